@@ -1,13 +1,23 @@
 package main
 
+import "time"
+
 // AvailabilityData coming from ILS Connector
 type AvailabilityData struct {
 	Availability struct {
 		ID             string            `json:"title_id"`
 		Display        map[string]string `json:"display"`
 		Items          []*Item           `json:"items"`
+		Summary        *HoldingsSummary  `json:"summary,omitempty"`
+		TotalItems     int               `json:"total_items,omitempty"`
+		Groups         []*ItemGroup      `json:"groups,omitempty"`
 		RequestOptions []RequestOption   `json:"request_options"`
 		BoundWith      []BoundWithItem   `json:"bound_with"`
+		LastUpdated    *time.Time        `json:"last_updated,omitempty"`
+		Stale          bool              `json:"stale,omitempty"`
+		Degraded       bool              `json:"degraded,omitempty"`
+		DegradedReason string            `json:"degraded_reason,omitempty"`
+		AnonStatus     []string          `json:"anon_availability,omitempty"`
 	} `json:"availability"`
 }
 
@@ -26,6 +36,45 @@ type Item struct {
 	Volume            string `json:"volume"`
 	SCNotes           string `json:"special_collections_location"`
 	Map               Map    `json:"map"`
+	StatusUnverified  bool   `json:"status_unverified,omitempty"`
+	Status            string `json:"status"`
+	StatusLabel       string `json:"status_label"`
+	DueDate           string `json:"due_date,omitempty"`
+	ExpectedAvailable string `json:"expected_available,omitempty"`
+	HoldCount         int    `json:"hold_count,omitempty"`
+}
+
+// ItemGroup is a run of items in the same volume or year. Offset is the position of its first item.
+type ItemGroup struct {
+	Label     string `json:"label"`
+	Offset    int    `json:"offset"`
+	Count     int    `json:"count"`
+	Available int    `json:"available"`
+}
+
+// HoldingsSummary rolls up item availability by library and location
+type HoldingsSummary struct {
+	Available    int               `json:"available"`
+	Total        int               `json:"total"`
+	Label        string            `json:"label"`
+	BestLocation *LocationSummary  `json:"best_location,omitempty"`
+	Libraries    []*LibrarySummary `json:"libraries"`
+}
+
+// LibrarySummary counts the available and total items at a library, and at each of its locations
+type LibrarySummary struct {
+	Library   string             `json:"library"`
+	Available int                `json:"available"`
+	Total     int                `json:"total"`
+	Locations []*LocationSummary `json:"locations"`
+}
+
+// LocationSummary counts the available and total items at one location of a library
+type LocationSummary struct {
+	Library   string `json:"library"`
+	Location  string `json:"location"`
+	Available int    `json:"available"`
+	Total     int    `json:"total"`
 }
 
 // Map contains a URL and label for an item location map
@@ -3,7 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 
@@ -16,39 +16,61 @@ import (
 func (svc *ServiceContext) authMiddleware(c *gin.Context) {
 	tokenStr, err := getBearerToken(c.Request.Header.Get("Authorization"))
 	if err != nil {
-		log.Printf("Authentication failed: [%s]", err.Error())
+		slog.WarnContext(c.Request.Context(), "authentication failed", "error", err.Error())
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
 
 	if tokenStr == "undefined" {
-		log.Printf("Authentication failed; bearer token is undefined")
+		slog.WarnContext(c.Request.Context(), "authentication failed; bearer token is undefined")
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
 
-	log.Printf("Validating JWT auth token...")
 	v4Claims, jwtErr := v4jwt.Validate(tokenStr, svc.JWTKey)
 	if jwtErr != nil {
-		log.Printf("JWT signature for %s is invalid: %s", tokenStr, jwtErr.Error())
+		slog.WarnContext(c.Request.Context(), "JWT signature is invalid", "error", jwtErr.Error())
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
 
 	// add the parsed claims and signed JWT string to the request context so other handlers can access it.
+	// The user ID is added to the request context so it is included in every log for the request.
 	c.Set("jwt", tokenStr)
 	c.Set("claims", v4Claims)
-	log.Printf("got bearer token: [%s]: %+v", tokenStr, v4Claims)
+	c.Request = c.Request.WithContext(withUserID(c.Request.Context(), v4Claims.UserID))
+	slog.InfoContext(c.Request.Context(), "authenticated", "role", v4Claims.Role.String())
 	c.Next()
 }
 
+// staffMiddleware ensures the signed in user is staff. It must be used after authMiddleware
+func (svc *ServiceContext) staffMiddleware(c *gin.Context) {
+	claims, err := getJWTClaims(c)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "staff authorization failed", "error", err.Error())
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	if isStaff(claims) == false {
+		slog.WarnContext(c.Request.Context(), "staff authorization failed", "role", claims.Role.String())
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	c.Next()
+}
+
+// isStaff checks if the claims belong to a staff member or admin
+func isStaff(claims *v4jwt.V4Claims) bool {
+	return claims.Role == v4jwt.Staff || claims.Role == v4jwt.Admin
+}
+
 // getBearerToken is a helper to extract the token from headers
 func getBearerToken(authorization string) (string, error) {
 	components := strings.Split(strings.Join(strings.Fields(authorization), " "), " ")
 
 	// must have two components, the first of which is "Bearer", and the second a non-empty token
 	if len(components) != 2 || components[0] != "Bearer" || components[1] == "" {
-		return "", fmt.Errorf("Invalid Authorization header: [%s]", authorization)
+		return "", fmt.Errorf("Invalid Authorization header")
 	}
 
 	return components[1], nil
@@ -1,54 +1,159 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"reflect"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/go-querystring/query"
+	"github.com/uvalib/virgo4-jwt/v4jwt"
 )
 
 // getAvailability uses ILS Connector V4 API /availability to get details for a Document
 func (svc *ServiceContext) getAvailability(c *gin.Context) {
-	rawID := c.Param("id")
+	itemQ, err := parseItemQuery(c)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "invalid item query", "error", err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	v4Claims, _ := getJWTClaims(c)
+	availResp, _, _ := svc.availabilityForRequest(c, v4Claims, nil)
+	if availResp == nil {
+		return
+	}
+	if itemQ.isSet() {
+		itemQ.apply(availResp)
+	}
+	c.JSON(http.StatusOK, availResp)
+}
 
-	titleID := strings.TrimSpace(rawID)
+// availabilityForRequest gets the processed availability for the title in the request path, along with
+// its solr document and the ILS response status. If this fails, the error response has already been sent
+// and the availability is nil. Malformed IDs are rejected with a 400 before any upstream request. If trace
+// is not nil, every rule decision is recorded in it.
+func (svc *ServiceContext) availabilityForRequest(c *gin.Context, v4Claims *v4jwt.V4Claims, trace *ruleTrace) (*AvailabilityData, *SolrDocument, int) {
+	ctx := c.Request.Context()
+	rawID := c.Param("id")
+	titleID, err := validateTitleID(rawID)
+	if err != nil {
+		slog.ErrorContext(ctx, "invalid title id", "title_id", rawID, "error", err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return nil, nil, 0
+	}
 	if titleID != rawID {
-		log.Printf("WARNING: corrected suspicious ID: [%s] => [%s]", rawID, titleID)
+		slog.InfoContext(ctx, "trimmed whitespace from title id", "raw_id", rawID, "title_id", titleID)
 	}
 
-	log.Printf("Getting availability for %s with ILS Connector...", titleID)
+	slog.InfoContext(ctx, "getting availability", "title_id", titleID, "ils", svc.ILS.Name())
+	availResp, solrDoc, ilsErr := svc.lookupAvailability(ctx, titleID, c.GetString("jwt"), ilsCacheUser(v4Claims))
+
+	if ctx.Err() != nil {
+		slog.InfoContext(ctx, "client canceled availability request", "title_id", titleID)
+		c.AbortWithStatus(statusClientClosedRequest)
+		return nil, nil, 0
+	}
 
-	availabilityURL := fmt.Sprintf("%s/availability/%s", svc.ILSAPI, titleID)
-	bodyBytes, ilsErr := svc.ILSConnectorGet(availabilityURL, c.GetString("jwt"), svc.SlowHTTPClient)
 	if ilsErr != nil && ilsErr.StatusCode != 404 {
-		log.Printf("ERROR: ILS Connector failure: %+v", ilsErr)
-		c.String(ilsErr.StatusCode, "There was a problem retrieving availability. Please try again later.")
-		return
+		slog.ErrorContext(ctx, "ILS failure", "title_id", titleID, "status", ilsErr.StatusCode, "error", ilsErr.Message)
+		if solrDoc == nil || useDegradedAvailability(ilsErr) == false {
+			if ilsErr.StatusCode == 503 {
+				slog.ErrorContext(ctx, "ILS is offline", "title_id", titleID)
+				c.String(ilsErr.StatusCode, "Availability information is currently unavailable. Please try again later.")
+				return nil, nil, 0
+			}
+			c.String(ilsErr.StatusCode, "There was a problem retrieving availability. Please try again later.")
+			return nil, nil, 0
+		}
+		availResp = degradedAvailability(ctx, solrDoc, ilsErr)
 	}
 
-	if ilsErr != nil && ilsErr.StatusCode == 503 {
-		log.Printf("ERROR: Sirsi is offline")
-		c.String(ilsErr.StatusCode, "Availability information is currently unavailable. Please try again later.")
-		return
+	if availResp.Availability.ID == "" {
+		// ID not provided by ILS connector, add it now
+		availResp.Availability.ID = titleID
+	}
+
+	ilsStatus := http.StatusOK
+	if ilsErr != nil {
+		ilsStatus = ilsErr.StatusCode
 	}
+	svc.processAvailability(ctx, titleID, solrDoc, v4Claims, availResp, trace)
+	return availResp, solrDoc, ilsStatus
+}
 
-	//log.Printf("INFO: raw ilsconnector response: %s", bodyBytes)
+// lookupAvailability gets the ILS availability and solr document for a title. The lookups are independent
+// so they run at the same time, and both are canceled if the client goes away. The solr document is
+// still needed when the ILS fails; it is the source of degraded availability.
+func (svc *ServiceContext) lookupAvailability(ctx context.Context, titleID string, jwt string, cacheUser string) (*AvailabilityData, *SolrDocument, *RequestError) {
+	var solrDoc *SolrDocument
+	var solrWG sync.WaitGroup
+	solrWG.Add(1)
+	go func() {
+		defer solrWG.Done()
+		solrDoc = svc.getSolrDoc(ctx, titleID)
+	}()
+
+	availResp, ilsErr := svc.getILSAvailability(ctx, titleID, jwt, cacheUser)
+	solrWG.Wait()
+	return availResp, solrDoc, ilsErr
+}
 
-	// Convert from json
+// getILSAvailability gets the raw availability for a title from the ILS backend. A 404 is not considered
+// fatal; Non-Sirsi items may be found in other places and have availability. In this case the
+// returned error is the 404 and the availability data is empty, but usable. Responses are cached; cached
+// data is flagged with the time it was fetched, and stale if it is past its TTL. The ILS response depends on
+// the JWT it was requested with, so it is cached per user (see ilsCacheUser).
+func (svc *ServiceContext) getILSAvailability(ctx context.Context, titleID string, jwt string, cacheUser string) (*AvailabilityData, *RequestError) {
 	availResp := AvailabilityData{}
-	if err := json.Unmarshal(bodyBytes, &availResp); err != nil {
-		// Non-Sirsi Item may be found in other places and have availability
-		// ID not provided by ILS connector, add it now
-		availResp.Availability.ID = rawID
+	cacheKey := fmt.Sprintf("%s:%s", titleID, cacheUser)
+	result, ilsErr := svc.Cache.fetch(ctx, svc.ILSCache, cacheKey, func(ctx context.Context) ([]byte, *RequestError) {
+		ilsResp, ilsErr := svc.ILS.GetAvailability(ctx, titleID, jwt)
+		if ilsErr != nil {
+			return nil, ilsErr
+		}
+		respBytes, err := json.Marshal(ilsResp)
+		if err != nil {
+			return nil, &RequestError{StatusCode: http.StatusInternalServerError, Message: err.Error()}
+		}
+		return respBytes, nil
+	})
+	if ilsErr != nil {
+		return &availResp, ilsErr
+	}
+
+	if err := json.Unmarshal(result.Data, &availResp); err != nil {
+		slog.WarnContext(ctx, "unable to parse availability", "title_id", titleID, "error", err.Error())
+		availResp = AvailabilityData{}
+	}
+	if result.Cached {
+		lastUpdated := result.StoredAt
+		availResp.Availability.LastUpdated = &lastUpdated
+		availResp.Availability.Stale = result.Stale
+	}
+	return &availResp, nil
+}
+
+// ilsCacheUser is the part of the ILS cache key for the user a request is made for. Request options
+// and item details from the ILS depend on the user, so signed in users each have their own entries.
+// Public requests made with the guest JWT share a guest entry.
+func ilsCacheUser(v4Claims *v4jwt.V4Claims) string {
+	if v4Claims == nil || v4Claims.Role == v4jwt.Guest || v4Claims.UserID == "" {
+		return "guest"
 	}
+	return fmt.Sprintf("user:%s", v4Claims.UserID)
+}
 
+// processAvailability runs the request option pipeline against raw ILS availability for a title. If trace
+// is not nil, every rule decision is recorded in it.
+func (svc *ServiceContext) processAvailability(ctx context.Context, titleID string, solrDoc *SolrDocument, v4Claims *v4jwt.V4Claims, availResp *AvailabilityData, trace *ruleTrace) {
 	// Create a display mapping from item field to label. Localize at some point. Maybe.
 	availResp.Availability.Display = make(map[string]string)
 	availResp.Availability.Display["library"] = "Library"
@@ -56,70 +161,60 @@ func (svc *ServiceContext) getAvailability(c *gin.Context) {
 	availResp.Availability.Display["call_number"] = "Call Number"
 	availResp.Availability.Display["barcode"] = "Barcode"
 
-	solrDoc := svc.getSolrDoc(titleID)
-	if solrDoc != nil {
-		v4Claims, _ := getJWTClaims(c)
-		if v4Claims.HomeLibrary == "HEALTHSCI" {
-			svc.updateHSLScanOptions(solrDoc, &availResp)
-		}
-		if v4Claims.CanPlaceReserve {
-			svc.addStreamingVideoReserve(solrDoc, &availResp)
-		}
-
-		svc.appendAeonRequestOptions(solrDoc, &availResp)
-		svc.removeETASRequestOptions(titleID, solrDoc, &availResp)
+	svc.applyRequestRules(&ruleInput{Ctx: ctx, TitleID: titleID, Claims: v4Claims, SolrDoc: solrDoc, Result: availResp, Trace: trace})
+	for _, item := range availResp.Availability.Items {
+		normalizeItemStatus(item)
 	}
-	svc.addMapInfo(availResp.Availability.Items)
+	svc.addMapInfo(ctx, availResp.Availability.Items)
+	sortItems(availResp.Availability.Items)
+	availResp.Availability.Groups = groupVolumes(availResp.Availability.Items)
+	availResp.Availability.Summary = summarizeHoldings(availResp.Availability.Items)
+}
 
-	c.JSON(http.StatusOK, availResp)
+func (svc *ServiceContext) getSolrDoc(ctx context.Context, id string) *SolrDocument {
+	result, solrErr := svc.Cache.fetch(ctx, svc.SolrCache, id, func(ctx context.Context) ([]byte, *RequestError) {
+		return svc.fetchSolrDoc(ctx, id)
+	})
+	if solrErr != nil {
+		return nil
+	}
+	var solrDoc SolrDocument
+	if err := json.Unmarshal(result.Data, &solrDoc); err != nil {
+		slog.ErrorContext(ctx, "unable to parse cached solr document", "title_id", id, "error", err.Error())
+		return nil
+	}
+	return &solrDoc
 }
 
-func (svc *ServiceContext) getSolrDoc(id string) *SolrDocument {
+// fetchSolrDoc gets the solr document for an ID as JSON. A 404 error is returned if there is none.
+func (svc *ServiceContext) fetchSolrDoc(ctx context.Context, id string) ([]byte, *RequestError) {
 	fields := solrFieldList()
-	solrPath := fmt.Sprintf(`select?fl=%s,&q=id%%3A%s`, fields, id)
+	solrPath := fmt.Sprintf(`select?fl=%s,&q=%s`, fields, solrIDQuery([]string{id}))
 
-	respBytes, solrErr := svc.SolrGet(solrPath)
+	respBytes, solrErr := svc.SolrGet(ctx, solrPath)
 	if solrErr != nil {
-		log.Printf("ERROR: Solr request for Aeon info failed: %s", solrErr.Message)
+		slog.ErrorContext(ctx, "solr document request failed", "title_id", id, "status", solrErr.StatusCode, "error", solrErr.Message)
+		return nil, solrErr
 	}
 	var solrResp SolrResponse
 	if err := json.Unmarshal(respBytes, &solrResp); err != nil {
-		log.Printf("ERROR: Unable to parse solr response: %s.", err.Error())
+		slog.ErrorContext(ctx, "unable to parse solr response", "title_id", id, "error", err.Error())
+		return nil, &RequestError{StatusCode: http.StatusInternalServerError, Message: err.Error()}
 	}
-	if solrResp.Response.NumFound == 0 {
-		log.Printf("ERROR: no solr document found for %s", id)
-		return nil
+	if solrResp.Response.NumFound == 0 || len(solrResp.Response.Docs) == 0 {
+		slog.ErrorContext(ctx, "no solr document found", "title_id", id)
+		return nil, &RequestError{StatusCode: http.StatusNotFound, Message: fmt.Sprintf("%s not found", id)}
 	} else if solrResp.Response.NumFound > 1 {
-		log.Printf("WARNING: more than one record found for the id: %s", id)
-	}
-	solrDoc := solrResp.Response.Docs[0]
-	return &solrDoc
-}
-
-func (svc *ServiceContext) updateHSLScanOptions(solrDoc *SolrDocument, result *AvailabilityData) {
-	log.Printf("Updating scan options for HSL user")
-	// remove existing scan
-	for i, opt := range result.Availability.RequestOptions {
-		if opt.Type == "scan" {
-			result.Availability.RequestOptions = append(
-				result.Availability.RequestOptions[:i],
-				result.Availability.RequestOptions[i+1:]...)
-			break
-		}
+		slog.WarnContext(ctx, "more than one solr document found", "title_id", id)
 	}
-
-	hsScan := RequestOption{
-		Type:           "directLink",
-		Label:          "Request a scan",
-		SignInRequired: false,
-		Description:    "Select a portion of this item to be scanned.",
-		CreateURL:      openURLQuery(svc.HSILLiadURL, solrDoc),
-		ItemOptions:    make([]ItemOption, 0),
+	docBytes, err := json.Marshal(solrResp.Response.Docs[0])
+	if err != nil {
+		return nil, &RequestError{StatusCode: http.StatusInternalServerError, Message: err.Error()}
 	}
-	result.Availability.RequestOptions = append(result.Availability.RequestOptions, hsScan)
+	return docBytes, nil
 }
 
-func openURLQuery(baseURL string, doc *SolrDocument) string {
+func openURLQuery(ctx context.Context, baseURL string, doc *SolrDocument) string {
 	var req struct {
 		Action  string `url:"Action"`
 		Form    string `url:"Form"`
@@ -142,56 +237,14 @@ func openURLQuery(baseURL string, doc *SolrDocument) string {
 	req.Date = doc.PublicationDate
 	query, err := query.Values(req)
 	if err != nil {
-		log.Printf("ERROR: couldn't generate OpenURL: %s", err.Error())
+		slog.ErrorContext(ctx, "unable to generate OpenURL", "title_id", doc.ID, "error", err.Error())
 	}
 
 	return fmt.Sprintf("%s/illiad.dll?%s", baseURL, query.Encode())
 }
 
-// Adds option for course reserves video request for streaming video items
-// This could be Sirsi "Internet materials", Avalon, Swank, etc.
-func (svc *ServiceContext) addStreamingVideoReserve(solrDoc *SolrDocument, result *AvailabilityData) {
-
-	if (solrDoc.Pool[0] == "video" && contains(solrDoc.Location, "Internet materials")) ||
-		contains(solrDoc.Source, "Avalon") {
-
-		log.Printf("Adding streaming video reserve option")
-		option := RequestOption{
-			Type:             "videoReserve",
-			Label:            "Video reserve request",
-			SignInRequired:   true,
-			Description:      "Request a video reserve for streaming",
-			StreamingReserve: true,
-			ItemOptions:      []ItemOption{},
-		}
-		result.Availability.RequestOptions = append(result.Availability.RequestOptions, option)
-	}
-
-	return
-}
-
-// Appends Aeon request to availability response
-func (svc *ServiceContext) appendAeonRequestOptions(solrDoc *SolrDocument, result *AvailabilityData) {
-
-	processSCAvailabilityStored(result, solrDoc)
-
-	if !(contains(solrDoc.Library, "Special Collections")) {
-		return
-	}
-
-	aeonOption := RequestOption{
-		Type:           "aeon",
-		Label:          "Request this in Special Collections",
-		SignInRequired: false,
-		Description:    "",
-		CreateURL:      createAeonURL(solrDoc),
-		ItemOptions:    createAeonItemOptions(result, solrDoc),
-	}
-	result.Availability.RequestOptions = append(result.Availability.RequestOptions, aeonOption)
-}
-
 // processSCAvailabilityStored adds items stored in sc_availability_stored solr field to availability
-func processSCAvailabilityStored(result *AvailabilityData, doc *SolrDocument) {
+func processSCAvailabilityStored(ctx context.Context, result *AvailabilityData, doc *SolrDocument) {
 	// If this item has Stored SC data (ArchiveSpace)
 	if doc.SCAvailability == "" {
 		return
@@ -202,7 +255,7 @@ func processSCAvailabilityStored(result *AvailabilityData, doc *SolrDocument) {
 
 	var scItems []*Item
 	if err := json.Unmarshal([]byte(doc.SCAvailability), &scItems); err != nil {
-		log.Printf("Error parsing sc_availability_large_single: %+v", err)
+		slog.ErrorContext(ctx, "unable to parse sc_availability_large_single", "title_id", doc.ID, "error", err.Error())
 	}
 
 	for _, item := range scItems {
@@ -339,50 +392,6 @@ func createAeonURL(doc *SolrDocument) string {
 
 }
 
-// Appends Aeon request to availability response
-func (svc *ServiceContext) removeETASRequestOptions(id string, solrDoc *SolrDocument, result *AvailabilityData) {
-
-	// disable requesting for items available via ETAS *and* not in the public domain
-	if len(solrDoc.HathiETAS) > 0 && solrDoc.HathiETAS[0] == "etas" {
-		log.Printf("ETAS FOUND. Removing request options for %s", id)
-		hathiOption := RequestOption{
-			Type:           "directLink",
-			SignInRequired: false,
-			Description: "Use the link above to read this item online through the <a target=\"_blank\" href=\"https://www.library.virginia.edu/services/etas\">Emergency Temporary Access Service.</a>" +
-				"<p>Because of U.S. Copyright law, any item made available online through ETAS cannot be also physically circulated. Buttons above reflect any requests that can be made for this item. <a href=\"https://www.library.virginia.edu/news/covid-19/\" target=\"blank\">Read more about digital and physical access during COVID-19.</a></p>",
-		}
-		if len(solrDoc.URL) > 0 {
-			hathiOption.CreateURL = solrDoc.URL[0]
-			hathiOption.Label = "Read via HathiTrust"
-		}
-
-		// check options
-		holdID := -1
-		for i, v := range result.Availability.RequestOptions {
-			if v.Type == "hold" {
-				holdID = i
-			}
-		}
-
-		// Replace hold option with hathi button
-		if holdID != -1 {
-			result.Availability.RequestOptions[holdID] = hathiOption
-		} else {
-			// Append ETAS link even if there is not a hold button
-			result.Availability.RequestOptions = append(result.Availability.RequestOptions, hathiOption)
-		}
-
-		// Remove non-SC items
-		items := []*Item{}
-		for _, v := range result.Availability.Items {
-			if v.LibraryID == "SPEC-COLL" {
-				items = append(items, v)
-			}
-		}
-		result.Availability.Items = items
-	}
-}
-
 func contains(arr []string, str string) bool {
 	if len(arr) == 0 {
 		return false
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// upstreamLatency is the fixed response time of the fake ILS and Solr used by the lookup benchmarks
+const upstreamLatency = 20 * time.Millisecond
+
+// newTestUpstreams starts a fake ILS Connector and Solr that answer every request for u1 after a delay,
+// or when the request is canceled, and returns a service that uses them
+func newTestUpstreams(tb testing.TB, latency time.Duration) *ServiceContext {
+	tb.Helper()
+	delayed := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(latency):
+			case <-r.Context().Done():
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(body))
+		}
+	}
+	ils := httptest.NewServer(delayed(`{"availability":{"title_id":"u1","items":[{"barcode":"X1","on_shelf":true}]}}`))
+	solr := httptest.NewServer(delayed(`{"response":{"numFound":1,"docs":[{"id":"u1"}]}}`))
+	tb.Cleanup(ils.Close)
+	tb.Cleanup(solr.Close)
+
+	svc := &ServiceContext{HTTPClient: ils.Client(), FastHTTPClient: solr.Client(), SlowHTTPClient: ils.Client(),
+		Solr: SolrConfig{URL: solr.URL, Core: "test_core"}}
+	breakerCfg := BreakerConfig{Failures: 1000, Cooldown: time.Minute}
+	svc.ILSBreaker = newCircuitBreaker("test_ils", breakerCfg)
+	svc.SolrBreaker = newCircuitBreaker("test_solr", breakerCfg)
+	backend, err := newILSBackend(svc, &ServiceConfig{ILSBackend: "connector", ILSAPI: ils.URL})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	svc.ILS = backend
+	return svc
+}
+
+// BenchmarkLookupSequential is the lookup as it was before the ILS and Solr requests ran concurrently
+func BenchmarkLookupSequential(b *testing.B) {
+	svc := newTestUpstreams(b, upstreamLatency)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := svc.getILSAvailability(ctx, "u1", "jwt", "guest"); err != nil {
+			b.Fatal(err.Message)
+		}
+		if svc.getSolrDoc(ctx, "u1") == nil {
+			b.Fatal("no solr document")
+		}
+	}
+}
+
+func BenchmarkLookupConcurrent(b *testing.B) {
+	svc := newTestUpstreams(b, upstreamLatency)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, solrDoc, err := svc.lookupAvailability(ctx, "u1", "jwt", "guest")
+		if err != nil || solrDoc == nil {
+			b.Fatalf("lookup failed: %+v, %v", err, solrDoc)
+		}
+	}
+}
+
+func TestLookupConcurrent(t *testing.T) {
+	svc := newTestUpstreams(t, 200*time.Millisecond)
+	start := time.Now()
+	availResp, solrDoc, err := svc.lookupAvailability(context.Background(), "u1", "jwt", "guest")
+	if err != nil || solrDoc == nil || len(availResp.Availability.Items) != 1 {
+		t.Fatalf("lookup = %+v, %+v, %+v", availResp, solrDoc, err)
+	}
+	if elapsed := time.Since(start); elapsed >= 400*time.Millisecond {
+		t.Errorf("lookup took %s; the ILS and Solr requests did not overlap", elapsed)
+	}
+}
+
+// rulesFired is the number of times a request option rule has been applied
+func rulesFired(t *testing.T, rule string) float64 {
+	t.Helper()
+	var metric dto.Metric
+	if err := requestOptionRules.WithLabelValues(rule).Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetCounter().GetValue()
+}
+
+func TestCanceledAvailabilityRequest(t *testing.T) {
+	svc := newTestUpstreams(t, 10*time.Second)
+	// a rule that always applies shows if processAvailability ran
+	noUser := false
+	svc.Rules = &requestRules{Rules: []*requestRule{{Name: "test_cancel_probe", SolrOptional: true,
+		When: &ruleCondition{Claim: "userId", Present: &noUser}, Actions: []*ruleAction{{RemoveOption: "none"}}}}}
+	before := rulesFired(t, "test_cancel_probe")
+
+	router := gin.New()
+	router.GET("/item/:id", svc.getAvailability)
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/item/u1", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	start := time.Now()
+	go func() {
+		defer wg.Done()
+		router.ServeHTTP(w, req)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("canceled request took %s; upstream lookups were not canceled", elapsed)
+	}
+	if w.Code != statusClientClosedRequest {
+		t.Errorf("status = %d; want %d", w.Code, statusClientClosedRequest)
+	}
+	if fired := rulesFired(t, "test_cancel_probe") - before; fired != 0 {
+		t.Errorf("processAvailability ran %v time(s) for a canceled request", fired)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maximum number of simultaneous ILS Connector requests made for a single batch request
+const batchILSConcurrency = 8
+
+type batchItemError struct {
+	StatusCode int    `json:"status"`
+	Message    string `json:"message"`
+}
+
+// batchItemResult is the availability for one title in a batch. If the lookup
+// failed, only the error is populated
+type batchItemResult struct {
+	*AvailabilityData
+	Error *batchItemError `json:"error,omitempty"`
+}
+
+// getBatchAvailability gets availability for a list of title IDs in a single request. Response is a map
+// of title ID to availability (or an error for that ID)
+func (svc *ServiceContext) getBatchAvailability(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req struct {
+		Items []string `json:"items"`
+	}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		slog.ErrorContext(ctx, "unable to parse batch availability request", "error", err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// malformed IDs get a 400 in the response and are never sent to solr or the ILS
+	titleIDs := make([]string, 0)
+	invalid := make(map[string]*batchItemResult)
+	seen := make(map[string]bool)
+	for _, rawID := range req.Items {
+		rawID = strings.TrimSpace(rawID)
+		if rawID == "" || seen[rawID] {
+			continue
+		}
+		seen[rawID] = true
+		titleID, err := validateTitleID(rawID)
+		if err != nil {
+			slog.ErrorContext(ctx, "invalid title id in batch request", "title_id", rawID, "error", err.Error())
+			invalid[rawID] = &batchItemResult{Error: &batchItemError{StatusCode: http.StatusBadRequest, Message: err.Error()}}
+			continue
+		}
+		titleIDs = append(titleIDs, titleID)
+	}
+	if len(titleIDs) == 0 && len(invalid) > 0 {
+		c.JSON(http.StatusOK, invalid)
+		return
+	}
+	if len(titleIDs) == 0 {
+		c.String(http.StatusBadRequest, "at least one item is required")
+		return
+	}
+	if len(titleIDs) > svc.BatchLimit {
+		slog.ErrorContext(ctx, "batch availability request exceeds limit", "items", len(titleIDs), "limit", svc.BatchLimit)
+		c.String(http.StatusBadRequest, fmt.Sprintf("a maximum of %d items can be requested at once", svc.BatchLimit))
+		return
+	}
+	slog.InfoContext(ctx, "getting batch availability", "items", len(titleIDs))
+
+	// Solr docs come back in one request while the ILS Connector requests are in flight
+	var solrDocs map[string]*SolrDocument
+	var solrWG sync.WaitGroup
+	solrWG.Add(1)
+	go func() {
+		defer solrWG.Done()
+		solrDocs = svc.getSolrDocs(ctx, titleIDs)
+	}()
+
+	jwt := c.GetString("jwt")
+	v4Claims, _ := getJWTClaims(c)
+	cacheUser := ilsCacheUser(v4Claims)
+	ilsResults := make([]*AvailabilityData, len(titleIDs))
+	ilsErrors := make([]*RequestError, len(titleIDs))
+	sem := make(chan struct{}, batchILSConcurrency)
+	var ilsWG sync.WaitGroup
+lookups:
+	for idx, titleID := range titleIDs {
+		// no more lookups are started once the client goes away
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			break lookups
+		}
+		ilsWG.Add(1)
+		go func(idx int, titleID string) {
+			defer ilsWG.Done()
+			defer func() { <-sem }()
+			ilsResults[idx], ilsErrors[idx] = svc.getILSAvailability(ctx, titleID, jwt, cacheUser)
+		}(idx, titleID)
+	}
+	ilsWG.Wait()
+	solrWG.Wait()
+
+	if ctx.Err() != nil {
+		slog.InfoContext(ctx, "client canceled batch availability request", "items", len(titleIDs))
+		c.AbortWithStatus(statusClientClosedRequest)
+		return
+	}
+
+	out := invalid
+	for idx, titleID := range titleIDs {
+		ilsErr := ilsErrors[idx]
+		availResp := ilsResults[idx]
+		if ilsErr != nil && ilsErr.StatusCode != 404 {
+			slog.ErrorContext(ctx, "ILS failure", "title_id", titleID, "status", ilsErr.StatusCode, "error", ilsErr.Message)
+			if solrDocs[titleID] == nil || useDegradedAvailability(ilsErr) == false {
+				out[titleID] = &batchItemResult{Error: &batchItemError{StatusCode: ilsErr.StatusCode,
+					Message: "There was a problem retrieving availability. Please try again later."}}
+				continue
+			}
+			availResp = degradedAvailability(ctx, solrDocs[titleID], ilsErr)
+		}
+
+		if availResp.Availability.ID == "" {
+			availResp.Availability.ID = titleID
+		}
+		svc.processAvailability(ctx, titleID, solrDocs[titleID], v4Claims, availResp, nil)
+		out[titleID] = &batchItemResult{AvailabilityData: availResp}
+	}
+
+	c.JSON(http.StatusOK, out)
+}
+
+// getSolrDocs gets the solr documents for a list of IDs. Any that are not cached are fetched in one query.
+// The result is a map of ID to document; IDs with no document are not present in the map.
+func (svc *ServiceContext) getSolrDocs(ctx context.Context, ids []string) map[string]*SolrDocument {
+	out := make(map[string]*SolrDocument)
+	missing := make([]string, 0)
+	for _, id := range ids {
+		result, found := svc.Cache.cached(ctx, svc.SolrCache, id)
+		if found {
+			var doc SolrDocument
+			if err := json.Unmarshal(result.Data, &doc); err == nil {
+				out[id] = &doc
+				continue
+			}
+		}
+		missing = append(missing, id)
+	}
+	if len(missing) == 0 {
+		slog.InfoContext(ctx, "all solr documents are cached", "items", len(ids))
+		return out
+	}
+
+	fields := solrFieldList()
+	solrPath := fmt.Sprintf(`select?fl=%s,&q=%s&rows=%d`, fields, solrIDQuery(missing), len(missing))
+
+	respBytes, solrErr := svc.SolrGet(ctx, solrPath)
+	if solrErr != nil {
+		slog.ErrorContext(ctx, "solr batch request failed", "status", solrErr.StatusCode, "error", solrErr.Message)
+		return out
+	}
+	var solrResp SolrResponse
+	if err := json.Unmarshal(respBytes, &solrResp); err != nil {
+		slog.ErrorContext(ctx, "unable to parse solr response", "error", err.Error())
+		return out
+	}
+
+	for idx := range solrResp.Response.Docs {
+		doc := &solrResp.Response.Docs[idx]
+		if _, exists := out[doc.ID]; exists {
+			slog.WarnContext(ctx, "more than one solr document found", "title_id", doc.ID)
+			continue
+		}
+		out[doc.ID] = doc
+		if docBytes, err := json.Marshal(doc); err == nil {
+			svc.Cache.put(ctx, svc.SolrCache, doc.ID, docBytes)
+		}
+	}
+	slog.InfoContext(ctx, "found solr documents", "found", len(out), "items", len(ids))
+	return out
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// batchUpstreams is a fake ILS Connector and Solr for batch requests. The ILS knows every title; Solr has
+// documents for u1 and u2 only. Each ILS request is delayed by latency.
+type batchUpstreams struct {
+	lock        sync.Mutex
+	ilsRequests []string
+	solrQueries []string
+}
+
+// ils returns the title IDs the fake ILS has been asked for
+func (upstreams *batchUpstreams) ils() []string {
+	upstreams.lock.Lock()
+	defer upstreams.lock.Unlock()
+	return append([]string{}, upstreams.ilsRequests...)
+}
+
+func newBatchUpstreams(t *testing.T, latency time.Duration, batchLimit int) (*ServiceContext, *batchUpstreams) {
+	t.Helper()
+	upstreams := &batchUpstreams{}
+	ils := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		titleID := strings.TrimPrefix(r.URL.Path, "/availability/")
+		upstreams.lock.Lock()
+		upstreams.ilsRequests = append(upstreams.ilsRequests, titleID)
+		upstreams.lock.Unlock()
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"availability":{"title_id":"%s","items":[{"barcode":"X-%s","on_shelf":true}]}}`, titleID, titleID)
+	}))
+	solr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+		upstreams.lock.Lock()
+		upstreams.solrQueries = append(upstreams.solrQueries, q)
+		upstreams.lock.Unlock()
+		docs := make([]SolrDocument, 0)
+		for _, id := range strings.Split(strings.Trim(strings.TrimPrefix(q, "id:"), "()"), " OR ") {
+			if id == "u1" || id == "u2" {
+				docs = append(docs, SolrDocument{ID: id})
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"response": map[string]interface{}{"numFound": len(docs), "docs": docs}})
+	}))
+	t.Cleanup(ils.Close)
+	t.Cleanup(solr.Close)
+
+	svc := &ServiceContext{HTTPClient: ils.Client(), FastHTTPClient: solr.Client(), SlowHTTPClient: ils.Client(),
+		Solr: SolrConfig{URL: solr.URL, Core: "test_core"}, BatchLimit: batchLimit}
+	breakerCfg := BreakerConfig{Failures: 1000, Cooldown: time.Minute}
+	svc.ILSBreaker = newCircuitBreaker("test_ils", breakerCfg)
+	svc.SolrBreaker = newCircuitBreaker("test_solr", breakerCfg)
+	backend, err := newILSBackend(svc, &ServiceConfig{ILSBackend: "connector", ILSAPI: ils.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.ILS = backend
+	return svc, upstreams
+}
+
+// postBatch posts a batch availability request and returns the response
+func postBatch(svc *ServiceContext, ctx context.Context, items ...string) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.POST("/items", svc.getBatchAvailability)
+	body, _ := json.Marshal(map[string][]string{"items": items})
+	req := httptest.NewRequest("POST", "/items", strings.NewReader(string(body))).WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestBatchAvailability(t *testing.T) {
+	svc, upstreams := newBatchUpstreams(t, 0, 3)
+	w := postBatch(svc, context.Background(), "u1", " u1 ", "u2", "", "u1\x00", "u1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d; want 200 (%s)", w.Code, w.Body.String())
+	}
+	var resp map[string]batchItemResult
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp) != 3 {
+		t.Errorf("response has %d titles; want u1, u2 and the invalid ID", len(resp))
+	}
+	for _, titleID := range []string{"u1", "u2"} {
+		result := resp[titleID]
+		if result.Error != nil || result.AvailabilityData == nil || len(result.Availability.Items) != 1 ||
+			result.Availability.Items[0].Barcode != "X-"+titleID {
+			t.Errorf("%s = %+v; want its ILS availability", titleID, result)
+		}
+	}
+	if invalid := resp["u1\x00"]; invalid.Error == nil || invalid.Error.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid ID = %+v; want a 400 error", invalid)
+	}
+
+	ilsRequests := upstreams.ils()
+	sort.Strings(ilsRequests)
+	if strings.Join(ilsRequests, ",") != "u1,u2" {
+		t.Errorf("ILS requests = %v; want one for each distinct valid ID", ilsRequests)
+	}
+	if len(upstreams.solrQueries) != 1 || upstreams.solrQueries[0] != "id:(u1 OR u2)" {
+		t.Errorf("solr queries = %q; want one for u1 and u2", upstreams.solrQueries)
+	}
+}
+
+func TestBatchAvailabilityLimit(t *testing.T) {
+	svc, upstreams := newBatchUpstreams(t, 0, 2)
+	// duplicates and invalid IDs don't count toward the limit
+	if w := postBatch(svc, context.Background(), "u1", "u2", "u2", "u1\x00"); w.Code != http.StatusOK {
+		t.Errorf("status = %d; want 200 for two distinct valid IDs", w.Code)
+	}
+	before := len(upstreams.ils())
+	w := postBatch(svc, context.Background(), "u1", "u2", "u3")
+	if w.Code != http.StatusBadRequest || strings.Contains(w.Body.String(), "maximum of 2 items") == false {
+		t.Errorf("status = %d, body = %s; want 400 over the limit", w.Code, w.Body.String())
+	}
+	if ilsRequests := upstreams.ils(); len(ilsRequests) != before {
+		t.Errorf("ILS requests = %v; want none for a request over the limit", ilsRequests[before:])
+	}
+}
+
+func TestBatchAvailabilityNoItems(t *testing.T) {
+	svc, _ := newBatchUpstreams(t, 0, 2)
+	if w := postBatch(svc, context.Background(), " ", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d; want 400 with no items", w.Code)
+	}
+	w := postBatch(svc, context.Background(), "u1\x00")
+	var resp map[string]batchItemResult
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || len(resp) != 1 || resp["u1\x00"].Error == nil {
+		t.Errorf("status = %d, body = %s; want 200 with the invalid ID error", w.Code, w.Body.String())
+	}
+}
+
+func TestBatchAvailabilitySolrMiss(t *testing.T) {
+	svc, _ := newBatchUpstreams(t, 0, 3)
+	docs := svc.getSolrDocs(context.Background(), []string{"u1", "u9"})
+	if len(docs) != 1 || docs["u1"] == nil {
+		t.Errorf("solr docs = %v; want only u1", docs)
+	}
+
+	// a title with no solr document still gets its ILS availability
+	w := postBatch(svc, context.Background(), "u9")
+	var resp map[string]batchItemResult
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp["u9"].AvailabilityData == nil || len(resp["u9"].Availability.Items) != 1 {
+		t.Errorf("status = %d, body = %s; want the ILS availability for u9", w.Code, w.Body.String())
+	}
+}
+
+func TestGetSolrDocsCached(t *testing.T) {
+	svc, upstreams := newBatchUpstreams(t, 0, 3)
+	svc.Cache = &responseCache{store: newMemoryCache(10), refreshing: make(map[string]bool)}
+	svc.SolrCache = cacheSource{Name: "solr", TTL: time.Minute}
+	svc.getSolrDocs(context.Background(), []string{"u1"})
+	docs := svc.getSolrDocs(context.Background(), []string{"u1", "u2"})
+	if len(docs) != 2 {
+		t.Errorf("solr docs = %v; want u1 and u2", docs)
+	}
+	if len(upstreams.solrQueries) != 2 || upstreams.solrQueries[1] != "id:u2" {
+		t.Errorf("solr queries = %q; want the second to ask only for u2", upstreams.solrQueries)
+	}
+}
+
+func TestCanceledBatchAvailability(t *testing.T) {
+	svc, upstreams := newBatchUpstreams(t, 10*time.Second, 50)
+	items := make([]string, 0)
+	for i := 0; i < 3*batchILSConcurrency; i++ {
+		items = append(items, fmt.Sprintf("u%d", i))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	w := postBatch(svc, ctx, items...)
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("canceled batch took %s; upstream lookups were not canceled", elapsed)
+	}
+	if w.Code != statusClientClosedRequest {
+		t.Errorf("status = %d; want %d", w.Code, statusClientClosedRequest)
+	}
+	if started := len(upstreams.ils()); started > batchILSConcurrency {
+		t.Errorf("%d ILS requests were made; want no more started after the cancel", started)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// circuit breaker states
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// circuitBreaker stops calls to an upstream service that keeps failing, so requests fail fast instead of
+// each one waiting out the full client timeout. After Failures failed calls in a row the breaker opens and
+// calls are rejected with a 503. Once Cooldown has passed a single probe call is let through (half open);
+// if it works the breaker closes, if not it opens again. GET calls that fail with a gateway error are
+// retried with jittered backoff before they count as a failure.
+type circuitBreaker struct {
+	Name     string
+	Config   BreakerConfig
+	lock     sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+	now      func() time.Time
+}
+
+func newCircuitBreaker(name string, cfg BreakerConfig) *circuitBreaker {
+	cb := &circuitBreaker{Name: name, Config: cfg, state: breakerClosed, now: time.Now}
+	breakerState.WithLabelValues(name).Set(0)
+	return cb
+}
+
+// call makes a request to the upstream through the breaker. The request is retried if it is a GET and it
+// failed in a way that may work on a second try.
+func (cb *circuitBreaker) call(ctx context.Context, method string, request func() *RequestError) *RequestError {
+	probe, allowed := cb.allow()
+	if allowed == false {
+		breakerRejections.WithLabelValues(cb.Name).Inc()
+		return &RequestError{StatusCode: http.StatusServiceUnavailable,
+			Message: fmt.Sprintf("%s is unavailable; circuit breaker is open", cb.Name)}
+	}
+
+	err := request()
+	for attempt := 1; err != nil && probe == false && method == "GET" && attempt <= cb.Config.Retries && isRetryable(err); attempt++ {
+		wait := retryBackoff(cb.Config.RetryWait, attempt)
+		slog.InfoContext(ctx, "upstream request failed; retry", "upstream", cb.Name, "method", method, "status", err.StatusCode,
+			"attempt", attempt, "retries", cb.Config.Retries, "wait", wait.String())
+		select {
+		case <-ctx.Done():
+			cb.done(ctx, probe, err)
+			return err
+		case <-time.After(wait):
+		}
+		upstreamRetries.WithLabelValues(cb.Name).Inc()
+		err = request()
+	}
+	cb.done(ctx, probe, err)
+	return err
+}
+
+// State is the current breaker state; closed, open or half_open
+func (cb *circuitBreaker) State() string {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if cb.state == breakerOpen && cb.now().Sub(cb.openedAt) >= cb.Config.Cooldown {
+		return breakerHalfOpen
+	}
+	return cb.state
+}
+
+// allow reports if a call can be made now, and if that call is the half open probe
+func (cb *circuitBreaker) allow() (bool, bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	switch cb.state {
+	case breakerClosed:
+		return false, true
+	case breakerOpen:
+		if cb.now().Sub(cb.openedAt) < cb.Config.Cooldown {
+			return false, false
+		}
+		cb.setState(breakerHalfOpen)
+	}
+	if cb.probing {
+		return false, false
+	}
+	cb.probing = true
+	return true, true
+}
+
+// done records the outcome of a call. Errors that say nothing about the health of the upstream, like
+// a 404 or a canceled request, count as success.
+func (cb *circuitBreaker) done(ctx context.Context, probe bool, err *RequestError) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if probe {
+		cb.probing = false
+	}
+	if err == nil || isUpstreamFailure(err) == false {
+		cb.failures = 0
+		if cb.state != breakerClosed && probe {
+			slog.InfoContext(ctx, "circuit breaker closed", "upstream", cb.Name)
+			cb.setState(breakerClosed)
+		}
+		return
+	}
+
+	cb.failures++
+	if (cb.state == breakerClosed && cb.failures >= cb.Config.Failures) || probe {
+		slog.WarnContext(ctx, "circuit breaker open", "upstream", cb.Name, "cooldown", cb.Config.Cooldown.String(),
+			"failures", cb.failures, "status", err.StatusCode, "error", err.Message)
+		cb.openedAt = cb.now()
+		cb.setState(breakerOpen)
+	}
+}
+
+func (cb *circuitBreaker) setState(state string) {
+	cb.state = state
+	value := map[string]float64{breakerClosed: 0, breakerHalfOpen: 1, breakerOpen: 2}[state]
+	breakerState.WithLabelValues(cb.Name).Set(value)
+}
+
+// isUpstreamFailure is true for errors that mean the upstream is down or overloaded
+func isUpstreamFailure(err *RequestError) bool {
+	return err.StatusCode >= 500 || err.StatusCode == http.StatusRequestTimeout ||
+		err.StatusCode == http.StatusTooManyRequests
+}
+
+// isRetryable is true for errors that are likely to go away on a second try. Timeouts are not retried;
+// with the 30 second ILS timeout a retry would only make the caller wait longer.
+func isRetryable(err *RequestError) bool {
+	return err.StatusCode == http.StatusBadGateway || err.StatusCode == http.StatusServiceUnavailable ||
+		err.StatusCode == http.StatusGatewayTimeout
+}
+
+// retryBackoff doubles the wait for each attempt and picks a random time up to that ("full jitter")
+// so retries from many requests do not all land at once
+func retryBackoff(base time.Duration, attempt int) time.Duration {
+	ceiling := base << uint(attempt-1)
+	return time.Duration(rand.Int63n(int64(ceiling))) + time.Millisecond
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testClock is a settable clock for the circuit breaker
+type testClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func (clock *testClock) Now() time.Time {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	return clock.now
+}
+
+func (clock *testClock) advance(by time.Duration) {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	clock.now = clock.now.Add(by)
+}
+
+func newTestBreaker(t *testing.T, cfg BreakerConfig) (*circuitBreaker, *testClock) {
+	t.Helper()
+	clock := &testClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	cb := newCircuitBreaker("test_"+t.Name(), cfg)
+	cb.now = clock.Now
+	return cb, clock
+}
+
+// upstream is a fake upstream request that fails with status, if it is set, and counts its calls
+type upstream struct {
+	lock   sync.Mutex
+	calls  int
+	status int
+}
+
+func (up *upstream) request() *RequestError {
+	up.lock.Lock()
+	defer up.lock.Unlock()
+	up.calls++
+	if up.status != 0 {
+		return &RequestError{StatusCode: up.status, Message: http.StatusText(up.status)}
+	}
+	return nil
+}
+
+func TestBreakerOpensAfterFailures(t *testing.T) {
+	cb, _ := newTestBreaker(t, BreakerConfig{Failures: 3, Cooldown: time.Minute})
+	up := &upstream{status: http.StatusInternalServerError}
+	ctx := context.Background()
+	for i := 1; i <= 3; i++ {
+		if err := cb.call(ctx, "GET", up.request); err == nil || err.StatusCode != http.StatusInternalServerError {
+			t.Fatalf("call %d err = %+v; want the upstream 500", i, err)
+		}
+		want := breakerClosed
+		if i == 3 {
+			want = breakerOpen
+		}
+		if cb.State() != want {
+			t.Errorf("state after %d failures = %s; want %s", i, cb.State(), want)
+		}
+	}
+
+	err := cb.call(ctx, "GET", up.request)
+	if err == nil || err.StatusCode != http.StatusServiceUnavailable || up.calls != 3 {
+		t.Errorf("open breaker call err = %+v, upstream calls = %d; want a 503 without calling upstream", err, up.calls)
+	}
+}
+
+func TestBreakerSuccessResetsFailures(t *testing.T) {
+	cb, _ := newTestBreaker(t, BreakerConfig{Failures: 2, Cooldown: time.Minute})
+	up := &upstream{}
+	ctx := context.Background()
+	for _, status := range []int{http.StatusBadGateway, 0, http.StatusBadGateway, 0, http.StatusBadGateway} {
+		up.status = status
+		cb.call(ctx, "POST", up.request)
+	}
+	if cb.State() != breakerClosed {
+		t.Errorf("state = %s; failures that are not in a row should not open the breaker", cb.State())
+	}
+}
+
+func TestBreakerIgnoresClientErrors(t *testing.T) {
+	cb, _ := newTestBreaker(t, BreakerConfig{Failures: 1, Cooldown: time.Minute})
+	for _, status := range []int{http.StatusNotFound, statusClientClosedRequest, http.StatusUnauthorized, http.StatusBadRequest} {
+		up := &upstream{status: status}
+		if err := cb.call(context.Background(), "GET", up.request); err == nil || err.StatusCode != status {
+			t.Errorf("err = %+v; want %d", err, status)
+		}
+		if cb.State() != breakerClosed {
+			t.Errorf("state after a %d = %s; want closed", status, cb.State())
+		}
+	}
+}
+
+func TestBreakerHalfOpenProbe(t *testing.T) {
+	cb, clock := newTestBreaker(t, BreakerConfig{Failures: 1, Cooldown: time.Minute})
+	ctx := context.Background()
+	up := &upstream{status: http.StatusServiceUnavailable}
+	cb.call(ctx, "GET", up.request)
+	if cb.State() != breakerOpen {
+		t.Fatalf("state = %s; want open", cb.State())
+	}
+
+	clock.advance(59 * time.Second)
+	if cb.State() != breakerOpen {
+		t.Errorf("state before the cooldown = %s; want open", cb.State())
+	}
+	clock.advance(time.Second)
+	if cb.State() != breakerHalfOpen {
+		t.Errorf("state after the cooldown = %s; want half_open", cb.State())
+	}
+
+	// a failed probe opens the breaker for another cooldown
+	cb.call(ctx, "GET", up.request)
+	if cb.State() != breakerOpen || up.calls != 2 {
+		t.Errorf("state after a failed probe = %s, upstream calls = %d; want open after one probe", cb.State(), up.calls)
+	}
+	clock.advance(30 * time.Second)
+	if err := cb.call(ctx, "GET", up.request); err == nil || up.calls != 2 {
+		t.Errorf("call during the new cooldown err = %+v, upstream calls = %d; want rejected", err, up.calls)
+	}
+
+	// a successful probe closes it
+	clock.advance(30 * time.Second)
+	up.status = 0
+	if err := cb.call(ctx, "GET", up.request); err != nil || cb.State() != breakerClosed {
+		t.Errorf("probe err = %+v, state = %s; want closed", err, cb.State())
+	}
+}
+
+func TestBreakerSingleProbe(t *testing.T) {
+	cb, clock := newTestBreaker(t, BreakerConfig{Failures: 1, Cooldown: time.Minute})
+	ctx := context.Background()
+	cb.call(ctx, "GET", (&upstream{status: http.StatusBadGateway}).request)
+	clock.advance(time.Minute)
+
+	started := make(chan bool)
+	release := make(chan bool)
+	probeDone := make(chan *RequestError)
+	go func() {
+		probeDone <- cb.call(ctx, "GET", func() *RequestError {
+			started <- true
+			<-release
+			return nil
+		})
+	}()
+	<-started
+
+	up := &upstream{}
+	if err := cb.call(ctx, "GET", up.request); err == nil || err.StatusCode != http.StatusServiceUnavailable || up.calls != 0 {
+		t.Errorf("call during the probe err = %+v, upstream calls = %d; want rejected", err, up.calls)
+	}
+	close(release)
+	if err := <-probeDone; err != nil {
+		t.Errorf("probe err = %+v", err)
+	}
+	if err := cb.call(ctx, "GET", up.request); err != nil || up.calls != 1 {
+		t.Errorf("call after the probe err = %+v, upstream calls = %d; want it to go through", err, up.calls)
+	}
+}
+
+func TestBreakerRetries(t *testing.T) {
+	cfg := BreakerConfig{Failures: 10, Cooldown: time.Minute, Retries: 2, RetryWait: time.Millisecond}
+	tests := []struct {
+		method string
+		status int
+		calls  int
+	}{
+		{"GET", http.StatusBadGateway, 3},
+		{"GET", http.StatusServiceUnavailable, 3},
+		{"GET", http.StatusGatewayTimeout, 3},
+		{"GET", http.StatusRequestTimeout, 1},
+		{"GET", http.StatusInternalServerError, 1},
+		{"GET", http.StatusNotFound, 1},
+		{"POST", http.StatusBadGateway, 1},
+		{"POST", http.StatusServiceUnavailable, 1},
+	}
+	for _, test := range tests {
+		cb, _ := newTestBreaker(t, cfg)
+		up := &upstream{status: test.status}
+		cb.call(context.Background(), test.method, up.request)
+		if up.calls != test.calls {
+			t.Errorf("%s with %d made %d calls; want %d", test.method, test.status, up.calls, test.calls)
+		}
+	}
+}
+
+func TestBreakerRetryCanceled(t *testing.T) {
+	cb, _ := newTestBreaker(t, BreakerConfig{Failures: 10, Cooldown: time.Minute, Retries: 2, RetryWait: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	up := &upstream{status: http.StatusBadGateway}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if err := cb.call(ctx, "GET", up.request); err == nil || up.calls != 1 {
+		t.Errorf("err = %+v, calls = %d; want the first failure returned when canceled during the retry wait", err, up.calls)
+	}
+}
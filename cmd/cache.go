@@ -0,0 +1,271 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// cacheEntry is a cached upstream response and the time it was fetched
+type cacheEntry struct {
+	Data     []byte    `json:"data"`
+	StoredAt time.Time `json:"storedAt"`
+}
+
+// cacheStore is the backing storage for cached responses. Entries are retained for
+// the supplied duration; whether they are still fresh is decided by the responseCache. The context is
+// only used for logging; a store must not give up because the request was canceled.
+type cacheStore interface {
+	Get(ctx context.Context, key string) (*cacheEntry, bool)
+	Set(ctx context.Context, key string, entry *cacheEntry, retain time.Duration)
+}
+
+// cacheSource is the caching policy for one upstream source
+type cacheSource struct {
+	Name string
+	// TTL is how long a response is fresh
+	TTL time.Duration
+	// Revalidate is how long past the TTL a response is served while it is refreshed in the background
+	Revalidate time.Duration
+	// StaleIfError is how long past the TTL a response is served when the upstream is unavailable
+	StaleIfError time.Duration
+}
+
+func (src cacheSource) retention() time.Duration {
+	if src.StaleIfError > src.Revalidate {
+		return src.TTL + src.StaleIfError
+	}
+	return src.TTL + src.Revalidate
+}
+
+// cacheFetcher gets a response from the upstream source when it is not in the cache
+type cacheFetcher func(ctx context.Context) ([]byte, *RequestError)
+
+// cacheResult is the data returned from a cached fetch
+type cacheResult struct {
+	Data     []byte
+	StoredAt time.Time
+	Cached   bool
+	Stale    bool
+}
+
+// responseCache adds stale-while-revalidate and stale-if-error on top of a cacheStore
+type responseCache struct {
+	store      cacheStore
+	lock       sync.Mutex
+	refreshing map[string]bool
+}
+
+// newResponseCache creates the cache selected in the config. A nil store means caching is off.
+func newResponseCache(cfg CacheConfig) (*responseCache, error) {
+	rc := responseCache{refreshing: make(map[string]bool)}
+	switch cfg.Type {
+	case "none":
+		slog.Info("response caching is disabled")
+	case "memory":
+		slog.Info("using in-memory response cache", "entries", cfg.Size)
+		rc.store = newMemoryCache(cfg.Size)
+	case "redis":
+		slog.Info("using redis response cache", "redis", redactURL(cfg.RedisURL))
+		store, err := newRedisCache(cfg.RedisURL)
+		if err != nil {
+			return nil, err
+		}
+		rc.store = store
+	default:
+		return nil, fmt.Errorf("%s is not a supported cache type", cfg.Type)
+	}
+	return &rc, nil
+}
+
+// fetch returns the cached response for a key if it is fresh. A response in the revalidate window is
+// returned immediately and refreshed in the background. Otherwise, the fetcher is called and its result cached.
+// If the upstream is unavailable (503), a response in the stale-if-error window is returned instead of the error.
+// The fetcher is called with the request context, except for background refreshes which must outlive the request.
+func (rc *responseCache) fetch(ctx context.Context, src cacheSource, key string, fetcher cacheFetcher) (*cacheResult, *RequestError) {
+	if rc == nil || rc.store == nil {
+		data, err := fetcher(ctx)
+		return &cacheResult{Data: data, StoredAt: time.Now()}, err
+	}
+
+	cacheKey := fmt.Sprintf("%s:%s", src.Name, key)
+	entry, found := rc.store.Get(ctx, cacheKey)
+	if found {
+		age := time.Since(entry.StoredAt)
+		if age < src.TTL {
+			return &cacheResult{Data: entry.Data, StoredAt: entry.StoredAt, Cached: true}, nil
+		}
+		if age < src.TTL+src.Revalidate {
+			slog.InfoContext(ctx, "cached response is stale; serve cached copy and revalidate", "key", cacheKey)
+			go rc.revalidate(context.WithoutCancel(ctx), src, cacheKey, fetcher)
+			return &cacheResult{Data: entry.Data, StoredAt: entry.StoredAt, Cached: true, Stale: true}, nil
+		}
+	}
+
+	data, reqErr := fetcher(ctx)
+	if reqErr == nil {
+		entry := cacheEntry{Data: data, StoredAt: time.Now()}
+		rc.store.Set(ctx, cacheKey, &entry, src.retention())
+		return &cacheResult{Data: data, StoredAt: entry.StoredAt}, nil
+	}
+
+	if reqErr.StatusCode == http.StatusServiceUnavailable && found && time.Since(entry.StoredAt) < src.TTL+src.StaleIfError {
+		slog.WarnContext(ctx, "upstream is unavailable; serve cached copy", "upstream", src.Name, "stored_at", entry.StoredAt.Format(time.RFC3339))
+		return &cacheResult{Data: entry.Data, StoredAt: entry.StoredAt, Cached: true, Stale: true}, nil
+	}
+	return nil, reqErr
+}
+
+// cached returns a fresh cached response for a key, if there is one
+func (rc *responseCache) cached(ctx context.Context, src cacheSource, key string) (*cacheResult, bool) {
+	if rc == nil || rc.store == nil {
+		return nil, false
+	}
+	entry, found := rc.store.Get(ctx, fmt.Sprintf("%s:%s", src.Name, key))
+	if !found || time.Since(entry.StoredAt) >= src.TTL {
+		return nil, false
+	}
+	return &cacheResult{Data: entry.Data, StoredAt: entry.StoredAt, Cached: true}, true
+}
+
+// put adds a response to the cache
+func (rc *responseCache) put(ctx context.Context, src cacheSource, key string, data []byte) {
+	if rc == nil || rc.store == nil {
+		return
+	}
+	rc.store.Set(ctx, fmt.Sprintf("%s:%s", src.Name, key), &cacheEntry{Data: data, StoredAt: time.Now()}, src.retention())
+}
+
+// revalidate refreshes a cached response. Only one refresh of a key runs at a time. The context carries the
+// log values of the request that found the stale response, but is never canceled.
+func (rc *responseCache) revalidate(ctx context.Context, src cacheSource, cacheKey string, fetcher cacheFetcher) {
+	rc.lock.Lock()
+	if rc.refreshing[cacheKey] {
+		rc.lock.Unlock()
+		return
+	}
+	rc.refreshing[cacheKey] = true
+	rc.lock.Unlock()
+
+	defer func() {
+		rc.lock.Lock()
+		delete(rc.refreshing, cacheKey)
+		rc.lock.Unlock()
+	}()
+
+	data, reqErr := fetcher(ctx)
+	if reqErr != nil {
+		slog.WarnContext(ctx, "unable to revalidate cached response", "key", cacheKey, "status", reqErr.StatusCode, "error", reqErr.Message)
+		return
+	}
+	rc.store.Set(ctx, cacheKey, &cacheEntry{Data: data, StoredAt: time.Now()}, src.retention())
+}
+
+// memoryCache is a size limited, least recently used cacheStore
+type memoryCache struct {
+	size    int
+	lock    sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type memoryCacheItem struct {
+	key       string
+	entry     *cacheEntry
+	expiresAt time.Time
+}
+
+func newMemoryCache(size int) *memoryCache {
+	return &memoryCache{size: size, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+func (mc *memoryCache) Get(ctx context.Context, key string) (*cacheEntry, bool) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	elem, found := mc.entries[key]
+	if !found {
+		return nil, false
+	}
+	item := elem.Value.(*memoryCacheItem)
+	if time.Now().After(item.expiresAt) {
+		mc.lru.Remove(elem)
+		delete(mc.entries, key)
+		return nil, false
+	}
+	mc.lru.MoveToFront(elem)
+	return item.entry, true
+}
+
+func (mc *memoryCache) Set(ctx context.Context, key string, entry *cacheEntry, retain time.Duration) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	item := &memoryCacheItem{key: key, entry: entry, expiresAt: time.Now().Add(retain)}
+	if elem, found := mc.entries[key]; found {
+		elem.Value = item
+		mc.lru.MoveToFront(elem)
+		return
+	}
+	mc.entries[key] = mc.lru.PushFront(item)
+	for mc.lru.Len() > mc.size {
+		oldest := mc.lru.Back()
+		mc.lru.Remove(oldest)
+		delete(mc.entries, oldest.Value.(*memoryCacheItem).key)
+	}
+}
+
+// redisCache is a cacheStore backed by redis. Redis failures are logged and treated as a cache miss.
+type redisCache struct {
+	client *redis.Client
+}
+
+func newRedisCache(redisURL string) (*redisCache, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %s", err.Error())
+	}
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("unable to connect to redis: %s", err.Error())
+	}
+	return &redisCache{client: client}, nil
+}
+
+func (rc *redisCache) Get(ctx context.Context, key string) (*cacheEntry, bool) {
+	redisCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second)
+	defer cancel()
+	val, err := rc.client.Get(redisCtx, key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			slog.WarnContext(ctx, "redis get failed", "key", key, "error", err.Error())
+		}
+		return nil, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(val, &entry); err != nil {
+		slog.WarnContext(ctx, "invalid cache entry", "key", key, "error", err.Error())
+		return nil, false
+	}
+	return &entry, true
+}
+
+func (rc *redisCache) Set(ctx context.Context, key string, entry *cacheEntry, retain time.Duration) {
+	val, err := json.Marshal(entry)
+	if err != nil {
+		slog.WarnContext(ctx, "unable to serialize cache entry", "key", key, "error", err.Error())
+		return
+	}
+	redisCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second)
+	defer cancel()
+	if err := rc.client.Set(redisCtx, key, val, retain).Err(); err != nil {
+		slog.WarnContext(ctx, "redis set failed", "key", key, "error", err.Error())
+	}
+}
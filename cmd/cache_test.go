@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+// testCacheStores runs a test against the memory cache and a redis cache backed by miniredis
+func testCacheStores(t *testing.T, test func(t *testing.T, rc *responseCache)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, &responseCache{store: newMemoryCache(100), refreshing: make(map[string]bool)})
+	})
+	t.Run("redis", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		store, err := newRedisCache(fmt.Sprintf("redis://%s", mr.Addr()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		test(t, &responseCache{store: store, refreshing: make(map[string]bool)})
+	})
+}
+
+// countingFetcher returns a numbered response each call, or the error if one is set
+type countingFetcher struct {
+	lock  sync.Mutex
+	calls int
+	err   *RequestError
+}
+
+func (cf *countingFetcher) fetch(ctx context.Context) ([]byte, *RequestError) {
+	cf.lock.Lock()
+	defer cf.lock.Unlock()
+	cf.calls++
+	if cf.err != nil {
+		return nil, cf.err
+	}
+	return []byte(fmt.Sprintf("response %d", cf.calls)), nil
+}
+
+func (cf *countingFetcher) count() int {
+	cf.lock.Lock()
+	defer cf.lock.Unlock()
+	return cf.calls
+}
+
+// age rewrites the cached entry for a key as if it was stored the given time ago
+func age(t *testing.T, rc *responseCache, src cacheSource, key string, by time.Duration) {
+	t.Helper()
+	cacheKey := fmt.Sprintf("%s:%s", src.Name, key)
+	entry, found := rc.store.Get(context.Background(), cacheKey)
+	if !found {
+		t.Fatalf("%s is not cached", cacheKey)
+	}
+	rc.store.Set(context.Background(), cacheKey, &cacheEntry{Data: entry.Data, StoredAt: time.Now().Add(-by)}, time.Hour)
+}
+
+var testCacheSource = cacheSource{Name: "test", TTL: time.Minute, Revalidate: time.Minute, StaleIfError: 10 * time.Minute}
+
+func TestCacheFresh(t *testing.T) {
+	testCacheStores(t, func(t *testing.T, rc *responseCache) {
+		cf := &countingFetcher{}
+		first, err := rc.fetch(context.Background(), testCacheSource, "u1", cf.fetch)
+		if err != nil || first.Cached || string(first.Data) != "response 1" {
+			t.Fatalf("first fetch = %+v, %+v; want an uncached response 1", first, err)
+		}
+		second, err := rc.fetch(context.Background(), testCacheSource, "u1", cf.fetch)
+		if err != nil || second.Cached == false || second.Stale || string(second.Data) != "response 1" {
+			t.Errorf("second fetch = %+v, %+v; want a fresh cached response 1", second, err)
+		}
+		if cf.count() != 1 {
+			t.Errorf("fetcher called %d times; want 1", cf.count())
+		}
+	})
+}
+
+func TestCacheRevalidate(t *testing.T) {
+	testCacheStores(t, func(t *testing.T, rc *responseCache) {
+		cf := &countingFetcher{}
+		rc.fetch(context.Background(), testCacheSource, "u1", cf.fetch)
+		age(t, rc, testCacheSource, "u1", 90*time.Second)
+
+		stale, err := rc.fetch(context.Background(), testCacheSource, "u1", cf.fetch)
+		if err != nil || stale.Stale == false || string(stale.Data) != "response 1" {
+			t.Fatalf("stale fetch = %+v, %+v; want stale response 1", stale, err)
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			if result, found := rc.cached(context.Background(), testCacheSource, "u1"); found && string(result.Data) == "response 2" {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("cache was not revalidated in the background")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+func TestCacheStaleIfError(t *testing.T) {
+	testCacheStores(t, func(t *testing.T, rc *responseCache) {
+		cf := &countingFetcher{}
+		rc.fetch(context.Background(), testCacheSource, "u1", cf.fetch)
+		age(t, rc, testCacheSource, "u1", 5*time.Minute)
+
+		cf.err = &RequestError{StatusCode: http.StatusServiceUnavailable, Message: "down"}
+		result, err := rc.fetch(context.Background(), testCacheSource, "u1", cf.fetch)
+		if err != nil || result.Stale == false || string(result.Data) != "response 1" {
+			t.Errorf("fetch with ILS down = %+v, %+v; want stale response 1", result, err)
+		}
+
+		// other errors are not hidden by the cache
+		cf.err = &RequestError{StatusCode: http.StatusUnauthorized, Message: "bad jwt"}
+		if _, err = rc.fetch(context.Background(), testCacheSource, "u1", cf.fetch); err == nil || err.StatusCode != http.StatusUnauthorized {
+			t.Errorf("fetch with bad jwt err = %+v; want 401", err)
+		}
+
+		// past the stale-if-error window the error is returned
+		cf.err = &RequestError{StatusCode: http.StatusServiceUnavailable, Message: "down"}
+		age(t, rc, testCacheSource, "u1", 20*time.Minute)
+		if _, err = rc.fetch(context.Background(), testCacheSource, "u1", cf.fetch); err == nil {
+			t.Error("fetch past stale-if-error window; want an error")
+		}
+	})
+}
+
+// userILS returns availability with a request option naming the JWT it was called with
+type userILS struct {
+	lock  sync.Mutex
+	calls int
+}
+
+func (ils *userILS) Name() string { return "test" }
+
+func (ils *userILS) GetAvailability(ctx context.Context, titleID string, jwt string) (*AvailabilityData, *RequestError) {
+	ils.lock.Lock()
+	ils.calls++
+	ils.lock.Unlock()
+	resp := AvailabilityData{}
+	resp.Availability.ID = titleID
+	resp.Availability.RequestOptions = []RequestOption{{Type: "hold", Label: "hold for " + jwt}}
+	return &resp, nil
+}
+
+func (ils *userILS) ValidateReserves(ctx context.Context, titleIDs []string, jwt string) ([]validateResponse, *RequestError) {
+	return nil, nil
+}
+
+func (ils *userILS) Health() *RequestError { return nil }
+
+func TestILSCachePerUser(t *testing.T) {
+	testCacheStores(t, func(t *testing.T, rc *responseCache) {
+		ils := &userILS{}
+		svc := &ServiceContext{ILS: ils, Cache: rc, ILSCache: cacheSource{Name: "ils", TTL: time.Minute}}
+		alice := ilsCacheUser(&v4jwt.V4Claims{UserID: "alice", Role: v4jwt.User})
+		bob := ilsCacheUser(&v4jwt.V4Claims{UserID: "bob", Role: v4jwt.User})
+		guest := ilsCacheUser(nil)
+		if guest != ilsCacheUser(&v4jwt.V4Claims{UserID: "anonymous", Role: v4jwt.Guest}) {
+			t.Errorf("public and guest requests do not share a cache entry")
+		}
+
+		ctx := context.Background()
+		for _, req := range []struct{ jwt, user string }{
+			{"alice-jwt", alice}, {"bob-jwt", bob}, {"guest-jwt", guest},
+			{"alice-jwt", alice}, {"bob-jwt", bob}, {"guest-jwt", guest},
+		} {
+			resp, err := svc.getILSAvailability(ctx, "u123", req.jwt, req.user)
+			if err != nil {
+				t.Fatalf("getILSAvailability: %+v", err)
+			}
+			if label := resp.Availability.RequestOptions[0].Label; label != "hold for "+req.jwt {
+				t.Errorf("%s got %q; another user's cached options", req.user, label)
+			}
+		}
+		if ils.calls != 3 {
+			t.Errorf("ILS called %d times; want once per user", ils.calls)
+		}
+	})
+}
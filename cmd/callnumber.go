@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// callNumber is a Library of Congress call number broken into its parts.
+// Examples: "HV599.5 .A1 B23 1998" => class HV, number 599.5, parts [a1 b23 1998]
+type callNumber struct {
+	Class  string
+	Number string
+	Parts  []string
+}
+
+// parseCallNumber splits a raw call number into class letters, class number and
+// the remaining cutters / dates. Everything is lowercased so comparisons are case insensitive.
+// Non-LC values like "Bachelor's thesis" or "DISS. 1234" parse with the leading word as the class.
+func parseCallNumber(raw string) callNumber {
+	out := callNumber{Parts: make([]string, 0)}
+	cn := []rune(strings.ToLower(strings.TrimSpace(raw)))
+	pos := 0
+
+	// class letters
+	for pos < len(cn) && unicode.IsLetter(cn[pos]) {
+		pos++
+	}
+	out.Class = string(cn[:pos])
+
+	// optional class number with decimal, possibly separated from the class by spaces
+	numStart := pos
+	for numStart < len(cn) && cn[numStart] == ' ' {
+		numStart++
+	}
+	numEnd := numStart
+	for numEnd < len(cn) && unicode.IsDigit(cn[numEnd]) {
+		numEnd++
+	}
+	if numEnd > numStart {
+		if numEnd+1 < len(cn) && cn[numEnd] == '.' && unicode.IsDigit(cn[numEnd+1]) {
+			numEnd++
+			for numEnd < len(cn) && unicode.IsDigit(cn[numEnd]) {
+				numEnd++
+			}
+		}
+		out.Number = string(cn[numStart:numEnd])
+		pos = numEnd
+	}
+
+	// cutters, dates and anything else. Cutter digits are decimal so ".A2" sorts after ".A123";
+	// leaving them as strings gives that ordering for free.
+	rest := strings.FieldsFunc(string(cn[pos:]), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, part := range rest {
+		if isAllDigits(part) {
+			part = zeroPad(part, 5)
+		}
+		out.Parts = append(out.Parts, part)
+	}
+	return out
+}
+
+// sortKey returns a string that sorts in LC shelf order when compared bytewise.
+// A key for a partial call number (like the "A" or "HV599" in a range) is a prefix
+// of the keys for all of the call numbers it covers.
+func (cn callNumber) sortKey() string {
+	key := cn.Class
+	if cn.Number != "" {
+		whole, frac, hasFrac := strings.Cut(cn.Number, ".")
+		key += " " + zeroPad(whole, 5)
+		if hasFrac {
+			key += "." + frac
+		}
+	}
+	for _, part := range cn.Parts {
+		key += " " + part
+	}
+	return key
+}
+
+// callNumberSortKey is a convenience to parse and generate a sort key in one step
+func callNumberSortKey(raw string) string {
+	return parseCallNumber(raw).sortKey()
+}
+
+// keySuccessor returns the smallest key that is greater than every key prefixed by the
+// supplied key. It is used to make range upper bounds inclusive of everything they prefix.
+func keySuccessor(key string) string {
+	if key == "" {
+		return ""
+	}
+	b := []byte(key)
+	b[len(b)-1]++
+	return string(b)
+}
+
+// zeroPad left pads numeric strings with zeros so they compare correctly as strings
+func zeroPad(val string, width int) string {
+	if len(val) >= width {
+		return val
+	}
+	return strings.Repeat("0", width-len(val)) + val
+}
+
+func isAllDigits(val string) bool {
+	if val == "" {
+		return false
+	}
+	for _, r := range val {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseCallNumber(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want callNumber
+	}{
+		{"HV599.5 .A1 B23 1998", callNumber{Class: "hv", Number: "599.5", Parts: []string{"a1", "b23", "01998"}}},
+		{"hv 599.5 .a1", callNumber{Class: "hv", Number: "599.5", Parts: []string{"a1"}}},
+		{"PS3545 .I345", callNumber{Class: "ps", Number: "3545", Parts: []string{"i345"}}},
+		{"QA76.", callNumber{Class: "qa", Number: "76", Parts: []string{}}},
+		{"XX(1234)", callNumber{Class: "xx", Parts: []string{"01234"}}},
+		{"Bachelor's thesis 1992", callNumber{Class: "bachelor", Parts: []string{"s", "thesis", "01992"}}},
+		{"DISS. 1234", callNumber{Class: "diss", Parts: []string{"01234"}}},
+		{"  ", callNumber{Parts: []string{}}},
+	}
+	for _, test := range tests {
+		if got := parseCallNumber(test.raw); reflect.DeepEqual(got, test.want) == false {
+			t.Errorf("parseCallNumber(%q) = %+v; want %+v", test.raw, got, test.want)
+		}
+	}
+}
+
+func TestSortKey(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"HV599.5 .A1 B23 1998", "hv 00599.5 a1 b23 01998"},
+		{"A", "a"},
+		{"HV600", "hv 00600"},
+		{"R13 .A1", "r 00013 a1"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := callNumberSortKey(test.raw); got != test.want {
+			t.Errorf("callNumberSortKey(%q) = %q; want %q", test.raw, got, test.want)
+		}
+	}
+
+	// keys sort in shelf order
+	shelf := []string{"A1 .B2", "A2", "A10", "A10.5", "A100", "AB1", "B1", "HV599.5 .A123", "HV599.5 .A2", "HV600", "Z1"}
+	keys := make([]string, 0, len(shelf))
+	for _, cn := range shelf {
+		keys = append(keys, callNumberSortKey(cn))
+	}
+	if sort.StringsAreSorted(keys) == false {
+		t.Errorf("sort keys are not in shelf order: %q", keys)
+	}
+
+	// the key of a partial call number prefixes the keys it covers, and keySuccessor bounds them
+	if low, cn := callNumberSortKey("HV599"), callNumberSortKey("HV599 .B2 1990"); cn < low || cn >= keySuccessor(low) {
+		t.Errorf("%q is not within [%q, %q)", cn, low, keySuccessor(low))
+	}
+}
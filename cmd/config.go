@@ -3,6 +3,8 @@ package main
 import (
 	"flag"
 	"log"
+	"strings"
+	"time"
 )
 
 // SolrConfig wraps up the config for solr acess
@@ -21,17 +23,79 @@ type SMTPConfig struct {
 	DevMode bool
 }
 
+// DBConfig wraps up the postgres connection configuration
+type DBConfig struct {
+	Host string
+	Port int
+	Name string
+	User string
+	Pass string
+}
+
+// FOLIOConfig wraps up the config for FOLIO Okapi access
+type FOLIOConfig struct {
+	URL    string
+	Tenant string
+	User   string
+	Pass   string
+}
+
+// CacheConfig wraps up the config for upstream response caching
+type CacheConfig struct {
+	Type         string
+	Size         int
+	RedisURL     string
+	ILSTTL       time.Duration
+	SolrTTL      time.Duration
+	Revalidate   time.Duration
+	StaleIfError time.Duration
+}
+
+// PublicConfig wraps up the rate limits for unauthenticated availability requests
+type PublicConfig struct {
+	Rate  float64
+	Burst int
+}
+
+// BreakerConfig wraps up the circuit breaker and retry policy for upstream (ILS and Solr) requests
+type BreakerConfig struct {
+	Failures  int
+	Cooldown  time.Duration
+	Retries   int
+	RetryWait time.Duration
+}
+
+// OutboxConfig wraps up the delivery schedule for queued emails
+type OutboxConfig struct {
+	Interval    time.Duration
+	MaxAttempts int
+	RetryBase   time.Duration
+	RetryMax    time.Duration
+}
+
 // ServiceConfig defines all of the v4client service configuration parameters
 type ServiceConfig struct {
 	Port               int
 	VirgoURL           string
 	ILSAPI             string
+	ILSBackend         string
+	FOLIO              FOLIOConfig
 	JWTKey             string
 	Solr               SolrConfig
 	HSILLiadURL        string
 	CourseReserveEmail string
 	LawReserveEmail    string
+	BatchLimit         int
+	ReserveItemLimit   int
+	RulesFile          string
+	RoutesFile         string
 	SMTP               SMTPConfig
+	DB                 DBConfig
+	Cache              CacheConfig
+	Public             PublicConfig
+	TrustedProxies     []string
+	Breaker            BreakerConfig
+	Outbox             OutboxConfig
 }
 
 // LoadConfig will load the service configuration from env/cmdline
@@ -41,13 +105,52 @@ func loadConfiguration() *ServiceConfig {
 	flag.StringVar(&cfg.VirgoURL, "virgo", "https://search.virginia.edu", "URL to Virgo")
 	flag.StringVar(&cfg.JWTKey, "jwtkey", "", "JWT signature key")
 	flag.StringVar(&cfg.ILSAPI, "ils", "https://ils-connector.lib.virginia.edu", "ILS Connector API URL")
+	flag.StringVar(&cfg.ILSBackend, "ilsbackend", "connector", "ILS backend for availability; connector or folio")
 	flag.StringVar(&cfg.CourseReserveEmail, "cremail", "", "Email recipient for course reserves requests")
 	flag.StringVar(&cfg.LawReserveEmail, "lawemail", "", "Law Email recipient for course reserves requests")
+	flag.StringVar(&cfg.RulesFile, "rules", "./data/request_options.yaml", "Request option rules file (YAML or JSON)")
+	flag.StringVar(&cfg.RoutesFile, "routes", "./data/reserve_routes.yaml", "Course reserve email routing file (YAML)")
+	flag.IntVar(&cfg.BatchLimit, "batchmax", 100, "Max number of items in a batch availability request (default 100)")
+	flag.IntVar(&cfg.ReserveItemLimit, "reservemax", 50, "Max number of items in a course reserve request (default 50)")
+
+	// Public (no JWT) availability rate limits, per client IP
+	flag.Float64Var(&cfg.Public.Rate, "publicrate", 2, "Public availability requests per second allowed per client")
+	flag.IntVar(&cfg.Public.Burst, "publicburst", 20, "Public availability request burst allowed per client")
+	var trustedProxies string
+	flag.StringVar(&trustedProxies, "trustedproxies", "", "Comma separated load balancer IPs or CIDRs trusted to set X-Forwarded-For; none by default")
+
+	// Upstream circuit breaker and retries
+	flag.IntVar(&cfg.Breaker.Failures, "breakerfailures", 5, "Failed upstream requests in a row that open the circuit breaker")
+	flag.DurationVar(&cfg.Breaker.Cooldown, "breakercooldown", 30*time.Second, "How long the circuit breaker stays open before a probe request")
+	flag.IntVar(&cfg.Breaker.Retries, "retries", 2, "Retries of upstream GET requests that fail with a gateway error")
+	flag.DurationVar(&cfg.Breaker.RetryWait, "retrywait", 200*time.Millisecond, "Base wait before retrying an upstream request; doubles each retry")
 
 	// Solr config
 	flag.StringVar(&cfg.Solr.URL, "solr", "", "Solr URL for journal browse")
 	flag.StringVar(&cfg.Solr.Core, "core", "test_core", "Solr core for journal browse")
 
+	// FOLIO config
+	flag.StringVar(&cfg.FOLIO.URL, "folio", "", "FOLIO Okapi URL")
+	flag.StringVar(&cfg.FOLIO.Tenant, "foliotenant", "", "FOLIO tenant")
+	flag.StringVar(&cfg.FOLIO.User, "foliouser", "", "FOLIO user")
+	flag.StringVar(&cfg.FOLIO.Pass, "foliopass", "", "FOLIO password")
+
+	// Cache config
+	flag.StringVar(&cfg.Cache.Type, "cache", "memory", "Response cache; memory, redis or none")
+	flag.IntVar(&cfg.Cache.Size, "cachesize", 10000, "Max entries in the memory cache")
+	flag.StringVar(&cfg.Cache.RedisURL, "redis", "", "Redis URL for the redis cache")
+	flag.DurationVar(&cfg.Cache.ILSTTL, "ilscachettl", 30*time.Second, "How long ILS availability is fresh")
+	flag.DurationVar(&cfg.Cache.SolrTTL, "solrcachettl", 10*time.Minute, "How long Solr documents are fresh")
+	flag.DurationVar(&cfg.Cache.Revalidate, "cacherevalidate", 5*time.Minute, "How long past TTL a response is served while it is refreshed")
+	flag.DurationVar(&cfg.Cache.StaleIfError, "cachestale", 24*time.Hour, "How long past TTL a response is served when the upstream is unavailable")
+
+	// DB connection params
+	flag.StringVar(&cfg.DB.Host, "dbhost", "", "Database host")
+	flag.IntVar(&cfg.DB.Port, "dbport", 5432, "Database port")
+	flag.StringVar(&cfg.DB.Name, "dbname", "", "Database name")
+	flag.StringVar(&cfg.DB.User, "dbuser", "", "Database user")
+	flag.StringVar(&cfg.DB.Pass, "dbpass", "", "Database password")
+
 	// SMTP settings
 	flag.StringVar(&cfg.SMTP.Host, "smtphost", "", "SMTP Host")
 	flag.IntVar(&cfg.SMTP.Port, "smtpport", 0, "SMTP Port")
@@ -56,17 +159,61 @@ func loadConfiguration() *ServiceConfig {
 	flag.StringVar(&cfg.SMTP.Sender, "smtpsender", "virgo4@virginia.edu", "SMTP sender email")
 	flag.BoolVar(&cfg.SMTP.DevMode, "stubsmtp", false, "Log email insted of sending (dev mode)")
 
+	// Email outbox delivery
+	flag.DurationVar(&cfg.Outbox.Interval, "outboxinterval", 10*time.Second, "How often the email outbox is checked for messages to send")
+	flag.IntVar(&cfg.Outbox.MaxAttempts, "outboxattempts", 8, "Send attempts before an email is dead lettered")
+	flag.DurationVar(&cfg.Outbox.RetryBase, "outboxretry", 30*time.Second, "Wait before the first email retry; doubles each retry")
+	flag.DurationVar(&cfg.Outbox.RetryMax, "outboxretrymax", time.Hour, "Longest wait between email retries")
+
 	// Illiad communications
 	flag.StringVar(&cfg.HSILLiadURL, "hsilliad", "", "HS Illiad API URL")
 	flag.Parse()
+	for _, proxy := range strings.Split(trustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			cfg.TrustedProxies = append(cfg.TrustedProxies, proxy)
+		}
+	}
 
 	// Fatal error for missing required params
 	if cfg.ILSAPI == "" {
 		log.Fatal("ils param is required")
 	}
+	if cfg.ILSBackend != "connector" && cfg.ILSBackend != "folio" {
+		log.Fatal("ilsbackend param must be connector or folio")
+	}
+	if cfg.ILSBackend == "folio" && (cfg.FOLIO.URL == "" || cfg.FOLIO.Tenant == "" || cfg.FOLIO.User == "" || cfg.FOLIO.Pass == "") {
+		log.Fatal("folio, foliotenant, foliouser and foliopass params are required for the folio backend")
+	}
 	if cfg.Solr.URL == "" || cfg.Solr.Core == "" {
 		log.Fatal("solr and core params are required")
 	}
+	if cfg.DB.Host == "" || cfg.DB.Name == "" || cfg.DB.User == "" {
+		log.Fatal("dbhost, dbname and dbuser params are required")
+	}
+	if cfg.Cache.Type == "redis" && cfg.Cache.RedisURL == "" {
+		log.Fatal("redis param is required for the redis cache")
+	}
+	if cfg.Cache.Type == "memory" && cfg.Cache.Size <= 0 {
+		log.Fatal("cachesize param must be greater than zero")
+	}
+	if cfg.Public.Rate <= 0 || cfg.Public.Burst <= 0 {
+		log.Fatal("publicrate and publicburst params must be greater than zero")
+	}
+	if cfg.Breaker.Failures <= 0 || cfg.Breaker.Cooldown <= 0 {
+		log.Fatal("breakerfailures and breakercooldown params must be greater than zero")
+	}
+	if cfg.Breaker.Retries < 0 || (cfg.Breaker.Retries > 0 && cfg.Breaker.RetryWait <= 0) {
+		log.Fatal("retries param must be zero or greater and retrywait must be greater than zero")
+	}
+	if cfg.Outbox.Interval <= 0 || cfg.Outbox.MaxAttempts <= 0 || cfg.Outbox.RetryBase <= 0 || cfg.Outbox.RetryMax < cfg.Outbox.RetryBase {
+		log.Fatal("outboxinterval, outboxattempts and outboxretry params must be greater than zero and outboxretrymax at least outboxretry")
+	}
+	if cfg.BatchLimit <= 0 {
+		log.Fatal("batchmax param must be greater than zero")
+	}
+	if cfg.ReserveItemLimit <= 0 {
+		log.Fatal("reservemax param must be greater than zero")
+	}
 	if cfg.JWTKey == "" {
 		log.Fatal("jwtkey param is required")
 	}
@@ -83,8 +230,41 @@ func loadConfiguration() *ServiceConfig {
 	log.Printf("[CONFIG] port          = [%d]", cfg.Port)
 	log.Printf("[CONFIG] virgo         = [%s]", cfg.VirgoURL)
 	log.Printf("[CONFIG] ils           = [%s]", cfg.ILSAPI)
+	log.Printf("[CONFIG] ilsbackend    = [%s]", cfg.ILSBackend)
+	if cfg.ILSBackend == "folio" {
+		log.Printf("[CONFIG] folio         = [%s]", cfg.FOLIO.URL)
+		log.Printf("[CONFIG] foliotenant   = [%s]", cfg.FOLIO.Tenant)
+		log.Printf("[CONFIG] foliouser     = [%s]", cfg.FOLIO.User)
+	}
 	log.Printf("[CONFIG] solr          = [%s]", cfg.Solr.URL)
 	log.Printf("[CONFIG] core          = [%s]", cfg.Solr.Core)
+	log.Printf("[CONFIG] batchmax      = [%d]", cfg.BatchLimit)
+	log.Printf("[CONFIG] reservemax    = [%d]", cfg.ReserveItemLimit)
+	log.Printf("[CONFIG] rules         = [%s]", cfg.RulesFile)
+	log.Printf("[CONFIG] publicrate    = [%.2f]", cfg.Public.Rate)
+	log.Printf("[CONFIG] publicburst   = [%d]", cfg.Public.Burst)
+	log.Printf("[CONFIG] trustedproxies = [%s]", strings.Join(cfg.TrustedProxies, ","))
+	log.Printf("[CONFIG] breakerfailures = [%d]", cfg.Breaker.Failures)
+	log.Printf("[CONFIG] breakercooldown = [%s]", cfg.Breaker.Cooldown)
+	log.Printf("[CONFIG] retries       = [%d]", cfg.Breaker.Retries)
+	log.Printf("[CONFIG] retrywait     = [%s]", cfg.Breaker.RetryWait)
+	log.Printf("[CONFIG] cache         = [%s]", cfg.Cache.Type)
+	if cfg.Cache.Type == "memory" {
+		log.Printf("[CONFIG] cachesize     = [%d]", cfg.Cache.Size)
+	}
+	if cfg.Cache.Type == "redis" {
+		log.Printf("[CONFIG] redis         = [%s]", redactURL(cfg.Cache.RedisURL))
+	}
+	if cfg.Cache.Type != "none" {
+		log.Printf("[CONFIG] ilscachettl   = [%s]", cfg.Cache.ILSTTL)
+		log.Printf("[CONFIG] solrcachettl  = [%s]", cfg.Cache.SolrTTL)
+		log.Printf("[CONFIG] cacherevalidate = [%s]", cfg.Cache.Revalidate)
+		log.Printf("[CONFIG] cachestale    = [%s]", cfg.Cache.StaleIfError)
+	}
+	log.Printf("[CONFIG] dbhost        = [%s]", cfg.DB.Host)
+	log.Printf("[CONFIG] dbport        = [%d]", cfg.DB.Port)
+	log.Printf("[CONFIG] dbname        = [%s]", cfg.DB.Name)
+	log.Printf("[CONFIG] dbuser        = [%s]", cfg.DB.User)
 	if cfg.SMTP.Host != "" {
 		log.Printf("[CONFIG] smtphost      = [%s]", cfg.SMTP.Host)
 		log.Printf("[CONFIG] smtpport      = [%d]", cfg.SMTP.Port)
@@ -94,8 +274,13 @@ func loadConfiguration() *ServiceConfig {
 	}
 	log.Printf("[CONFIG] smtpsender    = [%s]", cfg.SMTP.Sender)
 	log.Printf("[CONFIG] stubsmtp      = [%t]", cfg.SMTP.DevMode)
+	log.Printf("[CONFIG] outboxinterval = [%s]", cfg.Outbox.Interval)
+	log.Printf("[CONFIG] outboxattempts = [%d]", cfg.Outbox.MaxAttempts)
+	log.Printf("[CONFIG] outboxretry   = [%s]", cfg.Outbox.RetryBase)
+	log.Printf("[CONFIG] outboxretrymax = [%s]", cfg.Outbox.RetryMax)
 	log.Printf("[CONFIG] cremail       = [%s]", cfg.CourseReserveEmail)
 	log.Printf("[CONFIG] lawemail      = [%s]", cfg.LawReserveEmail)
+	log.Printf("[CONFIG] routes        = [%s]", cfg.RoutesFile)
 	log.Printf("[CONFIG] hsilliad      = [%s]", cfg.HSILLiadURL)
 
 	return &cfg
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	_ "github.com/lib/pq"
+)
+
+// location of the schema migrations, relative to the bin directory
+const migrationsDir = "db/migrations"
+
+// initDB connects to postgres and brings the schema up to date. Any failure is fatal to the service.
+func (svc *ServiceContext) initDB(cfg DBConfig) error {
+	slog.Info("connect to postgres", "user", cfg.User, "host", cfg.Host, "port", cfg.Port, "db", cfg.Name)
+	db, err := sql.Open("postgres", dbConnectionURL(cfg))
+	if err != nil {
+		return fmt.Errorf("unable to open database: %s", err.Error())
+	}
+	if err = db.Ping(); err != nil {
+		return fmt.Errorf("unable to connect to database: %s", err.Error())
+	}
+	svc.DB = db
+	return migrateDB(db, migrationsDir)
+}
+
+// migrateDB brings the schema up to date with the migrations in dir
+func migrateDB(db *sql.DB, dir string) error {
+	slog.Info("run schema migrations", "dir", dir)
+	driver, err := postgres.WithInstance(db, &postgres.Config{})
+	if err != nil {
+		return fmt.Errorf("unable to create migration driver: %s", err.Error())
+	}
+	migrator, err := migrate.NewWithDatabaseInstance(fmt.Sprintf("file://%s", dir), "postgres", driver)
+	if err != nil {
+		return fmt.Errorf("unable to load migrations: %s", err.Error())
+	}
+	err = migrator.Up()
+	if err != nil && err != migrate.ErrNoChange {
+		return fmt.Errorf("schema migration failed: %s", err.Error())
+	}
+	schemaVersion, dirty, _ := migrator.Version()
+	slog.Info("database schema migrated", "version", schemaVersion, "dirty", dirty)
+	if dirty {
+		return fmt.Errorf("database schema version %d is dirty", schemaVersion)
+	}
+	return nil
+}
+
+// dbConnectionURL is the postgres connection URL for the config. Building it as a URL escapes
+// any quotes, backslashes or spaces in the values.
+func dbConnectionURL(cfg DBConfig) string {
+	connURL := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.User, cfg.Pass),
+		Host:     net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+		Path:     "/" + cfg.Name,
+		RawQuery: "sslmode=disable",
+	}
+	return connURL.String()
+}
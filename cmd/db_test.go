@@ -0,0 +1,47 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestDBConnectionURL(t *testing.T) {
+	cfg := DBConfig{Host: "db.example.com", Port: 5433, Name: "virgo4", User: "v4 user", Pass: `it's a \ p@ss:/word?`}
+	conn, err := pq.ParseURL(dbConnectionURL(cfg))
+	if err != nil {
+		t.Fatalf("ParseURL: %s", err.Error())
+	}
+	for _, want := range []string{`user='v4 user'`, `password='it\'s a \\ p@ss:/word?'`, `dbname='virgo4'`,
+		`host='db.example.com'`, `port='5433'`, `sslmode='disable'`} {
+		if strings.Contains(conn, want) == false {
+			t.Errorf("connection %s is missing %s", conn, want)
+		}
+	}
+}
+
+// newTestDB connects to the scratch database in TEST_DATABASE_URL, brings its schema up to date and empties
+// the reserve and outbox tables. Tests that need postgres are skipped when it is not set.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dbURL := os.Getenv("TEST_DATABASE_URL")
+	if dbURL == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err = migrateDB(db, "../"+migrationsDir); err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`TRUNCATE reserve_requests, reserve_request_items, reserve_status_events, email_outbox RESTART IDENTITY`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// degradedNotice is shown on items built from solr; their circulation status is not known
+const degradedNotice = "Current status could not be verified. Please check with library staff."
+
+// useDegradedAvailability is true for ILS errors that mean the ILS is down; a 5xx (including an open
+// circuit breaker) or a timeout. Other errors are about the request and are returned as is.
+func useDegradedAvailability(ilsErr *RequestError) bool {
+	return ilsErr.StatusCode >= 500 || ilsErr.StatusCode == http.StatusRequestTimeout
+}
+
+// degradedAvailability builds availability from the holdings in a solr document. It is used when the ILS
+// is down so patrons can still see where copies live (and the map). Every item is flagged status_unverified
+// and the response is flagged degraded with the reason. When the index has an anonymous availability for
+// the title it is included in the response and in each item notice, as the last known status.
+func degradedAvailability(ctx context.Context, doc *SolrDocument, ilsErr *RequestError) *AvailabilityData {
+	slog.WarnContext(ctx, "using degraded availability from solr", "title_id", doc.ID, "ils_status", ilsErr.StatusCode)
+	degradedResponses.WithLabelValues(fmt.Sprintf("%d", ilsErr.StatusCode)).Inc()
+
+	availResp := AvailabilityData{}
+	availResp.Availability.ID = doc.ID
+	availResp.Availability.Degraded = true
+	availResp.Availability.DegradedReason = fmt.Sprintf("ILS unavailable (%d)", ilsErr.StatusCode)
+	availResp.Availability.Items = solrHoldings(doc)
+	availResp.Availability.AnonStatus = doc.AnonAvailability
+	if len(doc.AnonAvailability) > 0 {
+		notice := fmt.Sprintf("Last known status: %s. %s", strings.Join(doc.AnonAvailability, ", "), degradedNotice)
+		for _, item := range availResp.Availability.Items {
+			item.Notice = notice
+		}
+	}
+	availResp.Availability.RequestOptions = make([]RequestOption, 0)
+	return &availResp
+}
+
+// solrHoldings creates an item for each barcode or call number in a solr document. Library and location
+// are per item when there is one value per item, otherwise the first value is used for all. Solr has
+// location names, not codes; the name is converted to a code-like ID so map lookups can still match.
+func solrHoldings(doc *SolrDocument) []*Item {
+	count := len(doc.Barcode)
+	if len(doc.CallNumber) > count {
+		count = len(doc.CallNumber)
+	}
+	if count == 0 && len(doc.Library) > 0 {
+		count = 1
+	}
+
+	items := make([]*Item, 0)
+	for idx := 0; idx < count; idx++ {
+		location := holdingValue(doc.Location, idx, count)
+		item := Item{
+			Barcode:          holdingValue(doc.Barcode, idx, count),
+			CallNumber:       holdingValue(doc.CallNumber, idx, count),
+			Library:          holdingValue(doc.Library, idx, count),
+			CurrentLocation:  location,
+			HomeLocationID:   strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(location), " ", "-")),
+			Notice:           degradedNotice,
+			StatusUnverified: true,
+		}
+		item.CurrentLocationID = item.HomeLocationID
+		items = append(items, &item)
+	}
+	return items
+}
+
+func holdingValue(values []string, idx int, count int) string {
+	if len(values) == count && idx < len(values) {
+		return values[idx]
+	}
+	if len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
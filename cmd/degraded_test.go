@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUseDegradedAvailability(t *testing.T) {
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{http.StatusInternalServerError, true},
+		{http.StatusBadGateway, true},
+		{http.StatusServiceUnavailable, true},
+		{http.StatusGatewayTimeout, true},
+		{http.StatusRequestTimeout, true},
+		{http.StatusBadRequest, false},
+		{http.StatusUnauthorized, false},
+		{http.StatusForbidden, false},
+		{http.StatusNotFound, false},
+		{statusClientClosedRequest, false},
+	}
+	for _, test := range tests {
+		if got := useDegradedAvailability(&RequestError{StatusCode: test.status}); got != test.want {
+			t.Errorf("useDegradedAvailability(%d) = %t; want %t", test.status, got, test.want)
+		}
+	}
+
+	// an open breaker is a 503
+	cb := newCircuitBreaker("test_degraded", BreakerConfig{Failures: 1, Cooldown: time.Hour})
+	cb.done(context.Background(), false, &RequestError{StatusCode: http.StatusBadGateway})
+	if err := cb.call(context.Background(), "GET", func() *RequestError { return nil }); err == nil || useDegradedAvailability(err) == false {
+		t.Errorf("open breaker error %+v does not use degraded availability", err)
+	}
+}
+
+func TestDegradedAvailability(t *testing.T) {
+	doc := &SolrDocument{ID: "u1", Barcode: []string{"X1", "X2"}, CallNumber: []string{"PS3545 .I345", "PS3545 .I345 v.2"},
+		Library: []string{"Alderman"}, Location: []string{"Stacks", "Reference"}}
+	resp := degradedAvailability(context.Background(), doc, &RequestError{StatusCode: http.StatusServiceUnavailable})
+	avail := resp.Availability
+	if avail.ID != "u1" || avail.Degraded == false || avail.DegradedReason != "ILS unavailable (503)" || len(avail.Items) != 2 {
+		t.Fatalf("availability = %+v", avail)
+	}
+	item := avail.Items[1]
+	if item.Barcode != "X2" || item.Library != "Alderman" || item.HomeLocationID != "REFERENCE" ||
+		item.StatusUnverified == false || item.Notice != degradedNotice {
+		t.Errorf("item = %+v", item)
+	}
+	if avail.AnonStatus != nil {
+		t.Errorf("anon status = %v; want none", avail.AnonStatus)
+	}
+
+	doc.AnonAvailability = []string{"On shelf"}
+	avail = degradedAvailability(context.Background(), doc, &RequestError{StatusCode: http.StatusRequestTimeout}).Availability
+	if len(avail.AnonStatus) != 1 || avail.AnonStatus[0] != "On shelf" {
+		t.Errorf("anon status = %v; want the solr anon_availability_a", avail.AnonStatus)
+	}
+	for _, item := range avail.Items {
+		if strings.HasPrefix(item.Notice, "Last known status: On shelf.") == false || item.StatusUnverified == false {
+			t.Errorf("item = %+v; want the anon availability in an unverified notice", item)
+		}
+	}
+}
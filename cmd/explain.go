@@ -0,0 +1,116 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ruleTrace records every decision made by the request option rules for one title
+type ruleTrace struct {
+	ClaimsUsed map[string][]string `json:"claims_used"`
+	Rules      []*ruleDecision     `json:"rules"`
+}
+
+// ruleDecision is the outcome of one rule; the field tests that decided it and the actions it took
+type ruleDecision struct {
+	Rule       string               `json:"rule"`
+	Applied    bool                 `json:"applied"`
+	Reason     string               `json:"reason,omitempty"`
+	Conditions []*conditionDecision `json:"conditions"`
+	Actions    []*actionDecision    `json:"actions"`
+}
+
+type conditionDecision struct {
+	Source  string   `json:"source"`
+	Field   string   `json:"field"`
+	Values  []string `json:"values"`
+	Test    string   `json:"test"`
+	Matched bool     `json:"matched"`
+}
+
+type actionDecision struct {
+	Action       string   `json:"action"`
+	OptionType   string   `json:"option_type,omitempty"`
+	Result       string   `json:"result"`
+	ItemsRemoved []string `json:"items_removed,omitempty"`
+}
+
+// explainResponse is the availability for a title along with how it was decided
+type explainResponse struct {
+	TitleID      string            `json:"title_id"`
+	ILSStatus    int               `json:"ils_status"`
+	SolrDocument bool              `json:"solr_document"`
+	Trace        *ruleTrace        `json:"trace"`
+	Availability *AvailabilityData `json:"result"`
+}
+
+// explainAvailability runs the same pipeline as getAvailability and returns a trace of every decision made
+// by the request option rules. Staff use this to find out why a patron does or does not see a request option.
+func (svc *ServiceContext) explainAvailability(c *gin.Context) {
+	slog.InfoContext(c.Request.Context(), "explain availability", "title_id", c.Param("id"))
+	v4Claims, _ := getJWTClaims(c)
+	trace := &ruleTrace{ClaimsUsed: make(map[string][]string), Rules: make([]*ruleDecision, 0)}
+	availResp, solrDoc, ilsStatus := svc.availabilityForRequest(c, v4Claims, trace)
+	if availResp == nil {
+		return
+	}
+	c.JSON(http.StatusOK, explainResponse{TitleID: strings.TrimSpace(c.Param("id")), ILSStatus: ilsStatus,
+		SolrDocument: solrDoc != nil, Trace: trace, Availability: availResp})
+}
+
+// addRule starts the record of a rule. All trace methods do nothing on a nil trace.
+func (trace *ruleTrace) addRule(name string) *ruleDecision {
+	if trace == nil {
+		return nil
+	}
+	decision := &ruleDecision{Rule: name, Conditions: make([]*conditionDecision, 0), Actions: make([]*actionDecision, 0)}
+	trace.Rules = append(trace.Rules, decision)
+	return decision
+}
+
+func (trace *ruleTrace) claimUsed(claim string, values []string) {
+	if trace == nil {
+		return
+	}
+	trace.ClaimsUsed[claim] = values
+}
+
+func (decision *ruleDecision) apply() {
+	if decision == nil {
+		return
+	}
+	decision.Applied = true
+}
+
+func (decision *ruleDecision) skip(reason string) {
+	if decision == nil {
+		return
+	}
+	decision.Reason = reason
+}
+
+func (decision *ruleDecision) condition(cond *ruleCondition, values []string, matched bool) {
+	if decision == nil {
+		return
+	}
+	cd := conditionDecision{Field: cond.Claim, Source: "claim", Values: values, Test: cond.describe(), Matched: matched}
+	if cond.Solr != "" {
+		cd.Source, cd.Field = "solr", cond.Solr
+	} else if cond.Item != "" {
+		cd.Source, cd.Field = "item", cond.Item
+	}
+	if cd.Values == nil {
+		cd.Values = make([]string, 0)
+	}
+	decision.Conditions = append(decision.Conditions, &cd)
+}
+
+func (decision *ruleDecision) action(ad *actionDecision) {
+	if decision == nil {
+		return
+	}
+	decision.Actions = append(decision.Actions, ad)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+	"gopkg.in/yaml.v3"
+)
+
+// explainTitle gets the explain response for a title as a signed in user from the home library
+func explainTitle(t *testing.T, svc *ServiceContext, titleID string, homeLibrary string) *httptest.ResponseRecorder {
+	t.Helper()
+	router := gin.New()
+	router.GET("/item/:id/explain", func(c *gin.Context) {
+		c.Set("claims", &v4jwt.V4Claims{UserID: "mst3k", HomeLibrary: homeLibrary})
+	}, svc.explainAvailability)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/item/"+titleID+"/explain", nil))
+	return w
+}
+
+func TestExplainAvailabilityTrace(t *testing.T) {
+	svc := newTestUpstreams(t, 0)
+	svc.Rules = &requestRules{}
+	err := yaml.Unmarshal([]byte(`
+rules:
+  - name: test_law_items_only
+    solr_optional: true
+    when: {claim: homeLibrary, equals: LAW}
+    actions:
+      - filter_items: {item: library, equals: Law}
+  - name: test_clemons_only
+    solr_optional: true
+    when: {claim: homeLibrary, equals: CLEMONS}
+    actions:
+      - remove_option: hold
+`), svc.Rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := explainTitle(t, svc, "u1", "LAW")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d; want 200 (%s)", w.Code, w.Body.String())
+	}
+	var resp explainResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.TitleID != "u1" || resp.ILSStatus != http.StatusOK || resp.SolrDocument == false {
+		t.Errorf("explain = %s, ILS %d, solr %t; want u1 found in the ILS and solr", resp.TitleID, resp.ILSStatus, resp.SolrDocument)
+	}
+	if claim := resp.Trace.ClaimsUsed["homeLibrary"]; len(claim) != 1 || claim[0] != "LAW" {
+		t.Errorf("claims used = %v; want homeLibrary LAW", resp.Trace.ClaimsUsed)
+	}
+	if len(resp.Trace.Rules) != 2 {
+		t.Fatalf("trace has %d rules; want 2", len(resp.Trace.Rules))
+	}
+
+	law := resp.Trace.Rules[0]
+	if law.Rule != "test_law_items_only" || law.Applied == false || len(law.Conditions) != 1 || law.Conditions[0].Matched == false ||
+		law.Conditions[0].Source != "claim" || law.Conditions[0].Field != "homeLibrary" {
+		t.Errorf("law rule = %+v; want it applied on the homeLibrary claim", law)
+	}
+	if len(law.Actions) != 1 || law.Actions[0].Action != "filter_items" || law.Actions[0].Result != "kept 0 of 1 items" ||
+		len(law.Actions[0].ItemsRemoved) != 1 || strings.HasPrefix(law.Actions[0].ItemsRemoved[0], "X1") == false {
+		t.Errorf("law rule actions = %+v; want item X1 removed", law.Actions)
+	}
+	if len(resp.Availability.Availability.Items) != 0 {
+		t.Errorf("result items = %+v; want the filtered item gone", resp.Availability.Availability.Items)
+	}
+
+	clemons := resp.Trace.Rules[1]
+	if clemons.Applied || clemons.Reason != "conditions not met" || len(clemons.Conditions) != 1 || clemons.Conditions[0].Matched {
+		t.Errorf("clemons rule = %+v; want it skipped on an unmatched condition", clemons)
+	}
+}
+
+func TestExplainAvailabilityILSFailure(t *testing.T) {
+	ils := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "internal connector detail", http.StatusBadRequest)
+	}))
+	t.Cleanup(ils.Close)
+	svc := newTestUpstreams(t, 0)
+	backend, err := newILSBackend(svc, &ServiceConfig{ILSBackend: "connector", ILSAPI: ils.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.ILS = backend
+
+	w := explainTitle(t, svc, "u1", "LAW")
+	if w.Code != http.StatusBadRequest || strings.Contains(w.Body.String(), "connector detail") {
+		t.Errorf("status = %d, body = %q; want a 400 without the ILS error", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FOLIO item statuses that mean the item is on the shelf, or can't be had at all
+var folioAvailableStatus = []string{"Available"}
+var folioUnavailableStatus = []string{"Missing", "Withdrawn", "Lost and paid", "Aged to lost", "Declared lost",
+	"Claimed returned", "Long missing", "Unavailable", "Unknown", "Restricted"}
+
+// cqlEscaper escapes the characters that are special inside a quoted CQL term
+var cqlEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `*`, `\*`, `?`, `\?`, `^`, `\^`)
+
+var uuidRegex = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// folioBackend is the ILSBackend for FOLIO. Availability comes from mod-rtac
+// through Okapi; title IDs that are not instance UUIDs are resolved as instance HRIDs.
+type folioBackend struct {
+	svc    *ServiceContext
+	Config FOLIOConfig
+	token  string
+	lock   sync.Mutex
+}
+
+// folioRTACResponse is the response from GET /rtac/:instanceID
+type folioRTACResponse struct {
+	Holdings []folioHolding `json:"holdings"`
+}
+
+type folioHolding struct {
+	ID           string `json:"id"`
+	Barcode      string `json:"barcode"`
+	CallNumber   string `json:"callNumber"`
+	Location     string `json:"location"`
+	LocationCode string `json:"locationCode"`
+	Status       string `json:"status"`
+	DueDate      string `json:"dueDate"`
+	HoldCount    int    `json:"totalHoldRequests"`
+	Volume       string `json:"volume"`
+	Library      struct {
+		Name string `json:"name"`
+		Code string `json:"code"`
+	} `json:"library"`
+	MaterialType struct {
+		Name string `json:"name"`
+	} `json:"materialType"`
+}
+
+type folioInstances struct {
+	Instances []struct {
+		ID   string `json:"id"`
+		HRID string `json:"hrid"`
+	} `json:"instances"`
+	TotalRecords int `json:"totalRecords"`
+}
+
+func newFolioBackend(svc *ServiceContext, cfg FOLIOConfig) *folioBackend {
+	return &folioBackend{svc: svc, Config: cfg}
+}
+
+func (folio *folioBackend) Name() string {
+	return "folio"
+}
+
+func (folio *folioBackend) GetAvailability(ctx context.Context, titleID string, jwt string) (*AvailabilityData, *RequestError) {
+	availResp := AvailabilityData{}
+	holdings, folioErr := folio.getHoldings(ctx, titleID)
+	if folioErr != nil {
+		return &availResp, folioErr
+	}
+
+	availResp.Availability.ID = titleID
+	availResp.Availability.Items = make([]*Item, 0)
+	availResp.Availability.RequestOptions = make([]RequestOption, 0)
+	for _, h := range holdings {
+		item := Item{
+			Barcode:           h.Barcode,
+			OnShelf:           folioStatusIn(h.Status, folioAvailableStatus),
+			Unavailable:       folioStatusIn(h.Status, folioUnavailableStatus),
+			Library:           h.Library.Name,
+			LibraryID:         h.Library.Code,
+			CurrentLocation:   h.Location,
+			CurrentLocationID: h.LocationCode,
+			HomeLocationID:    h.LocationCode,
+			CallNumber:        h.CallNumber,
+			Volume:            h.Volume,
+			DueDate:           h.DueDate,
+			HoldCount:         h.HoldCount,
+		}
+		if item.OnShelf == false {
+			item.Notice = h.Status
+			if h.DueDate != "" {
+				item.Notice = fmt.Sprintf("%s, due %s", h.Status, h.DueDate)
+			}
+		}
+		availResp.Availability.Items = append(availResp.Availability.Items, &item)
+	}
+	return &availResp, nil
+}
+
+// ValidateReserves treats any title with at least one item as reservable. Items are video when their
+// material type says so. The HRIDs of all of the titles are resolved with a single instance query.
+func (folio *folioBackend) ValidateReserves(ctx context.Context, titleIDs []string, jwt string) ([]validateResponse, *RequestError) {
+	instanceIDs, folioErr := folio.resolveInstanceIDs(ctx, titleIDs)
+	if folioErr != nil {
+		return nil, folioErr
+	}
+	out := make([]validateResponse, 0)
+	for _, titleID := range titleIDs {
+		resp := validateResponse{ID: titleID}
+		instanceID, found := instanceIDs[titleID]
+		if found == false {
+			out = append(out, resp)
+			continue
+		}
+		holdings, folioErr := folio.getInstanceHoldings(ctx, titleID, instanceID)
+		if folioErr != nil && folioErr.StatusCode != http.StatusNotFound {
+			return nil, folioErr
+		}
+		for _, h := range holdings {
+			resp.Reserve = true
+			mt := strings.ToLower(h.MaterialType.Name)
+			if strings.Contains(mt, "video") || strings.Contains(mt, "dvd") {
+				resp.IsVideo = true
+			}
+		}
+		out = append(out, resp)
+	}
+	return out, nil
+}
+
+func (folio *folioBackend) Health() *RequestError {
+	_, folioErr := folio.get(context.Background(), "/admin/health", folio.svc.FastHTTPClient)
+	return folioErr
+}
+
+// getHoldings gets the RTAC holdings for a title
+func (folio *folioBackend) getHoldings(ctx context.Context, titleID string) ([]folioHolding, *RequestError) {
+	instanceID, folioErr := folio.resolveInstanceID(ctx, titleID)
+	if folioErr != nil {
+		return nil, folioErr
+	}
+	return folio.getInstanceHoldings(ctx, titleID, instanceID)
+}
+
+// getInstanceHoldings gets the RTAC holdings for the FOLIO instance of a title
+func (folio *folioBackend) getInstanceHoldings(ctx context.Context, titleID string, instanceID string) ([]folioHolding, *RequestError) {
+	respBytes, folioErr := folio.get(ctx, fmt.Sprintf("/rtac/%s", url.PathEscape(instanceID)), folio.svc.SlowHTTPClient)
+	if folioErr != nil {
+		return nil, folioErr
+	}
+	var rtac folioRTACResponse
+	if err := json.Unmarshal(respBytes, &rtac); err != nil {
+		slog.ErrorContext(ctx, "unable to parse FOLIO RTAC response", "title_id", titleID, "error", err.Error())
+		return nil, &RequestError{StatusCode: http.StatusInternalServerError, Message: err.Error()}
+	}
+	return rtac.Holdings, nil
+}
+
+// resolveInstanceID converts a title ID into a FOLIO instance UUID. IDs that are already
+// a UUID are used as-is, anything else is looked up as an instance HRID.
+func (folio *folioBackend) resolveInstanceID(ctx context.Context, titleID string) (string, *RequestError) {
+	instanceIDs, folioErr := folio.resolveInstanceIDs(ctx, []string{titleID})
+	if folioErr != nil {
+		return "", folioErr
+	}
+	instanceID, found := instanceIDs[titleID]
+	if found == false {
+		return "", &RequestError{StatusCode: http.StatusNotFound, Message: fmt.Sprintf("%s not found", titleID)}
+	}
+	return instanceID, nil
+}
+
+// resolveInstanceIDs converts title IDs into FOLIO instance UUIDs, returned as a map of title ID to UUID.
+// UUIDs are used as-is; the rest are looked up as instance HRIDs in one query. Titles with no instance
+// are not in the map.
+func (folio *folioBackend) resolveInstanceIDs(ctx context.Context, titleIDs []string) (map[string]string, *RequestError) {
+	out := make(map[string]string)
+	terms := make([]string, 0)
+	for _, titleID := range titleIDs {
+		if uuidRegex.MatchString(titleID) {
+			out[titleID] = titleID
+			continue
+		}
+		terms = append(terms, fmt.Sprintf(`hrid=="%s"`, cqlEscaper.Replace(titleID)))
+	}
+	if len(terms) == 0 {
+		return out, nil
+	}
+
+	cql := url.QueryEscape(strings.Join(terms, " or "))
+	respBytes, folioErr := folio.get(ctx, fmt.Sprintf("/instance-storage/instances?limit=%d&query=%s", len(terms), cql), folio.svc.HTTPClient)
+	if folioErr != nil {
+		return nil, folioErr
+	}
+	var resp folioInstances
+	if err := json.Unmarshal(respBytes, &resp); err != nil {
+		slog.ErrorContext(ctx, "unable to parse FOLIO instance response", "title_ids", titleIDs, "error", err.Error())
+		return nil, &RequestError{StatusCode: http.StatusInternalServerError, Message: err.Error()}
+	}
+	for _, instance := range resp.Instances {
+		out[instance.HRID] = instance.ID
+	}
+	return out, nil
+}
+
+// get sends an authenticated GET to Okapi through the ILS circuit breaker. An expired token is refreshed
+// and the request retried once.
+func (folio *folioBackend) get(ctx context.Context, path string, httpClient *http.Client) ([]byte, *RequestError) {
+	var resp []byte
+	folioErr := folio.svc.ILSBreaker.call(ctx, "GET", func() *RequestError {
+		token, folioErr := folio.getToken(ctx, false)
+		if folioErr != nil {
+			return folioErr
+		}
+		resp, folioErr = folio.doGet(ctx, path, token, httpClient)
+		if folioErr != nil && folioErr.StatusCode == http.StatusUnauthorized {
+			slog.InfoContext(ctx, "FOLIO token rejected; login and retry")
+			token, folioErr = folio.getToken(ctx, true)
+			if folioErr != nil {
+				return folioErr
+			}
+			resp, folioErr = folio.doGet(ctx, path, token, httpClient)
+		}
+		return folioErr
+	})
+	return resp, folioErr
+}
+
+func (folio *folioBackend) doGet(ctx context.Context, path string, token string, httpClient *http.Client) ([]byte, *RequestError) {
+	url := fmt.Sprintf("%s%s", folio.Config.URL, path)
+	slog.InfoContext(ctx, "FOLIO request", "method", "GET", "url", url, "timeout_sec", httpClient.Timeout.Seconds())
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, &RequestError{StatusCode: http.StatusBadRequest, Message: err.Error()}
+	}
+	req.Header.Set("X-Okapi-Tenant", folio.Config.Tenant)
+	req.Header.Set("X-Okapi-Token", token)
+	req.Header.Set("Accept", "application/json")
+
+	startTime := time.Now()
+	rawResp, rawErr := httpClient.Do(req)
+	resp, respErr := handleAPIResponse(url, rawResp, rawErr)
+	elapsed := time.Since(startTime)
+	elapsedMS := int64(elapsed / time.Millisecond)
+	observeUpstream("folio", "GET", elapsed, respErr)
+	if respErr != nil {
+		slog.ErrorContext(ctx, "FOLIO response", "method", "GET", "url", url, "status", respErr.StatusCode,
+			"error", respErr.Message, "elapsed_ms", elapsedMS)
+	} else {
+		slog.InfoContext(ctx, "FOLIO response", "method", "GET", "url", url, "status", http.StatusOK, "elapsed_ms", elapsedMS)
+	}
+	return resp, respErr
+}
+
+// getToken returns the cached Okapi token, logging in if there is none or a refresh is requested
+func (folio *folioBackend) getToken(ctx context.Context, refresh bool) (string, *RequestError) {
+	folio.lock.Lock()
+	defer folio.lock.Unlock()
+	if folio.token != "" && refresh == false {
+		return folio.token, nil
+	}
+
+	url := fmt.Sprintf("%s/authn/login", folio.Config.URL)
+	slog.InfoContext(ctx, "FOLIO login request", "url", url, "user", folio.Config.User)
+	creds := map[string]string{"username": folio.Config.User, "password": folio.Config.Pass}
+	b, _ := json.Marshal(creds)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(b))
+	if err != nil {
+		return "", &RequestError{StatusCode: http.StatusBadRequest, Message: err.Error()}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Okapi-Tenant", folio.Config.Tenant)
+	rawResp, rawErr := folio.svc.HTTPClient.Do(req)
+	if rawErr == nil && rawResp.StatusCode == http.StatusCreated {
+		folio.token = rawResp.Header.Get("X-Okapi-Token")
+	}
+	_, respErr := handleAPIResponse(url, rawResp, rawErr)
+	if respErr != nil {
+		slog.ErrorContext(ctx, "FOLIO login failed", "url", url, "status", respErr.StatusCode, "error", respErr.Message)
+		return "", respErr
+	}
+	if folio.token == "" {
+		return "", &RequestError{StatusCode: http.StatusUnauthorized, Message: "FOLIO login returned no token"}
+	}
+	return folio.token, nil
+}
+
+func folioStatusIn(status string, statuses []string) bool {
+	for _, s := range statuses {
+		if strings.EqualFold(s, status) {
+			return true
+		}
+	}
+	return false
+}
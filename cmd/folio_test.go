@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testInstanceID = "0f6e1e2a-3b4c-4d5e-8f90-1a2b3c4d5e6f"
+
+// fakeOkapi is an Okapi stand in. Each login issues a new token and only the newest token is accepted,
+// so a test can expire the token the backend has cached by logging in again.
+type fakeOkapi struct {
+	lock     sync.Mutex
+	logins   int
+	token    string
+	requests []string
+	rtac     folioRTACResponse
+	status   int
+}
+
+func (okapi *fakeOkapi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	okapi.lock.Lock()
+	defer okapi.lock.Unlock()
+	okapi.requests = append(okapi.requests, r.URL.RequestURI())
+	if r.Header.Get("X-Okapi-Tenant") != "diku" {
+		http.Error(w, "no tenant", http.StatusBadRequest)
+		return
+	}
+	if r.URL.Path == "/authn/login" {
+		var creds map[string]string
+		json.NewDecoder(r.Body).Decode(&creds)
+		if r.Method != "POST" || creds["username"] != "v4" || creds["password"] != "secret" {
+			http.Error(w, "bad login", http.StatusUnprocessableEntity)
+			return
+		}
+		okapi.logins++
+		okapi.token = fmt.Sprintf("token-%d", okapi.logins)
+		w.Header().Set("X-Okapi-Token", okapi.token)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	if r.Header.Get("X-Okapi-Token") != okapi.token {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	if okapi.status != 0 {
+		http.Error(w, "okapi failure", okapi.status)
+		return
+	}
+	switch r.URL.Path {
+	case "/instance-storage/instances":
+		resp := folioInstances{}
+		for _, term := range strings.Split(r.URL.Query().Get("query"), " or ") {
+			if term == `hrid=="in00001"` {
+				resp.Instances = append(resp.Instances, struct {
+					ID   string `json:"id"`
+					HRID string `json:"hrid"`
+				}{ID: testInstanceID, HRID: "in00001"})
+				resp.TotalRecords++
+			}
+		}
+		json.NewEncoder(w).Encode(resp)
+	case "/rtac/" + testInstanceID:
+		json.NewEncoder(w).Encode(okapi.rtac)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// expireToken makes Okapi reject the token the backend has cached
+func (okapi *fakeOkapi) expireToken() {
+	okapi.lock.Lock()
+	defer okapi.lock.Unlock()
+	okapi.token = "expired"
+}
+
+func newTestFolio(t *testing.T) (*folioBackend, *fakeOkapi) {
+	t.Helper()
+	okapi := &fakeOkapi{}
+	server := httptest.NewServer(okapi)
+	t.Cleanup(server.Close)
+	svc := &ServiceContext{HTTPClient: server.Client(), FastHTTPClient: server.Client(), SlowHTTPClient: server.Client()}
+	svc.ILSBreaker = newCircuitBreaker("test_folio", BreakerConfig{Failures: 5, Cooldown: time.Minute})
+	cfg := FOLIOConfig{URL: server.URL, Tenant: "diku", User: "v4", Pass: "secret"}
+	return newFolioBackend(svc, cfg), okapi
+}
+
+func TestFolioTokenRefresh(t *testing.T) {
+	folio, okapi := newTestFolio(t)
+	ctx := context.Background()
+	if _, err := folio.GetAvailability(ctx, testInstanceID, ""); err != nil {
+		t.Fatalf("GetAvailability: %+v", err)
+	}
+	if _, err := folio.GetAvailability(ctx, testInstanceID, ""); err != nil {
+		t.Fatalf("GetAvailability: %+v", err)
+	}
+	if okapi.logins != 1 {
+		t.Errorf("logins = %d; want the token reused", okapi.logins)
+	}
+
+	okapi.expireToken()
+	if _, err := folio.GetAvailability(ctx, testInstanceID, ""); err != nil {
+		t.Fatalf("GetAvailability after token expired: %+v", err)
+	}
+	if okapi.logins != 2 || folio.token != "token-2" {
+		t.Errorf("logins = %d, token = %s; want a single login for the new token-2", okapi.logins, folio.token)
+	}
+}
+
+func TestFolioLoginFailure(t *testing.T) {
+	folio, okapi := newTestFolio(t)
+	folio.Config.Pass = "wrong"
+	_, err := folio.GetAvailability(context.Background(), testInstanceID, "")
+	if err == nil || err.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("err = %+v; want the 422 login failure", err)
+	}
+	if len(okapi.requests) != 1 {
+		t.Errorf("requests = %v; want only the login", okapi.requests)
+	}
+}
+
+func TestFolioHRIDResolution(t *testing.T) {
+	folio, okapi := newTestFolio(t)
+	ctx := context.Background()
+	id, err := folio.resolveInstanceID(ctx, "in00001")
+	if err != nil || id != testInstanceID {
+		t.Fatalf("resolveInstanceID(in00001) = %s, %+v; want %s", id, err, testInstanceID)
+	}
+	if _, err = folio.resolveInstanceID(ctx, "in99999"); err == nil || err.StatusCode != http.StatusNotFound {
+		t.Errorf("resolveInstanceID(in99999) err = %+v; want 404", err)
+	}
+
+	// UUIDs are used as is without a lookup
+	before := len(okapi.requests)
+	if id, err = folio.resolveInstanceID(ctx, testInstanceID); err != nil || id != testInstanceID {
+		t.Errorf("resolveInstanceID(uuid) = %s, %+v", id, err)
+	}
+	if len(okapi.requests) != before {
+		t.Errorf("a UUID was looked up: %v", okapi.requests[before:])
+	}
+}
+
+func TestFolioRTACItems(t *testing.T) {
+	folio, okapi := newTestFolio(t)
+	okapi.rtac.Holdings = []folioHolding{
+		{Barcode: "X001", CallNumber: "PS3545 .I345", Location: "Stacks", LocationCode: "AL-STACKS", Status: "Available", Volume: "v.1"},
+		{Barcode: "X002", CallNumber: "PS3545 .I345", Location: "Stacks", LocationCode: "AL-STACKS", Status: "Checked out",
+			DueDate: "2026-11-01T04:59:00.000+00:00", HoldCount: 2},
+		{Barcode: "X003", Location: "Stacks", LocationCode: "AL-STACKS", Status: "Missing"},
+	}
+	okapi.rtac.Holdings[0].Library.Name = "Alderman"
+	okapi.rtac.Holdings[0].Library.Code = "AL"
+
+	resp, err := folio.GetAvailability(context.Background(), "in00001", "")
+	if err != nil {
+		t.Fatalf("GetAvailability: %+v", err)
+	}
+	items := resp.Availability.Items
+	if resp.Availability.ID != "in00001" || len(items) != 3 {
+		t.Fatalf("availability = %+v; want 3 items for in00001", resp.Availability)
+	}
+	want := []Item{
+		{Barcode: "X001", OnShelf: true, Library: "Alderman", LibraryID: "AL", CurrentLocation: "Stacks",
+			CurrentLocationID: "AL-STACKS", HomeLocationID: "AL-STACKS", CallNumber: "PS3545 .I345", Volume: "v.1"},
+		{Barcode: "X002", Notice: "Checked out, due 2026-11-01T04:59:00.000+00:00", CurrentLocation: "Stacks",
+			CurrentLocationID: "AL-STACKS", HomeLocationID: "AL-STACKS", CallNumber: "PS3545 .I345",
+			DueDate: "2026-11-01T04:59:00.000+00:00", HoldCount: 2},
+		{Barcode: "X003", Unavailable: true, Notice: "Missing", CurrentLocation: "Stacks",
+			CurrentLocationID: "AL-STACKS", HomeLocationID: "AL-STACKS"},
+	}
+	for idx, item := range items {
+		if *item != want[idx] {
+			t.Errorf("item %d = %+v; want %+v", idx, *item, want[idx])
+		}
+	}
+}
+
+func TestFolioErrors(t *testing.T) {
+	folio, okapi := newTestFolio(t)
+	ctx := context.Background()
+	if _, err := folio.GetAvailability(ctx, "in99999", ""); err == nil || err.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown HRID err = %+v; want 404", err)
+	}
+	okapi.status = http.StatusBadGateway
+	if _, err := folio.GetAvailability(ctx, testInstanceID, ""); err == nil || err.StatusCode != http.StatusBadGateway {
+		t.Errorf("okapi failure err = %+v; want 502", err)
+	}
+}
+
+func TestFolioLoginCanceled(t *testing.T) {
+	folio, okapi := newTestFolio(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := folio.getToken(ctx, false); err == nil {
+		t.Fatal("login with a canceled context succeeded")
+	}
+	if okapi.logins != 0 || folio.token != "" {
+		t.Errorf("logins = %d, token = %q; want the login abandoned", okapi.logins, folio.token)
+	}
+}
+
+func TestFolioValidateReserves(t *testing.T) {
+	folio, okapi := newTestFolio(t)
+	okapi.rtac.Holdings = []folioHolding{{Barcode: "X001", Status: "Available"}, {Barcode: "X002", Status: "Available"}}
+	okapi.rtac.Holdings[1].MaterialType.Name = "DVD"
+
+	resp, err := folio.ValidateReserves(context.Background(), []string{"in00001", "in99999", testInstanceID}, "")
+	if err != nil {
+		t.Fatalf("ValidateReserves: %+v", err)
+	}
+	want := []validateResponse{{ID: "in00001", Reserve: true, IsVideo: true}, {ID: "in99999"}, {ID: testInstanceID, Reserve: true, IsVideo: true}}
+	if len(resp) != len(want) {
+		t.Fatalf("response = %+v; want %+v", resp, want)
+	}
+	for idx := range want {
+		if resp[idx] != want[idx] {
+			t.Errorf("title %d = %+v; want %+v", idx, resp[idx], want[idx])
+		}
+	}
+
+	// one login, one instance query for both HRIDs and an RTAC request for each title that was found
+	lookups := make([]string, 0)
+	for _, req := range okapi.requests {
+		if strings.HasPrefix(req, "/instance-storage/instances") {
+			lookups = append(lookups, req)
+		}
+	}
+	if len(lookups) != 1 || strings.Contains(lookups[0], "limit=2") == false || len(okapi.requests) != 4 {
+		t.Errorf("requests = %v; want the HRIDs resolved in a single query", okapi.requests)
+	}
+}
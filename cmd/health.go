@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/smtp"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type hcResp struct {
+	Healthy   bool   `json:"healthy"`
+	Message   string `json:"message,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+	Breaker   string `json:"circuit_breaker,omitempty"`
+}
+
+// dependencyCheck is a named health check of one service dependency. Breaker is set for upstreams
+// that are called through a circuit breaker so its state is reported with the check.
+type dependencyCheck struct {
+	Name    string
+	Check   func() error
+	Breaker *circuitBreaker
+}
+
+// HealthCheck reports the health of the server and all of its dependencies, including the ILS
+func (svc *ServiceContext) healthCheck(c *gin.Context) {
+	checks := svc.readinessChecks()
+	checks = append(checks, dependencyCheck{Name: svc.ILS.Name(), Check: svc.checkILS, Breaker: svc.ILSBreaker},
+		dependencyCheck{Name: "templates", Check: svc.checkReserveTemplates})
+	if svc.SMTP.DevMode == false {
+		checks = append(checks, dependencyCheck{Name: "smtp", Check: svc.checkSMTP})
+	}
+	hcMap := runHealthChecks(c.Request.Context(), checks)
+	c.JSON(http.StatusOK, hcMap)
+}
+
+// liveCheck reports that the service is running. It has no dependencies; if this fails the container should be restarted
+func (svc *ServiceContext) liveCheck(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"alive": true})
+}
+
+// readyCheck reports if this instance can handle requests. A 503 means traffic should not be routed here.
+// The ILS is not sent a health request; it is ready unless its circuit breaker is open. While the breaker
+// is closed or probing, requests are answered from the ILS, or from the cache and Solr if it fails.
+func (svc *ServiceContext) readyCheck(c *gin.Context) {
+	checks := append(svc.readinessChecks(), dependencyCheck{Name: svc.ILS.Name(), Check: svc.checkILSBreaker, Breaker: svc.ILSBreaker})
+	hcMap := runHealthChecks(c.Request.Context(), checks)
+	status := http.StatusOK
+	for _, hc := range hcMap {
+		if hc.Healthy == false {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	c.JSON(status, hcMap)
+}
+
+// readinessChecks are the local state and connections an instance needs to serve requests
+func (svc *ServiceContext) readinessChecks() []dependencyCheck {
+	return []dependencyCheck{
+		{Name: "config", Check: svc.checkConfig},
+		{Name: "solr", Check: svc.checkSolr, Breaker: svc.SolrBreaker},
+		{Name: "postgres", Check: svc.checkDB},
+	}
+}
+
+// runHealthChecks runs all checks at the same time and reports the result and latency of each
+func runHealthChecks(ctx context.Context, checks []dependencyCheck) map[string]hcResp {
+	hcMap := make(map[string]hcResp)
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for _, dc := range checks {
+		wg.Add(1)
+		go func(dc dependencyCheck) {
+			defer wg.Done()
+			start := time.Now()
+			err := dc.Check()
+			resp := hcResp{Healthy: true, LatencyMS: int64(time.Since(start) / time.Millisecond)}
+			if err != nil {
+				slog.ErrorContext(ctx, "health check failed", "dependency", dc.Name, "error", err.Error())
+				resp.Healthy = false
+				resp.Message = err.Error()
+			}
+			if dc.Breaker != nil {
+				resp.Breaker = dc.Breaker.State()
+			}
+			lock.Lock()
+			hcMap[dc.Name] = resp
+			lock.Unlock()
+		}(dc)
+	}
+	wg.Wait()
+	return hcMap
+}
+
+func (svc *ServiceContext) checkILS() error {
+	if ilsErr := svc.ILS.Health(); ilsErr != nil {
+		return fmt.Errorf("%d - %s", ilsErr.StatusCode, ilsErr.Message)
+	}
+	return nil
+}
+
+// checkILSBreaker fails while the ILS circuit breaker is open
+func (svc *ServiceContext) checkILSBreaker() error {
+	if svc.ILSBreaker.State() == breakerOpen {
+		return fmt.Errorf("%s circuit breaker is open", svc.ILS.Name())
+	}
+	return nil
+}
+
+func (svc *ServiceContext) checkSolr() error {
+	pingURL := fmt.Sprintf("%s/%s/admin/ping", svc.Solr.URL, svc.Solr.Core)
+	resp, err := svc.FastHTTPClient.Get(pingURL)
+	if _, solrErr := handleAPIResponse(pingURL, resp, err); solrErr != nil {
+		return fmt.Errorf("%d - %s", solrErr.StatusCode, solrErr.Message)
+	}
+	return nil
+}
+
+func (svc *ServiceContext) checkDB() error {
+	if svc.DB == nil {
+		return errors.New("no database connection")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return svc.DB.PingContext(ctx)
+}
+
+// checkSMTP connects to the SMTP server and waits for its greeting
+func (svc *ServiceContext) checkSMTP() error {
+	addr := net.JoinHostPort(svc.SMTP.Host, fmt.Sprintf("%d", svc.SMTP.Port))
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	client, err := smtp.NewClient(conn, svc.SMTP.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	return client.Quit()
+}
+
+// checkConfig makes sure the data loaded at startup is in place
+func (svc *ServiceContext) checkConfig() error {
+	if len(svc.Maps) == 0 {
+		return errors.New("no maps loaded")
+	}
+	if len(svc.MapLookups) == 0 {
+		return errors.New("no map lookups loaded")
+	}
+	if svc.Rules == nil {
+		return errors.New("no request option rules loaded")
+	}
+	if svc.ReserveRoutes == nil {
+		return errors.New("no reserve routes loaded")
+	}
+	return nil
+}
+
+func (svc *ServiceContext) checkReserveTemplates() error {
+	for _, reserveTpl := range append(svc.ReserveRoutes.templates(), receiptTemplate) {
+		if _, _, err := reserveTpl.parse(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
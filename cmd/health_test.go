@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pingConnector is a database connector for the postgres health check; connecting fails with err, if it is set
+type pingConnector struct {
+	err error
+}
+
+func (pc pingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	if pc.err != nil {
+		return nil, pc.err
+	}
+	return pingConn{}, nil
+}
+
+func (pc pingConnector) Driver() driver.Driver {
+	return nil
+}
+
+// pingConn is a connection that can only be pinged
+type pingConn struct{}
+
+func (pingConn) Prepare(query string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (pingConn) Close() error                              { return nil }
+func (pingConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+func (pingConn) Ping(ctx context.Context) error            { return nil }
+
+// newHealthService is a service that is ready; its ILS and Solr are fake upstreams and its startup data is loaded
+func newHealthService(t *testing.T) *ServiceContext {
+	t.Helper()
+	svc := newTestUpstreams(t, 0)
+	svc.DB = sql.OpenDB(pingConnector{})
+	t.Cleanup(func() { svc.DB.Close() })
+	svc.Maps = []Map{{}}
+	svc.MapLookups = []MapLookup{{}}
+	svc.Rules = &requestRules{}
+	svc.ReserveRoutes = &reserveRoutes{}
+	return svc
+}
+
+// getHealth gets a health endpoint and returns the status and the checks in the response
+func getHealth(t *testing.T, handler gin.HandlerFunc) (int, map[string]hcResp) {
+	t.Helper()
+	router := gin.New()
+	router.GET("/health", handler)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	checks := make(map[string]hcResp)
+	if err := json.Unmarshal(w.Body.Bytes(), &checks); err != nil {
+		t.Fatalf("unable to parse %s: %s", w.Body.String(), err.Error())
+	}
+	return w.Code, checks
+}
+
+func TestLiveCheck(t *testing.T) {
+	// nothing is loaded or connected; the service is still alive
+	svc := &ServiceContext{}
+	router := gin.New()
+	router.GET("/healthz/live", svc.liveCheck)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/healthz/live", nil))
+	if w.Code != http.StatusOK || w.Body.String() != `{"alive":true}` {
+		t.Errorf("live = %d %s; want 200 alive", w.Code, w.Body.String())
+	}
+}
+
+func TestReadyCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		change    func(svc *ServiceContext)
+		unhealthy string
+	}{
+		{"ready", func(svc *ServiceContext) {}, ""},
+		{"ILS breaker half open", func(svc *ServiceContext) {
+			svc.ILSBreaker = newCircuitBreaker("test_ils", BreakerConfig{Failures: 1, Cooldown: -time.Second})
+			svc.ILSBreaker.done(context.Background(), false, &RequestError{StatusCode: http.StatusBadGateway})
+		}, ""},
+		{"ILS breaker open", func(svc *ServiceContext) {
+			svc.ILSBreaker = newCircuitBreaker("test_ils", BreakerConfig{Failures: 1, Cooldown: time.Minute})
+			svc.ILSBreaker.done(context.Background(), false, &RequestError{StatusCode: http.StatusBadGateway})
+		}, "ils_connector"},
+		{"solr down", func(svc *ServiceContext) {
+			svc.Solr.URL = "http://127.0.0.1:1"
+		}, "solr"},
+		{"postgres down", func(svc *ServiceContext) {
+			svc.DB = sql.OpenDB(pingConnector{err: errors.New("connection refused")})
+		}, "postgres"},
+		{"no rules", func(svc *ServiceContext) {
+			svc.Rules = nil
+		}, "config"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			svc := newHealthService(t)
+			test.change(svc)
+			status, checks := getHealth(t, svc.readyCheck)
+
+			wantStatus := http.StatusOK
+			if test.unhealthy != "" {
+				wantStatus = http.StatusServiceUnavailable
+			}
+			if status != wantStatus {
+				t.Errorf("status = %d; want %d", status, wantStatus)
+			}
+			for _, name := range []string{"config", "solr", "postgres", "ils_connector"} {
+				check, found := checks[name]
+				if found == false {
+					t.Errorf("%s is not checked", name)
+					continue
+				}
+				if check.Healthy != (name != test.unhealthy) {
+					t.Errorf("%s = %+v; want healthy %t", name, check, name != test.unhealthy)
+				}
+			}
+			if len(checks) != 4 {
+				t.Errorf("checks = %v; want only config, solr, postgres and the ILS", checks)
+			}
+			if checks["ils_connector"].Breaker != svc.ILSBreaker.State() {
+				t.Errorf("ILS breaker = %s; want %s", checks["ils_connector"].Breaker, svc.ILSBreaker.State())
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/uvalib/virgo4-availability-ws/ilsclient"
+)
+
+// ILSBackend is the set of ILS operations used by the service. Implementations map
+// their native responses into the service availability types.
+type ILSBackend interface {
+	// Name identifies the backend in logs and health checks
+	Name() string
+	// GetAvailability gets the availability of a title. A 404 error means the ILS does not know the title.
+	GetAvailability(ctx context.Context, titleID string, jwt string) (*AvailabilityData, *RequestError)
+	// ValidateReserves checks if a list of titles can be placed on course reserve
+	ValidateReserves(ctx context.Context, titleIDs []string, jwt string) ([]validateResponse, *RequestError)
+	// Health checks if the ILS is reachable
+	Health() *RequestError
+}
+
+// newILSBackend creates the ILS backend selected in the config
+func newILSBackend(svc *ServiceContext, cfg *ServiceConfig) (ILSBackend, error) {
+	switch cfg.ILSBackend {
+	case "connector":
+		client, err := ilsclient.New(cfg.ILSAPI, svc.HTTPClient, svc.SlowHTTPClient, observeILS)
+		if err != nil {
+			return nil, err
+		}
+		return &ilsConnector{client: client, breaker: svc.ILSBreaker}, nil
+	case "folio":
+		return newFolioBackend(svc, cfg.FOLIO), nil
+	}
+	return nil, fmt.Errorf("%s is not a supported ILS backend", cfg.ILSBackend)
+}
+
+// ilsConnector is the ILSBackend for the Sirsi oriented ILS Connector
+type ilsConnector struct {
+	client  ilsclient.Client
+	breaker *circuitBreaker
+}
+
+func (ils *ilsConnector) Name() string {
+	return "ils_connector"
+}
+
+func (ils *ilsConnector) GetAvailability(ctx context.Context, titleID string, jwt string) (*AvailabilityData, *RequestError) {
+	var resp *ilsclient.AvailabilityResponse
+	ilsErr := ils.breaker.call(ctx, "GET", func() *RequestError {
+		var err error
+		resp, err = ils.client.GetAvailability(ctx, titleID, jwt)
+		return ilsRequestError(err)
+	})
+	if ilsErr != nil {
+		return &AvailabilityData{}, ilsErr
+	}
+	return availabilityFromILS(&resp.Availability), nil
+}
+
+func (ils *ilsConnector) ValidateReserves(ctx context.Context, titleIDs []string, jwt string) ([]validateResponse, *RequestError) {
+	var resp []ilsclient.ValidateResult
+	ilsErr := ils.breaker.call(ctx, "POST", func() *RequestError {
+		var err error
+		resp, err = ils.client.ValidateReserves(ctx, titleIDs, jwt)
+		return ilsRequestError(err)
+	})
+	if ilsErr != nil {
+		return nil, ilsErr
+	}
+	out := make([]validateResponse, 0, len(resp))
+	for _, r := range resp {
+		out = append(out, validateResponse{ID: r.ID, Reserve: r.Reserve, IsVideo: r.IsVideo})
+	}
+	return out, nil
+}
+
+func (ils *ilsConnector) Health() *RequestError {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := ils.client.Version(ctx); err != nil {
+		return ilsRequestError(err)
+	}
+	return nil
+}
+
+// ilsRequestError converts an ILS Connector client error into a RequestError
+func ilsRequestError(err error) *RequestError {
+	if err == nil {
+		return nil
+	}
+	var ilsErr *ilsclient.Error
+	if errors.As(err, &ilsErr) {
+		return &RequestError{StatusCode: ilsErr.StatusCode, Message: ilsErr.Message}
+	}
+	return &RequestError{StatusCode: http.StatusInternalServerError, Message: err.Error()}
+}
+
+// observeILS records ILS Connector request metrics
+func observeILS(method string, elapsed time.Duration, err *ilsclient.Error) {
+	var reqErr *RequestError
+	if err != nil {
+		reqErr = &RequestError{StatusCode: err.StatusCode, Message: err.Message}
+	}
+	observeUpstream("ils", method, elapsed, reqErr)
+}
+
+// availabilityFromILS converts the ILS Connector availability model into the service response
+func availabilityFromILS(ils *ilsclient.Availability) *AvailabilityData {
+	availResp := AvailabilityData{}
+	avail := &availResp.Availability
+	avail.ID = ils.TitleID
+	avail.Display = ils.Display
+	if ils.Items != nil {
+		avail.Items = make([]*Item, 0, len(ils.Items))
+	}
+	for _, i := range ils.Items {
+		avail.Items = append(avail.Items, &Item{
+			Barcode:           i.Barcode,
+			OnShelf:           i.OnShelf,
+			Unavailable:       i.Unavailable,
+			Notice:            i.Notice,
+			Library:           i.Library,
+			LibraryID:         i.LibraryID,
+			CurrentLocation:   i.CurrentLocation,
+			CurrentLocationID: i.CurrentLocationID,
+			HomeLocationID:    i.HomeLocationID,
+			CallNumber:        i.CallNumber,
+			Volume:            i.Volume,
+			SCNotes:           i.SCNotes,
+			DueDate:           i.DueDate,
+			ExpectedAvailable: i.ExpectedAvailable,
+			HoldCount:         i.HoldCount,
+		})
+	}
+	if ils.RequestOptions != nil {
+		avail.RequestOptions = make([]RequestOption, 0, len(ils.RequestOptions))
+	}
+	for _, o := range ils.RequestOptions {
+		opt := RequestOption{
+			Type:             o.Type,
+			Label:            o.Label,
+			Description:      o.Description,
+			CreateURL:        o.CreateURL,
+			SignInRequired:   o.SignInRequired,
+			StreamingReserve: o.StreamingReserve,
+		}
+		if o.ItemOptions != nil {
+			opt.ItemOptions = make([]ItemOption, 0, len(o.ItemOptions))
+		}
+		for _, io := range o.ItemOptions {
+			opt.ItemOptions = append(opt.ItemOptions, ItemOption(io))
+		}
+		avail.RequestOptions = append(avail.RequestOptions, opt)
+	}
+	if ils.BoundWith != nil {
+		avail.BoundWith = make([]BoundWithItem, 0, len(ils.BoundWith))
+	}
+	for _, bw := range ils.BoundWith {
+		avail.BoundWith = append(avail.BoundWith, BoundWithItem(bw))
+	}
+	return &availResp
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+var volumeYearRegex = regexp.MustCompile(`\b(1[5-9]\d\d|20\d\d)\b`)
+
+// itemQuery is the optional filtering and paging of the items in an availability response
+type itemQuery struct {
+	Library   string
+	Available bool
+	Offset    int
+	Limit     int
+}
+
+// parseItemQuery reads ?library=, ?available=true, ?offset= and ?limit= from a request. Limit 0 is no limit.
+func parseItemQuery(c *gin.Context) (*itemQuery, error) {
+	q := itemQuery{Library: strings.TrimSpace(c.Query("library")), Available: c.Query("available") == "true"}
+	if raw := c.Query("offset"); raw != "" {
+		offset, err := strconv.Atoi(raw)
+		if err != nil || offset < 0 {
+			return nil, errors.New("offset must be a number zero or greater")
+		}
+		q.Offset = offset
+	}
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			return nil, errors.New("limit must be a number greater than zero")
+		}
+		q.Limit = limit
+	}
+	return &q, nil
+}
+
+func (q *itemQuery) isSet() bool {
+	return q.Library != "" || q.Available || q.Offset > 0 || q.Limit > 0
+}
+
+// apply filters the items, groups them by volume and then pages them. The item total and groups are for
+// the filtered items so clients can page through all of them.
+func (q *itemQuery) apply(availResp *AvailabilityData) {
+	items := make([]*Item, 0)
+	for _, item := range availResp.Availability.Items {
+		if q.Library != "" && strings.EqualFold(item.Library, q.Library) == false && strings.EqualFold(item.LibraryID, q.Library) == false {
+			continue
+		}
+		if q.Available && item.Status != statusAvailable {
+			continue
+		}
+		items = append(items, item)
+	}
+	availResp.Availability.TotalItems = len(items)
+	availResp.Availability.Groups = groupVolumes(items)
+
+	start := q.Offset
+	if start > len(items) {
+		start = len(items)
+	}
+	end := len(items)
+	if q.Limit > 0 && start+q.Limit < end {
+		end = start + q.Limit
+	}
+	availResp.Availability.Items = items[start:end]
+}
+
+// sortItems orders serial and multi-volume items by volume enumeration, then by call number. Items of
+// titles without volumes are left in ILS order.
+func sortItems(items []*Item) {
+	hasVolumes := false
+	for _, item := range items {
+		if strings.TrimSpace(item.Volume) != "" {
+			hasVolumes = true
+			break
+		}
+	}
+	if hasVolumes == false {
+		return
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if cmp := compareEnumeration(items[i].Volume, items[j].Volume); cmp != 0 {
+			return cmp < 0
+		}
+		return callNumberSortKey(items[i].CallNumber) < callNumberSortKey(items[j].CallNumber)
+	})
+}
+
+// groupVolumes groups sorted items by the year in their volume, or by the first part of the volume
+// ("v.12") if there is no year. Each group has the offset of its first item. Items without volumes,
+// or with a blank volume, are not grouped.
+func groupVolumes(items []*Item) []*ItemGroup {
+	groups := make([]*ItemGroup, 0)
+	for idx, item := range items {
+		volume := strings.Fields(item.Volume)
+		if len(volume) == 0 {
+			continue
+		}
+		label := volumeYearRegex.FindString(item.Volume)
+		if label == "" {
+			label = volume[0]
+		}
+		if len(groups) == 0 || groups[len(groups)-1].Label != label {
+			groups = append(groups, &ItemGroup{Label: label, Offset: idx})
+		}
+		group := groups[len(groups)-1]
+		group.Count++
+		if item.Status == statusAvailable {
+			group.Available++
+		}
+	}
+	if len(groups) == 0 {
+		return nil
+	}
+	return groups
+}
+
+// compareEnumeration compares volume strings like "v.12 no.3 1998" so numbers sort by value
+// ("v.2" before "v.10"). Text is compared case insensitive; empty or blank volumes sort last.
+func compareEnumeration(a string, b string) int {
+	a = strings.TrimSpace(a)
+	b = strings.TrimSpace(b)
+	if a == "" || b == "" {
+		if a == b {
+			return 0
+		}
+		if a == "" {
+			return 1
+		}
+		return -1
+	}
+	aParts := enumerationParts(a)
+	bParts := enumerationParts(b)
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		aNum, aErr := strconv.Atoi(aParts[i])
+		bNum, bErr := strconv.Atoi(bParts[i])
+		if aErr == nil && bErr == nil {
+			if aNum != bNum {
+				if aNum < bNum {
+					return -1
+				}
+				return 1
+			}
+			continue
+		}
+		if cmp := strings.Compare(aParts[i], bParts[i]); cmp != 0 {
+			return cmp
+		}
+	}
+	return len(aParts) - len(bParts)
+}
+
+// enumerationParts splits a volume into lowercase runs of letters and runs of digits
+func enumerationParts(volume string) []string {
+	parts := make([]string, 0)
+	var current []rune
+	currentDigits := false
+	for _, r := range strings.ToLower(volume) {
+		isDigit := unicode.IsDigit(r)
+		if unicode.IsLetter(r) == false && isDigit == false {
+			if len(current) > 0 {
+				parts = append(parts, string(current))
+				current = nil
+			}
+			continue
+		}
+		if len(current) > 0 && isDigit != currentDigits {
+			parts = append(parts, string(current))
+			current = nil
+		}
+		current = append(current, r)
+		currentDigits = isDigit
+	}
+	if len(current) > 0 {
+		parts = append(parts, string(current))
+	}
+	return parts
+}
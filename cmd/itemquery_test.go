@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseItemQuery(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    itemQuery
+		wantErr bool
+	}{
+		{"", itemQuery{}, false},
+		{"library=%20Alderman%20&available=true", itemQuery{Library: "Alderman", Available: true}, false},
+		{"available=yes", itemQuery{}, false},
+		{"offset=0&limit=20", itemQuery{Limit: 20}, false},
+		{"offset=40&limit=20", itemQuery{Offset: 40, Limit: 20}, false},
+		{"offset=-1", itemQuery{}, true},
+		{"offset=ten", itemQuery{}, true},
+		{"limit=0", itemQuery{}, true},
+		{"limit=-5", itemQuery{}, true},
+		{"limit=1.5", itemQuery{}, true},
+	}
+	for _, test := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/item/u1?"+test.query, nil)
+		got, err := parseItemQuery(c)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parseItemQuery(%s) = %+v; want an error", test.query, got)
+			}
+			continue
+		}
+		if err != nil || *got != test.want {
+			t.Errorf("parseItemQuery(%s) = %+v, %v; want %+v", test.query, got, err, test.want)
+		}
+	}
+}
+
+func TestCompareEnumeration(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"v.2", "v.10", -1},
+		{"v.10", "v.2", 1},
+		{"V.2", "v.2", 0},
+		{"v.2 no.3", "v.2 no.12", -1},
+		{"v.2", "v.2 no.1", -1},
+		{"1998", "2001", -1},
+		{"v.1", "", -1},
+		{"", "v.1", 1},
+		{"", "", 0},
+		{"   ", "v.1", 1},
+		{"  ", "", 0},
+		{"c.1", "v.1", -1},
+		{" v.3 ", "v.3", 0},
+	}
+	for _, test := range tests {
+		got := compareEnumeration(test.a, test.b)
+		if (got < 0) != (test.want < 0) || (got > 0) != (test.want > 0) {
+			t.Errorf("compareEnumeration(%q, %q) = %d; want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestGroupVolumes(t *testing.T) {
+	item := func(volume string, status string) *Item { return &Item{Volume: volume, Status: status} }
+	tests := []struct {
+		name  string
+		items []*Item
+		want  []*ItemGroup
+	}{
+		{"no items", nil, nil},
+		{"no volumes", []*Item{item("", statusAvailable), item("", statusCheckedOut)}, nil},
+		{"blank volumes", []*Item{item(" ", statusAvailable), item("\t", statusAvailable)}, nil},
+		{"by year", []*Item{item("v.1 1998", statusAvailable), item("v.2 1998", statusCheckedOut), item("v.3 1999", statusAvailable)},
+			[]*ItemGroup{{Label: "1998", Offset: 0, Count: 2, Available: 1}, {Label: "1999", Offset: 2, Count: 1, Available: 1}}},
+		{"by first part", []*Item{item("v.1 no.1", statusAvailable), item("v.1 no.2", statusAvailable), item("v.2 no.1", statusMissing)},
+			[]*ItemGroup{{Label: "v.1", Offset: 0, Count: 2, Available: 2}, {Label: "v.2", Offset: 2, Count: 1}}},
+		{"blank and padded volumes", []*Item{item("  v.1 ", statusAvailable), item("   ", statusAvailable), item("v.1", statusCheckedOut)},
+			[]*ItemGroup{{Label: "v.1", Offset: 0, Count: 2, Available: 1}}},
+		{"items without volumes keep offsets", []*Item{item("", statusAvailable), item("c.2", statusAvailable)},
+			[]*ItemGroup{{Label: "c.2", Offset: 1, Count: 1, Available: 1}}},
+	}
+	for _, test := range tests {
+		got := groupVolumes(test.items)
+		if reflect.DeepEqual(got, test.want) == false {
+			t.Errorf("%s: groupVolumes = %s; want %s", test.name, groupsString(got), groupsString(test.want))
+		}
+	}
+}
+
+func groupsString(groups []*ItemGroup) string {
+	out := make([]string, 0)
+	for _, g := range groups {
+		out = append(out, fmt.Sprintf("%+v", *g))
+	}
+	return fmt.Sprintf("%v", out)
+}
+
+func TestSortItemsBlankVolumes(t *testing.T) {
+	items := []*Item{{Barcode: "A", Volume: " "}, {Barcode: "B", Volume: "v.10"}, {Barcode: "C", Volume: "v.2"}, {Barcode: "D"}}
+	sortItems(items)
+	order := ""
+	for _, item := range items {
+		order += item.Barcode
+	}
+	if order != "CBAD" {
+		t.Errorf("sorted order = %s; want volumes first, then blank and empty volumes in ILS order", order)
+	}
+}
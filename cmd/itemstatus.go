@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Normalized item statuses. Clients should use these rather than interpret notices or location codes.
+const (
+	statusAvailable      = "available"
+	statusCheckedOut     = "checked_out"
+	statusInTransit      = "in_transit"
+	statusOnHoldShelf    = "on_hold_shelf"
+	statusOnOrder        = "on_order"
+	statusInProcessing   = "in_processing"
+	statusMissing        = "missing"
+	statusLost           = "lost"
+	statusNonCirculating = "non_circulating"
+	statusUnavailable    = "unavailable"
+	statusUnknown        = "unknown"
+)
+
+var itemStatusLabels = map[string]string{
+	statusAvailable:      "Available",
+	statusCheckedOut:     "Checked out",
+	statusInTransit:      "In transit",
+	statusOnHoldShelf:    "On hold shelf",
+	statusOnOrder:        "On order",
+	statusInProcessing:   "In processing",
+	statusMissing:        "Missing",
+	statusLost:           "Lost",
+	statusNonCirculating: "Library use only",
+	statusUnavailable:    "Unavailable",
+	statusUnknown:        "Status unknown",
+}
+
+// ILS location codes (Sirsi) and item statuses (FOLIO) with upper case letters only, mapped to a status
+var ilsItemStatus = map[string]string{
+	"AVAILABLE":               statusAvailable,
+	"CHECKEDOUT":              statusCheckedOut,
+	"INTRANSIT":               statusInTransit,
+	"HOLDS":                   statusOnHoldShelf,
+	"AWAITINGPICKUP":          statusOnHoldShelf,
+	"AWAITINGDELIVERY":        statusOnHoldShelf,
+	"ONORDER":                 statusOnOrder,
+	"ORDERED":                 statusOnOrder,
+	"INPROCESS":               statusInProcessing,
+	"INPROCESSNONREQUESTABLE": statusInProcessing,
+	"CATALOGING":              statusInProcessing,
+	"MISSING":                 statusMissing,
+	"LONGMISSING":             statusMissing,
+	"CLAIMEDRETURNED":         statusMissing,
+	"LOST":                    statusLost,
+	"LOSTASSUM":               statusLost,
+	"LOSTCLAIM":               statusLost,
+	"DECLAREDLOST":            statusLost,
+	"AGEDTOLOST":              statusLost,
+	"LOSTANDPAID":             statusLost,
+	"WITHDRAWN":               statusLost,
+	"DISCARD":                 statusLost,
+	"NONCIRC":                 statusNonCirculating,
+	"RESTRICTED":              statusNonCirculating,
+	"BINDERY":                 statusUnavailable,
+	"UNAVAILABLE":             statusUnavailable,
+}
+
+// date layouts accepted for due and expected dates from the ILS
+var ilsDateLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02", "1/2/2006 15:04", "1/2/2006", "Jan 2, 2006", "Jan 2 2006", "January 2, 2006"}
+
+// due dates and hold counts in an ILS notice, like "Checked out, due 11/3/2026 (2 holds)"
+var (
+	noticeDueRegex   = regexp.MustCompile(`(?i)\bdue(?:\s+date)?:?\s+([^;()]+)`)
+	noticeHoldsRegex = regexp.MustCompile(`(?i)\b(\d+)\s+holds?\b`)
+)
+
+// normalizeItemStatus sets the status and status label of an item from the ILS location, notice and
+// shelf flags. The ILS Connector only has a due date and hold count in the notice; they are parsed from
+// it when the item doesn't have them. Due and expected dates are converted to YYYY-MM-DD.
+func normalizeItemStatus(item *Item) {
+	item.Status = itemStatus(item)
+	if item.DueDate == "" {
+		item.DueDate = noticeDueDate(item.Notice)
+	}
+	if item.HoldCount == 0 {
+		item.HoldCount = noticeHoldCount(item.Notice)
+	}
+	due := parseILSDate(item.DueDate)
+	if due != nil {
+		item.DueDate = due.Format("2006-01-02")
+	}
+	expected := parseILSDate(item.ExpectedAvailable)
+	if expected != nil {
+		item.ExpectedAvailable = expected.Format("2006-01-02")
+	}
+
+	label := itemStatusLabels[item.Status]
+	if item.StatusUnverified {
+		label = "Status unverified"
+	}
+	if due != nil {
+		label += fmt.Sprintf(", due %s", labelDate(*due))
+	} else if expected != nil {
+		label += fmt.Sprintf(", expected %s", labelDate(*expected))
+	}
+	if item.HoldCount == 1 {
+		label += " (1 hold)"
+	} else if item.HoldCount > 1 {
+		label += fmt.Sprintf(" (%d holds)", item.HoldCount)
+	}
+	item.StatusLabel = label
+}
+
+func itemStatus(item *Item) string {
+	if item.StatusUnverified {
+		return statusUnknown
+	}
+	// the notice is free text; only the part before any detail like a due date can be a status
+	notice := strings.Split(item.Notice, ",")[0]
+	for _, candidate := range []string{item.CurrentLocationID, item.CurrentLocation, notice} {
+		if status, found := ilsItemStatus[statusKey(candidate)]; found {
+			return status
+		}
+	}
+	if item.OnShelf {
+		return statusAvailable
+	}
+	if item.Unavailable {
+		return statusUnavailable
+	}
+	return statusUnknown
+}
+
+// statusKey reduces an ILS code or status to upper case letters so "IN-TRANSIT" and "In transit" match
+func statusKey(raw string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return -1
+	}, raw)
+}
+
+// noticeDueDate finds a due date in an ILS notice. The date is whatever follows "due" up to a ; or (,
+// less any trailing words that stop it from parsing as a date.
+func noticeDueDate(notice string) string {
+	match := noticeDueRegex.FindStringSubmatch(notice)
+	if match == nil {
+		return ""
+	}
+	words := strings.Fields(strings.TrimRight(strings.TrimSpace(match[1]), ".,"))
+	for n := len(words); n > 0; n-- {
+		candidate := strings.TrimRight(strings.Join(words[:n], " "), ".,")
+		if parseILSDate(candidate) != nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// noticeHoldCount finds the number of holds in an ILS notice, like "(2 holds)"
+func noticeHoldCount(notice string) int {
+	match := noticeHoldsRegex.FindStringSubmatch(notice)
+	if match == nil {
+		return 0
+	}
+	count, _ := strconv.Atoi(match[1])
+	return count
+}
+
+func parseILSDate(raw string) *time.Time {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil
+	}
+	for _, layout := range ilsDateLayouts {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
+
+// labelDate formats a date for a status label; the year is only included when it is not this year
+func labelDate(t time.Time) string {
+	if t.Year() == time.Now().Year() {
+		return t.Format("Jan 2")
+	}
+	return t.Format("Jan 2, 2006")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseILSDate(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"2026-11-03T23:59:00Z", "2026-11-03"},
+		{"2026-11-01T04:59:00.000+00:00", "2026-11-01"},
+		{"2026-11-03T23:59:00", "2026-11-03"},
+		{"2026-11-03 23:59:00", "2026-11-03"},
+		{"2026-11-03", "2026-11-03"},
+		{" 11/3/2026 ", "2026-11-03"},
+		{"11/3/2026 23:59", "2026-11-03"},
+		{"Nov 3, 2026", "2026-11-03"},
+		{"Nov 3 2026", "2026-11-03"},
+		{"November 3, 2026", "2026-11-03"},
+		{"", ""},
+		{"never", ""},
+		{"13/45/2026", ""},
+	}
+	for _, test := range tests {
+		got := ""
+		if parsed := parseILSDate(test.raw); parsed != nil {
+			got = parsed.Format("2006-01-02")
+		}
+		if got != test.want {
+			t.Errorf("parseILSDate(%q) = %q; want %q", test.raw, got, test.want)
+		}
+	}
+}
+
+func TestItemStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		item Item
+		want string
+	}{
+		{"on shelf", Item{OnShelf: true, CurrentLocationID: "STACKS"}, statusAvailable},
+		{"sirsi checked out", Item{CurrentLocationID: "CHECKEDOUT", Notice: "Checked out"}, statusCheckedOut},
+		{"sirsi in transit", Item{CurrentLocationID: "INTRANSIT"}, statusInTransit},
+		{"sirsi hold shelf", Item{CurrentLocationID: "HOLDS"}, statusOnHoldShelf},
+		{"sirsi on order", Item{CurrentLocationID: "ON-ORDER", Unavailable: true}, statusOnOrder},
+		{"sirsi missing", Item{CurrentLocationID: "MISSING", Unavailable: true}, statusMissing},
+		{"sirsi lost", Item{CurrentLocationID: "LOST-ASSUM"}, statusLost},
+		{"location name", Item{CurrentLocation: "In process"}, statusInProcessing},
+		{"folio notice", Item{Notice: "Awaiting pickup"}, statusOnHoldShelf},
+		{"notice with detail", Item{Notice: "Checked out, due 2026-11-03"}, statusCheckedOut},
+		{"code wins over flags", Item{OnShelf: true, CurrentLocationID: "NON-CIRC"}, statusNonCirculating},
+		{"unavailable", Item{Unavailable: true, Notice: "Ask at desk"}, statusUnavailable},
+		{"nothing known", Item{}, statusUnknown},
+		{"unverified", Item{OnShelf: true, StatusUnverified: true}, statusUnknown},
+	}
+	for _, test := range tests {
+		if got := itemStatus(&test.item); got != test.want {
+			t.Errorf("%s: itemStatus(%+v) = %s; want %s", test.name, test.item, got, test.want)
+		}
+	}
+}
+
+func TestNormalizeItemStatus(t *testing.T) {
+	year := time.Now().Year() + 1
+	next := func(layout string) string { return time.Date(year, 11, 3, 0, 0, 0, 0, time.UTC).Format(layout) }
+	tests := []struct {
+		name      string
+		item      Item
+		wantDue   string
+		wantHolds int
+		wantLabel string
+	}{
+		{"connector notice due date", Item{CurrentLocationID: "CHECKEDOUT", Notice: "Checked out, due " + next("1/2/2006")},
+			next("2006-01-02"), 0, "Checked out, due " + next("Jan 2, 2006")},
+		{"connector notice due date and holds", Item{CurrentLocationID: "CHECKEDOUT", Notice: "Due: " + next("Jan 2, 2006") + " (2 holds)"},
+			next("2006-01-02"), 2, "Checked out, due " + next("Jan 2, 2006") + " (2 holds)"},
+		{"connector notice date with time", Item{CurrentLocationID: "CHECKEDOUT", Notice: "Item due " + next("1/2/2006") + " 23:59; 1 hold"},
+			next("2006-01-02"), 1, "Checked out, due " + next("Jan 2, 2006") + " (1 hold)"},
+		{"folio fields", Item{Notice: "Checked out, due soon", DueDate: next(time.RFC3339), HoldCount: 3},
+			next("2006-01-02"), 3, "Checked out, due " + next("Jan 2, 2006") + " (3 holds)"},
+		{"no date in notice", Item{CurrentLocationID: "CHECKEDOUT", Notice: "Checked out, due back soon"},
+			"", 0, "Checked out"},
+		{"on shelf", Item{OnShelf: true}, "", 0, "Available"},
+		{"unverified", Item{StatusUnverified: true}, "", 0, "Status unverified"},
+	}
+	for _, test := range tests {
+		item := test.item
+		normalizeItemStatus(&item)
+		if item.DueDate != test.wantDue || item.HoldCount != test.wantHolds || item.StatusLabel != test.wantLabel {
+			t.Errorf("%s: due %q, holds %d, label %q; want %q, %d, %q", test.name, item.DueDate, item.HoldCount,
+				item.StatusLabel, test.wantDue, test.wantHolds, test.wantLabel)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// log package prefixes and the slog level they map to
+var logPrefixLevels = []struct {
+	Prefix string
+	Level  slog.Level
+}{
+	{Prefix: "ERROR:", Level: slog.LevelError},
+	{Prefix: "WARNING:", Level: slog.LevelWarn},
+	{Prefix: "WARN:", Level: slog.LevelWarn},
+	{Prefix: "INFO:", Level: slog.LevelInfo},
+}
+
+// requestIDHeader carries the request ID from callers and on to the ILS and Solr
+const requestIDHeader = "X-Request-ID"
+
+type logContextKey string
+
+const (
+	requestIDKey logContextKey = "request_id"
+	userIDKey    logContextKey = "user_id"
+)
+
+// accepted format of a request ID sent by a caller; anything else is replaced with a new ID
+var requestIDRegex = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// patterns for values that must never be logged
+var (
+	jwtRegex    = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	bearerRegex = regexp.MustCompile(`(?i)(bearer\s+)[^\s\["][^\s\]"]*`)
+	pinRegex    = regexp.MustCompile(`(?i)(pin=)[^&\s]+`)
+	emailRegex  = regexp.MustCompile(`[A-Za-z0-9._%+-]+@([A-Za-z0-9-]+\.)+[A-Za-z]{2,}`)
+)
+
+// initLogging makes slog JSON logging the default. Lines from the log package go through the same
+// handler, with a level taken from their ERROR:, WARNING: or INFO: prefix. Every message and string
+// attribute is redacted.
+func initLogging() {
+	opts := slog.HandlerOptions{ReplaceAttr: redactAttr}
+	slog.SetDefault(slog.New(&contextHandler{Handler: slog.NewJSONHandler(os.Stdout, &opts)}))
+	log.SetFlags(0)
+	log.SetOutput(logWriter{})
+}
+
+// contextHandler adds the request and user IDs from the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	if ctx != nil {
+		if requestID, ok := ctx.Value(requestIDKey).(string); ok {
+			out.AddAttrs(slog.String("request_id", requestID))
+		}
+		if userID, ok := ctx.Value(userIDKey).(string); ok {
+			out.AddAttrs(slog.String("user_id", userID))
+		}
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(a)
+		return true
+	})
+	return h.Handler.Handle(ctx, out)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// logWriter sends lines from the log package to slog
+type logWriter struct{}
+
+func (logWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSpace(string(p))
+	level := slog.LevelInfo
+	for _, pl := range logPrefixLevels {
+		if strings.HasPrefix(msg, pl.Prefix) {
+			level = pl.Level
+			msg = strings.TrimSpace(strings.TrimPrefix(msg, pl.Prefix))
+			break
+		}
+	}
+	slog.Default().Log(context.Background(), level, msg)
+	return len(p), nil
+}
+
+// redactAttr redacts string attribute values, including the message
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindString {
+		a.Value = slog.StringValue(redact(a.Value.String()))
+	}
+	return a
+}
+
+// redact removes JWTs, bearer tokens and PINs from a log value and hides the user part of email addresses
+func redact(value string) string {
+	value = jwtRegex.ReplaceAllString(value, "[JWT]")
+	value = bearerRegex.ReplaceAllString(value, "${1}[TOKEN]")
+	value = pinRegex.ReplaceAllString(value, "${1}SECRET")
+	return emailRegex.ReplaceAllStringFunc(value, func(email string) string {
+		return "***" + email[strings.LastIndex(email, "@"):]
+	})
+}
+
+// redactURL replaces the password in a URL, e.g. the redis URL, with xxxxx. A URL that can not be
+// parsed is hidden entirely since it may still hold the password.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "[INVALID URL]"
+	}
+	return u.Redacted()
+}
+
+// requestLogMiddleware assigns each request an ID, using the X-Request-ID of the caller if it has
+// one, and logs the request when it is done
+func requestLogMiddleware(c *gin.Context) {
+	start := time.Now()
+	requestID := c.GetHeader(requestIDHeader)
+	if requestIDRegex.MatchString(requestID) == false {
+		requestID = newRequestID()
+	}
+	c.Header(requestIDHeader, requestID)
+	c.Set("request_id", requestID)
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestIDKey, requestID))
+
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	status := c.Writer.Status()
+	level := slog.LevelInfo
+	if status >= 500 {
+		level = slog.LevelError
+	}
+	slog.LogAttrs(c.Request.Context(), level, "request", slog.String("method", c.Request.Method),
+		slog.String("route", route), slog.String("path", c.Request.URL.Path), slog.Int("status", status),
+		slog.Int64("latency_ms", int64(time.Since(start)/time.Millisecond)), slog.String("client_ip", c.ClientIP()))
+}
+
+// withUserID adds the signed in user to the context so it is included in logs
+func withUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userIDKey, userID)
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestIDTransport adds the request ID of the context to outgoing requests so a request can be
+// followed through the ILS Connector and Solr logs
+type requestIDTransport struct {
+	Base http.RoundTripper
+}
+
+func (t *requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	requestID, ok := req.Context().Value(requestIDKey).(string)
+	if ok == false || req.Header.Get(requestIDHeader) != "" {
+		return t.Base.RoundTrip(req)
+	}
+	out := req.Clone(req.Context())
+	out.Header.Set(requestIDHeader, requestID)
+	return t.Base.RoundTrip(out)
+}
@@ -7,12 +7,14 @@ import (
 	"github.com/gin-contrib/cors"
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Version of the service
 const version = "1.2.0"
 
 func main() {
+	initLogging()
 	log.Printf("===> V4 availability service staring up <===")
 
 	// Get config params and use them to init service context. Any issues are fatal
@@ -25,24 +27,45 @@ func main() {
 	log.Printf("Setup routes...")
 	gin.SetMode(gin.ReleaseMode)
 	gin.DisableConsoleColor()
-	router := gin.Default()
+	router := gin.New()
+	// client IPs (for public rate limits and logs) come from X-Forwarded-For only when set by a trusted proxy
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("invalid trustedproxies param: %s", err.Error())
+	}
+	router.Use(gin.Recovery())
+	router.Use(requestLogMiddleware)
 	router.Use(gzip.Gzip(gzip.DefaultCompression))
 	corsCfg := cors.DefaultConfig()
 	corsCfg.AllowAllOrigins = true
 	corsCfg.AllowCredentials = true
-	corsCfg.AddAllowHeaders("Authorization")
+	corsCfg.AddAllowHeaders("Authorization", requestIDHeader)
+	corsCfg.AddExposeHeaders(requestIDHeader)
 	router.Use(cors.New(corsCfg))
+	router.Use(metricsMiddleware)
 
 	router.GET("/", svc.getVersion)
 	router.GET("/favicon.ico", svc.ignoreFavicon)
 	router.GET("/version", svc.getVersion)
 	router.GET("/healthcheck", svc.healthCheck)
+	router.GET("/healthz/live", svc.liveCheck)
+	router.GET("/healthz/ready", svc.readyCheck)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	router.GET("/item/:id", svc.authMiddleware, svc.getAvailability)
+	router.POST("/items", svc.authMiddleware, svc.getBatchAvailability)
+	router.GET("/public/item/:id", svc.publicMiddleware, svc.getPublicAvailability)
+	router.GET("/item/:id/explain", svc.authMiddleware, svc.staffMiddleware, svc.explainAvailability)
 
 	// course reserves
 	router.POST("/reserves", svc.authMiddleware, svc.createCourseReserves)
 	router.POST("/reserves/validate", svc.authMiddleware, svc.validateCourseReserves)
 	router.GET("/reserves/search", svc.authMiddleware, svc.searchReserves)
+	router.GET("/reserves/requests", svc.authMiddleware, svc.getUserReserveRequests)
+	router.GET("/reserves/requests/:id", svc.authMiddleware, svc.getReserveRequest)
+
+	// course reserves request workflow (staff only)
+	router.GET("/reserves/staff/requests", svc.authMiddleware, svc.staffMiddleware, svc.getStaffReserveRequests)
+	router.PUT("/reserves/requests/:id/status", svc.authMiddleware, svc.staffMiddleware, svc.updateReserveRequestStatus)
+	router.PUT("/reserves/requests/:id/items/:item/status", svc.authMiddleware, svc.staffMiddleware, svc.updateReserveItemStatus)
 
 	portStr := fmt.Sprintf(":%d", cfg.Port)
 	log.Printf("Start service v%s on port %s", version, portStr)
@@ -2,21 +2,24 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/csv"
 	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
+	"sort"
+	"strings"
 )
 
 func (svc *ServiceContext) initMapLookups() {
-	log.Printf("Initializing map lookups data...")
+	slog.Info("initializing map lookups data")
 	svc.MapLookups = make([]MapLookup, 0)
 	svc.Maps = make([]Map, 0)
 
 	// Maps data: ID,URL,NAME
 	mapsData, err := ioutil.ReadFile("./data/maps.csv")
 	if err != nil {
-		log.Printf("ERROR: Unable to read maps data: %s", err.Error())
+		slog.Error("unable to read maps data", "error", err.Error())
 		return
 	}
 	csvReader := csv.NewReader(bytes.NewReader(mapsData))
@@ -26,13 +29,13 @@ func (svc *ServiceContext) initMapLookups() {
 			break
 		}
 		if err != nil {
-			log.Printf("ERROR: Unable to parse maps data: %s", err.Error())
+			slog.Error("unable to parse maps data", "error", err.Error())
 		}
 		if line[0] != "ID" {
 			mapDat := Map{
-				ID:     line[0],
-				MapURL: line[1],
-				Name:   line[2],
+				ID:     strings.TrimSpace(line[0]),
+				MapURL: strings.TrimSpace(line[1]),
+				Name:   strings.TrimSpace(line[2]),
 			}
 			svc.Maps = append(svc.Maps, mapDat)
 		}
@@ -41,7 +44,7 @@ func (svc *ServiceContext) initMapLookups() {
 	// Lookups: RANGE,LOCATION,MAP
 	lookupsData, err := ioutil.ReadFile("./data/map_lookups.csv")
 	if err != nil {
-		log.Printf("ERROR: Unable to read map lookups data: %s", err.Error())
+		slog.Error("unable to read map lookups data", "error", err.Error())
 		return
 	}
 	csvReader = csv.NewReader(bytes.NewReader(lookupsData))
@@ -51,45 +54,35 @@ func (svc *ServiceContext) initMapLookups() {
 			break
 		}
 		if err != nil {
-			log.Printf("ERROR: Unable to parse map lookups data: %s", err.Error())
+			slog.Error("unable to parse map lookups data", "error", err.Error())
 		}
 		if line[0] != "RANGE" {
 			lookup := MapLookup{
-				CallNumberRange: line[0],
-				Location:        line[1],
-				MapID:           line[2],
+				CallNumberRange: strings.TrimSpace(line[0]),
+				Location:        strings.ToUpper(strings.TrimSpace(line[1])),
+				MapID:           strings.TrimSpace(line[2]),
 			}
 			svc.MapLookups = append(svc.MapLookups, lookup)
 		}
 	}
 
-	log.Printf("Map lookups initialization COMPLETE")
+	svc.MapIndex = newMapIndex(svc.MapLookups)
+	slog.Info("map lookups loaded", "maps", len(svc.Maps), "lookups", len(svc.MapLookups))
 }
 
-func (svc *ServiceContext) addMapInfo(items []*Item) {
-	log.Printf("Add map info to items")
+func (svc *ServiceContext) addMapInfo(ctx context.Context, items []*Item) {
+	slog.InfoContext(ctx, "add map info to items", "items", len(items))
 	for _, item := range items {
 		item.Map.Name = "N/A"
-		var lookup *MapLookup
-		for _, lu := range svc.MapLookups {
-			if lu.Location == item.HomeLocationID {
-				lookup = &lu
-				break
-			}
-		}
-		if lookup == nil {
+		mapID := svc.MapIndex.lookup(item.HomeLocationID, item.CallNumber)
+		if mapID == "" {
 			continue
 		}
-
-		// if call is an asterisk, location is all thats needed for map
-		if lookup.CallNumberRange == "*" {
-			match := svc.findMap(lookup.MapID)
-			if match != nil {
-				item.Map.MapURL = match.MapURL
-				item.Map.Name = match.Name
-			}
-		} else {
-
+		match := svc.findMap(mapID)
+		if match != nil {
+			item.Map.ID = match.ID
+			item.Map.MapURL = match.MapURL
+			item.Map.Name = match.Name
 		}
 	}
 }
@@ -104,3 +97,145 @@ func (svc *ServiceContext) findMap(id string) *Map {
 	}
 	return out
 }
+
+// mapRange is a single call number range from the map lookups. The range covers
+// all call numbers with a sort key in [Low, High)
+type mapRange struct {
+	Low      string
+	High     string
+	MapID    string
+	Order    int
+	Width    uint64
+	Location string
+}
+
+// keyWidth is the distance between two sort keys, using the first 8 bytes of each as a big endian number.
+// It is used to compare how specific ranges are; keys that only differ past 8 bytes have a width of 0.
+func keyWidth(low, high string) uint64 {
+	return keyValue(high) - keyValue(low)
+}
+
+func keyValue(key string) uint64 {
+	var val uint64
+	for i := 0; i < 8; i++ {
+		val <<= 8
+		if i < len(key) {
+			val |= uint64(key[i])
+		}
+	}
+	return val
+}
+
+// narrower is true if the range is more specific than another range that also matches a call number:
+// the narrowest range wins, then a range for the item location over a "*" range, then the range that
+// starts closest to the call number and finally the lookup listed first.
+func (r *mapRange) narrower(other *mapRange) bool {
+	if r.Width != other.Width {
+		return r.Width < other.Width
+	}
+	if (r.Location == "*") != (other.Location == "*") {
+		return r.Location != "*"
+	}
+	if r.Low != other.Low {
+		return r.Low > other.Low
+	}
+	return r.Order < other.Order
+}
+
+// mapIndex is a location aware index of call number ranges to map IDs
+type mapIndex struct {
+	// locations where the call number is not relevant; any item there gets the map
+	Locations map[string]string
+	// call number ranges for a location, sorted by lower bound. Location "*" applies everywhere
+	Ranges map[string][]*mapRange
+}
+
+// newMapIndex builds the call number range index from the raw map lookups. Lookups are:
+//   - "*": location alone determines the map
+//   - "A-AFQ": a closed range; the upper bound includes everything it prefixes (AFQ123 .B2)
+//   - "AFR": a single class or prefix; same as "AFR-AFR"
+//   - "Bachelos-": open ended; runs to the end of the leading letter of the lower bound
+func newMapIndex(lookups []MapLookup) *mapIndex {
+	idx := mapIndex{Locations: make(map[string]string), Ranges: make(map[string][]*mapRange)}
+	for order, lu := range lookups {
+		if lu.CallNumberRange == "*" {
+			if _, exists := idx.Locations[lu.Location]; !exists {
+				idx.Locations[lu.Location] = lu.MapID
+			}
+			continue
+		}
+
+		lowStr, highStr, isRange := strings.Cut(lu.CallNumberRange, "-")
+		low := callNumberSortKey(lowStr)
+		if low == "" {
+			slog.Warn("skipping invalid map lookup range", "range", lu.CallNumberRange)
+			continue
+		}
+		high := ""
+		if isRange == false {
+			high = keySuccessor(low)
+		} else if strings.TrimSpace(highStr) == "" {
+			high = keySuccessor(low[:1])
+		} else {
+			high = keySuccessor(callNumberSortKey(highStr))
+		}
+		if high <= low {
+			slog.Warn("skipping map lookup range with upper bound below lower bound", "range", lu.CallNumberRange)
+			continue
+		}
+
+		rng := mapRange{Low: low, High: high, MapID: lu.MapID, Order: order, Width: keyWidth(low, high), Location: lu.Location}
+		idx.Ranges[lu.Location] = append(idx.Ranges[lu.Location], &rng)
+	}
+
+	for _, ranges := range idx.Ranges {
+		sort.SliceStable(ranges, func(i, j int) bool {
+			return ranges[i].Low < ranges[j].Low
+		})
+	}
+	return &idx
+}
+
+// lookup finds the map ID for an item location and call number. An empty string is returned if there is no match.
+// Locations mapped with "*" ignore the call number. Otherwise ranges for the location and "*" ranges are both
+// checked and the most specific match wins; see narrower.
+func (idx *mapIndex) lookup(location string, callNumber string) string {
+	if idx == nil {
+		return ""
+	}
+	location = strings.ToUpper(strings.TrimSpace(location))
+	if mapID, found := idx.Locations[location]; found {
+		return mapID
+	}
+
+	key := callNumberSortKey(callNumber)
+	if key == "" {
+		return ""
+	}
+	var best *mapRange
+	for _, match := range append(idx.findRanges(idx.Ranges[location], key), idx.findRanges(idx.Ranges["*"], key)...) {
+		if best == nil || match.narrower(best) {
+			best = match
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return best.MapID
+}
+
+// findRanges returns all ranges that contain the key
+func (idx *mapIndex) findRanges(ranges []*mapRange, key string) []*mapRange {
+	// all candidates have a lower bound <= key; they sort before the first range that starts after the key
+	end := sort.Search(len(ranges), func(i int) bool {
+		return ranges[i].Low > key
+	})
+
+	matches := make([]*mapRange, 0)
+	for _, rng := range ranges[:end] {
+		if key < rng.High {
+			matches = append(matches, rng)
+		}
+	}
+	return matches
+}
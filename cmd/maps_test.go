@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strings"
+	"testing"
+)
+
+// loadTestMapIndex builds the map index from the lookups shipped in data/
+func loadTestMapIndex(t *testing.T) *mapIndex {
+	t.Helper()
+	f, err := os.Open("../data/map_lookups.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookups := make([]MapLookup, 0)
+	for _, line := range lines[1:] {
+		lookups = append(lookups, MapLookup{CallNumberRange: strings.TrimSpace(line[0]),
+			Location: strings.ToUpper(strings.TrimSpace(line[1])), MapID: strings.TrimSpace(line[2])})
+	}
+	return newMapIndex(lookups)
+}
+
+func TestMapLookup(t *testing.T) {
+	idx := loadTestMapIndex(t)
+	tests := []struct {
+		location   string
+		callNumber string
+		want       string
+	}{
+		// nested ranges; the narrower range wins
+		{"STACKS", "AE5 .E363 1990", "13"},
+		{"STACKS", "AFR 12", "29"},
+		{"STACKS", "AZ 101", "1"},
+		{"STACKS", "L 7 .N3", "3"},
+		{"STACKS", "M 1500 .B2", "29"},
+		// partial overlap; HV600-INS is narrower than H
+		{"STACKS", "HV600 .B2", "28"},
+		{"STACKS", "HV599.5 .A1 B23 1998", "5"},
+		{"STACKS", "HA 29 .B2", "5"},
+		// "*" ranges compete with location ranges and win when they are narrower
+		{"STACKS", "R13 .A1", "16"},
+		{"STACKS", "XX(1234)", "16"},
+		{"STACKS", "R 12", "1"},
+		{"ALDERMAN", "XX(99)", "16"},
+		{"ALDERMAN", "HV600 .B2", ""},
+		// non-LC call numbers
+		{"STACKS", "Bachelor's thesis 1992", "1"},
+		{"STACKS", "Bachelos 1", "8"},
+		{"STACKS", "Ba 1", "8"},
+		// the location alone decides the map
+		{"law-ivy", "KF 101", "16"},
+		{"REFERENCE", "", "10"},
+		{"STACKS", "", ""},
+		{"NOWHERE", "Q 1", ""},
+	}
+	for _, test := range tests {
+		if got := idx.lookup(test.location, test.callNumber); got != test.want {
+			t.Errorf("lookup(%q, %q) = %q; want %q", test.location, test.callNumber, got, test.want)
+		}
+	}
+}
+
+func TestMapLookupOrderIndependent(t *testing.T) {
+	lookups := []MapLookup{
+		{CallNumberRange: "H", Location: "STACKS", MapID: "wide"},
+		{CallNumberRange: "HV600-INS", Location: "STACKS", MapID: "narrow"},
+		{CallNumberRange: "HV600-HV700", Location: "*", MapID: "narrowest"},
+	}
+	reversed := []MapLookup{lookups[2], lookups[1], lookups[0]}
+	for _, lu := range [][]MapLookup{lookups, reversed} {
+		idx := newMapIndex(lu)
+		if got := idx.lookup("STACKS", "HV650 .B2"); got != "narrowest" {
+			t.Errorf("HV650 .B2 = %q; want narrowest", got)
+		}
+		if got := idx.lookup("STACKS", "HV800"); got != "narrow" {
+			t.Errorf("HV800 = %q; want narrow", got)
+		}
+		if got := idx.lookup("STACKS", "HB 1"); got != "wide" {
+			t.Errorf("HB 1 = %q; want wide", got)
+		}
+	}
+
+	// same range for the location and "*"; the location wins
+	idx := newMapIndex([]MapLookup{{CallNumberRange: "Q", Location: "*", MapID: "any"},
+		{CallNumberRange: "Q", Location: "STACKS", MapID: "stacks"}})
+	if got := idx.lookup("STACKS", "Q 1"); got != "stacks" {
+		t.Errorf("Q 1 = %q; want stacks", got)
+	}
+	if got := idx.lookup("OTHER", "Q 1"); got != "any" {
+		t.Errorf("Q 1 at OTHER = %q; want any", got)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "availability_http_requests_total",
+		Help: "Number of HTTP requests by route and status",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "availability_http_request_duration_seconds",
+		Help:    "HTTP request latency by route",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	upstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "availability_upstream_request_duration_seconds",
+		Help:    "Latency of requests to upstream services",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"upstream", "method"})
+
+	upstreamErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "availability_upstream_errors_total",
+		Help: "Number of failed requests to upstream services by status",
+	}, []string{"upstream", "method", "status"})
+
+	upstreamRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "availability_upstream_retries_total",
+		Help: "Number of retried requests to upstream services",
+	}, []string{"upstream"})
+
+	breakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "availability_circuit_breaker_state",
+		Help: "Upstream circuit breaker state; 0 closed, 1 half open, 2 open",
+	}, []string{"upstream"})
+
+	breakerRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "availability_circuit_breaker_rejections_total",
+		Help: "Number of upstream requests rejected because the circuit breaker was open",
+	}, []string{"upstream"})
+
+	emailsSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "availability_emails_total",
+		Help: "Number of emails by outcome; sent, failed, dead_lettered or logged (dev mode)",
+	}, []string{"outcome"})
+
+	outboxMessages = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "availability_email_outbox_messages",
+		Help: "Number of emails in the outbox waiting to be sent (pending) or given up on (dead)",
+	}, []string{"status"})
+
+	degradedResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "availability_degraded_responses_total",
+		Help: "Number of availability responses built from solr because the ILS failed, by ILS status",
+	}, []string{"status"})
+
+	requestOptionRules = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "availability_request_option_rules_total",
+		Help: "Number of times each request option rule changed a response",
+	}, []string{"rule"})
+)
+
+// metricsMiddleware records count and latency of every request by gin route
+func metricsMiddleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	httpRequests.WithLabelValues(c.Request.Method, route, fmt.Sprintf("%d", c.Writer.Status())).Inc()
+	httpDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+}
+
+// observeUpstream records the latency and outcome of a request to an upstream service
+func observeUpstream(upstream string, method string, elapsed time.Duration, err *RequestError) {
+	upstreamDuration.WithLabelValues(upstream, method).Observe(elapsed.Seconds())
+	if err != nil {
+		upstreamErrors.WithLabelValues(upstream, method, fmt.Sprintf("%d", err.StatusCode)).Inc()
+	}
+}
+
+// ruleFired counts a request option rule that changed an availability response
+func ruleFired(rule string) {
+	requestOptionRules.WithLabelValues(rule).Inc()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// scrapeMetrics gets /metrics and returns the value of each sample by its name and labels, as written
+// in the text format; e.g. availability_emails_total{outcome="sent"}
+func scrapeMetrics(t *testing.T, router *gin.Engine) map[string]float64 {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("/metrics status = %d", w.Code)
+	}
+	samples := make(map[string]float64)
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		idx := strings.LastIndex(line, " ")
+		value, err := strconv.ParseFloat(line[idx+1:], 64)
+		if err != nil {
+			t.Fatalf("unable to parse sample %q", line)
+		}
+		samples[line[:idx]] = value
+	}
+	return samples
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	svc := newTestUpstreams(t, 0)
+	svc.SMTP.DevMode = true
+	noUser := false
+	svc.Rules = &requestRules{Rules: []*requestRule{{Name: "test_metrics_probe", SolrOptional: true,
+		When: &ruleCondition{Claim: "userId", Present: &noUser}, Actions: []*ruleAction{{RemoveOption: "none"}}}}}
+
+	router := gin.New()
+	router.Use(metricsMiddleware)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	router.GET("/item/:id", svc.getAvailability)
+
+	samples := []string{
+		`availability_http_requests_total{method="GET",route="/item/:id",status="200"}`,
+		`availability_http_request_duration_seconds_count{method="GET",route="/item/:id"}`,
+		`availability_upstream_request_duration_seconds_count{method="GET",upstream="ils"}`,
+		`availability_upstream_request_duration_seconds_count{method="GET",upstream="solr"}`,
+		`availability_request_option_rules_total{rule="test_metrics_probe"}`,
+		`availability_emails_total{outcome="logged"}`,
+	}
+	before := scrapeMetrics(t, router)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/item/u1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("/item/u1 status = %d", w.Code)
+	}
+	if err := svc.sendEmail(&emailRequest{Subject: "metrics test", To: []string{"mst3k@virginia.edu"},
+		From: "virgo4@virginia.edu", Body: "body"}); err != nil {
+		t.Fatal(err)
+	}
+
+	after := scrapeMetrics(t, router)
+	for _, sample := range samples {
+		value, found := after[sample]
+		if found == false {
+			t.Errorf("%s is not in /metrics", sample)
+			continue
+		}
+		if value != before[sample]+1 {
+			t.Errorf("%s = %v; want %v", sample, value, before[sample]+1)
+		}
+	}
+	// the /metrics scrape itself is counted by route
+	if scrapes := after[`availability_http_requests_total{method="GET",route="/metrics",status="200"}`]; scrapes < 1 {
+		t.Errorf("/metrics requests = %v; want the first scrape counted", scrapes)
+	}
+}
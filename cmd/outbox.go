@@ -0,0 +1,226 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// outbox message statuses
+const (
+	outboxPending = "pending"
+	outboxSent    = "sent"
+	outboxDead    = "dead"
+)
+
+// max number of messages claimed by one outbox delivery pass
+const outboxBatchSize = 20
+
+// how long claimed messages are held by the instance that claimed them. If the instance stops before it
+// saves the outcome, the messages are claimed and sent again once the lease is up.
+const outboxLease = 10 * time.Minute
+
+// emailOutbox delivers the emails queued in the email_outbox table. Messages are queued in the same
+// transaction that saves the request they belong to, so an accepted request always has its emails.
+// Messages are claimed in a short transaction and sent outside of it, so a slow SMTP server never holds
+// row locks. Delivery is at least once; a message that fails is retried with exponential backoff until it has
+// been tried Config.MaxAttempts times, then it is dead lettered and left in the table for staff.
+type emailOutbox struct {
+	svc    *ServiceContext
+	Config OutboxConfig
+	wake   chan struct{}
+}
+
+// outboxMessage is a queued email and its delivery attempts so far
+type outboxMessage struct {
+	ID       int64
+	Attempts int
+	Email    emailRequest
+}
+
+func newEmailOutbox(svc *ServiceContext, cfg OutboxConfig) *emailOutbox {
+	return &emailOutbox{svc: svc, Config: cfg, wake: make(chan struct{}, 1)}
+}
+
+// start delivers queued messages in the background; every Config.Interval and whenever notify is called
+func (ob *emailOutbox) start() {
+	go func() {
+		ticker := time.NewTicker(ob.Config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ob.wake:
+			}
+			ob.deliverDue()
+		}
+	}()
+}
+
+// notify wakes the delivery worker so newly queued messages go out right away
+func (ob *emailOutbox) notify() {
+	select {
+	case ob.wake <- struct{}{}:
+	default:
+	}
+}
+
+// queueEmails adds emails for a reserve request to the outbox as part of the transaction saving the request
+func queueEmails(tx *sql.Tx, requestID int64, emails []*emailRequest) error {
+	for _, email := range emails {
+		_, err := tx.Exec(`INSERT INTO email_outbox (request_id, subject, from_addr, reply_to, to_addrs, cc, body, html_body)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			requestID, email.Subject, email.From, email.ReplyTo, pq.Array(email.To), pq.Array(email.CC), email.Body, email.HTMLBody)
+		if err != nil {
+			return fmt.Errorf("unable to queue email %s: %s", email.Subject, err.Error())
+		}
+	}
+	return nil
+}
+
+// deliverDue sends all messages that are due, a batch at a time
+func (ob *emailOutbox) deliverDue() {
+	for {
+		count, err := ob.deliverBatch()
+		if err != nil {
+			slog.Error("email outbox delivery failed", "error", err.Error())
+			break
+		}
+		if count < outboxBatchSize {
+			break
+		}
+	}
+	ob.updateMetrics()
+}
+
+// deliverBatch claims a batch of due messages, then tries to send each one. The outcome of each message
+// is saved on its own as soon as it is known. The number of messages claimed is returned.
+func (ob *emailOutbox) deliverBatch() (int, error) {
+	messages, err := ob.claimDue()
+	if err != nil {
+		return 0, err
+	}
+	for _, msg := range messages {
+		outcome := ob.send(msg)
+		if err := ob.record(msg, outcome); err != nil {
+			// the claim lease runs out and the message is sent again
+			slog.Error("unable to update outbox email", "outbox_id", msg.ID, "error", err.Error())
+		}
+	}
+	return len(messages), nil
+}
+
+// claimDue claims a batch of due messages by moving their next attempt past the claim lease. It is a single
+// statement, so rows are only locked while they are claimed, not while they are sent; other service
+// instances skip locked rows and, once the claim is done, the messages are no longer due. The lease, like
+// the due check, uses the database clock so instances with skewed clocks agree on when a claim runs out.
+func (ob *emailOutbox) claimDue() ([]*outboxMessage, error) {
+	rows, err := ob.svc.DB.Query(`UPDATE email_outbox SET next_attempt_at = NOW() + make_interval(secs => $1) WHERE id IN
+		(SELECT id FROM email_outbox WHERE status = $2 AND next_attempt_at <= NOW() ORDER BY id LIMIT $3 FOR UPDATE SKIP LOCKED)
+		RETURNING id, attempts, subject, from_addr, reply_to, to_addrs, cc, body, html_body`,
+		outboxLease.Seconds(), outboxPending, outboxBatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("unable to claim queued emails: %s", err.Error())
+	}
+	defer rows.Close()
+	messages := make([]*outboxMessage, 0)
+	for rows.Next() {
+		var msg outboxMessage
+		var to, cc pq.StringArray
+		err = rows.Scan(&msg.ID, &msg.Attempts, &msg.Email.Subject, &msg.Email.From, &msg.Email.ReplyTo,
+			&to, &cc, &msg.Email.Body, &msg.Email.HTMLBody)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read queued email: %s", err.Error())
+		}
+		msg.Email.To = to
+		msg.Email.CC = cc
+		messages = append(messages, &msg)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read queued emails: %s", err.Error())
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].ID < messages[j].ID
+	})
+	return messages, nil
+}
+
+// outboxOutcome is the result of one delivery attempt; the new status of the message, the send error
+// and, for a message that is still pending, the wait before it is tried again
+type outboxOutcome struct {
+	Status string
+	Err    error
+	Retry  time.Duration
+}
+
+// send makes one delivery attempt for a claimed message. Nothing is saved here.
+func (ob *emailOutbox) send(msg *outboxMessage) outboxOutcome {
+	msg.Attempts++
+	sendErr := ob.svc.sendEmail(&msg.Email)
+	if sendErr == nil {
+		slog.Info("outbox email sent", "outbox_id", msg.ID, "subject", msg.Email.Subject, "attempts", msg.Attempts)
+		return outboxOutcome{Status: outboxSent}
+	}
+	if msg.Attempts >= ob.Config.MaxAttempts {
+		slog.Error("outbox email dead lettered", "outbox_id", msg.ID, "subject", msg.Email.Subject,
+			"attempts", msg.Attempts, "error", sendErr.Error())
+		emailsSent.WithLabelValues("dead_lettered").Inc()
+		return outboxOutcome{Status: outboxDead, Err: sendErr}
+	}
+	delay := ob.retryDelay(msg.Attempts)
+	slog.Warn("outbox email attempt failed", "outbox_id", msg.ID, "subject", msg.Email.Subject,
+		"attempts", msg.Attempts, "retry_in", delay.String(), "error", sendErr.Error())
+	return outboxOutcome{Status: outboxPending, Err: sendErr, Retry: delay}
+}
+
+// record saves the outcome of a delivery attempt for a message
+func (ob *emailOutbox) record(msg *outboxMessage, outcome outboxOutcome) error {
+	var err error
+	switch outcome.Status {
+	case outboxSent:
+		_, err = ob.svc.DB.Exec(`UPDATE email_outbox SET status = $1, attempts = $2, last_error = '', sent_at = NOW()
+			WHERE id = $3`, outboxSent, msg.Attempts, msg.ID)
+	case outboxDead:
+		_, err = ob.svc.DB.Exec(`UPDATE email_outbox SET status = $1, attempts = $2, last_error = $3 WHERE id = $4`,
+			outboxDead, msg.Attempts, outcome.Err.Error(), msg.ID)
+	default:
+		_, err = ob.svc.DB.Exec(`UPDATE email_outbox SET attempts = $1, last_error = $2,
+			next_attempt_at = NOW() + make_interval(secs => $3) WHERE id = $4`,
+			msg.Attempts, outcome.Err.Error(), outcome.Retry.Seconds(), msg.ID)
+	}
+	return err
+}
+
+// retryDelay doubles the wait after each failed attempt, up to Config.RetryMax
+func (ob *emailOutbox) retryDelay(attempts int) time.Duration {
+	delay := ob.Config.RetryBase << uint(attempts-1)
+	if delay <= 0 || delay > ob.Config.RetryMax {
+		return ob.Config.RetryMax
+	}
+	return delay
+}
+
+// updateMetrics records the number of outbox messages in each status
+func (ob *emailOutbox) updateMetrics() {
+	rows, err := ob.svc.DB.Query(`SELECT status, COUNT(*) FROM email_outbox WHERE status != $1 GROUP BY status`, outboxSent)
+	if err != nil {
+		slog.Warn("unable to count outbox emails", "error", err.Error())
+		return
+	}
+	defer rows.Close()
+	counts := map[string]float64{outboxPending: 0, outboxDead: 0}
+	for rows.Next() {
+		var status string
+		var count float64
+		if err := rows.Scan(&status, &count); err == nil {
+			counts[status] = count
+		}
+	}
+	for status, count := range counts {
+		outboxMessages.WithLabelValues(status).Set(count)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpSink is a local SMTP server that keeps the messages it accepts. Recipients in reject get a 550.
+type smtpSink struct {
+	listener net.Listener
+	reject   map[string]bool
+	lock     sync.Mutex
+	messages []string
+}
+
+func newSMTPSink(t *testing.T, reject ...string) *smtpSink {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	sink := &smtpSink{listener: listener, reject: make(map[string]bool)}
+	for _, addr := range reject {
+		sink.reject[addr] = true
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go sink.session(conn)
+		}
+	}()
+	return sink
+}
+
+func (sink *smtpSink) session(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 sink ready")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "RCPT":
+			addr := strings.Trim(strings.TrimPrefix(strings.ToUpper(arg), "TO:"), "<> ")
+			if sink.reject[strings.ToLower(addr)] {
+				tp.PrintfLine("550 no such user")
+				continue
+			}
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			lines, err := tp.ReadDotLines()
+			if err != nil {
+				return
+			}
+			sink.lock.Lock()
+			sink.messages = append(sink.messages, strings.Join(lines, "\n"))
+			sink.lock.Unlock()
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("250 ok")
+		}
+	}
+}
+
+func (sink *smtpSink) received() []string {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	return append([]string{}, sink.messages...)
+}
+
+// newTestOutbox has no database, so these tests also check that sending never touches it
+func newTestOutbox(sink *smtpSink) *emailOutbox {
+	addr := sink.listener.Addr().(*net.TCPAddr)
+	svc := &ServiceContext{SMTP: SMTPConfig{Host: addr.IP.String(), Port: addr.Port}}
+	return newEmailOutbox(svc, OutboxConfig{MaxAttempts: 3, RetryBase: 30 * time.Second, RetryMax: time.Hour})
+}
+
+func testOutboxMessage(attempts int) *outboxMessage {
+	return &outboxMessage{ID: 7, Attempts: attempts, Email: emailRequest{
+		Subject: "Course reserve request 12 received - ENGL 101 Fall 2026", To: []string{"requester@virginia.edu"},
+		CC: []string{"desk@virginia.edu"}, From: "virgo4@virginia.edu", ReplyTo: "reserves@virginia.edu",
+		Body: "plain text body", HTMLBody: "<p>html body</p>"}}
+}
+
+func TestOutboxSend(t *testing.T) {
+	sink := newSMTPSink(t)
+	ob := newTestOutbox(sink)
+	msg := testOutboxMessage(0)
+	outcome := ob.send(msg)
+	if outcome.Status != outboxSent || outcome.Err != nil {
+		t.Fatalf("outcome = %+v; want sent", outcome)
+	}
+	if msg.Attempts != 1 {
+		t.Errorf("attempts = %d; want 1", msg.Attempts)
+	}
+	received := sink.received()
+	if len(received) != 1 {
+		t.Fatalf("sink received %d messages; want 1", len(received))
+	}
+	for _, want := range []string{"To: requester@virginia.edu", "Cc: desk@virginia.edu", "From: virgo4@virginia.edu",
+		"Reply-To: reserves@virginia.edu", "multipart/alternative", "plain text body", "<p>html body</p>"} {
+		if strings.Contains(received[0], want) == false {
+			t.Errorf("sent message is missing %q:\n%s", want, received[0])
+		}
+	}
+}
+
+func TestOutboxSendRetry(t *testing.T) {
+	sink := newSMTPSink(t, "requester@virginia.edu")
+	ob := newTestOutbox(sink)
+	msg := testOutboxMessage(1)
+	outcome := ob.send(msg)
+	if outcome.Status != outboxPending || outcome.Err == nil {
+		t.Fatalf("outcome = %+v; want pending with the send error", outcome)
+	}
+	if outcome.Retry != time.Minute {
+		t.Errorf("retry = %s; want 1m0s after the second attempt", outcome.Retry)
+	}
+	if msg.Attempts != 2 {
+		t.Errorf("attempts = %d; want 2", msg.Attempts)
+	}
+	if received := sink.received(); len(received) != 0 {
+		t.Errorf("sink received %d messages; want none", len(received))
+	}
+}
+
+func TestOutboxSendDeadLetter(t *testing.T) {
+	sink := newSMTPSink(t, "requester@virginia.edu")
+	ob := newTestOutbox(sink)
+	msg := testOutboxMessage(2)
+	outcome := ob.send(msg)
+	if outcome.Status != outboxDead || outcome.Err == nil {
+		t.Fatalf("outcome = %+v; want dead with the send error", outcome)
+	}
+	if msg.Attempts != 3 {
+		t.Errorf("attempts = %d; want 3", msg.Attempts)
+	}
+}
+
+func TestOutboxSendUnreachable(t *testing.T) {
+	sink := newSMTPSink(t)
+	ob := newTestOutbox(sink)
+	sink.listener.Close()
+	outcome := ob.send(testOutboxMessage(0))
+	if outcome.Status != outboxPending || outcome.Retry != 30*time.Second {
+		t.Fatalf("outcome = %+v; want pending with a 30s retry", outcome)
+	}
+}
+
+func TestOutboxRetryDelay(t *testing.T) {
+	ob := newEmailOutbox(nil, OutboxConfig{RetryBase: 30 * time.Second, RetryMax: 5 * time.Minute})
+	for attempts, want := range map[int]time.Duration{1: 30 * time.Second, 2: time.Minute, 4: 4 * time.Minute,
+		5: 5 * time.Minute, 80: 5 * time.Minute} {
+		if got := ob.retryDelay(attempts); got != want {
+			t.Errorf("retryDelay(%d) = %s; want %s", attempts, got, want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// content type of an RFC 7807 problem response
+const problemContentType = "application/problem+json"
+
+// problemDetails is an RFC 7807 problem response. Errors lists each invalid field of a request.
+type problemDetails struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []fieldError `json:"errors,omitempty"`
+}
+
+// fieldError is a problem with one field of a request. Field is the JSON path of the field, like
+// request.email or items[2].period.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// fieldErrors collects the problems found while validating a request
+type fieldErrors []fieldError
+
+func (errs *fieldErrors) add(field, message string) {
+	*errs = append(*errs, fieldError{Field: field, Message: message})
+}
+
+// abortWithProblem ends the request with an RFC 7807 problem+json response
+func abortWithProblem(c *gin.Context, status int, detail string, errs []fieldError) {
+	problem := problemDetails{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  c.Request.URL.Path,
+		RequestID: c.GetString("request_id"),
+		Errors:    errs,
+	}
+	c.Header("Content-Type", problemContentType)
+	c.AbortWithStatusJSON(status, problem)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// postReserves posts a body to the create course reserves handler and returns the response
+func postReserves(t *testing.T, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	svc := &ServiceContext{ReserveItemLimit: 2, ReserveRoutes: &reserveRoutes{Libraries: []string{"clemons"}}}
+	router := gin.New()
+	router.Use(requestLogMiddleware)
+	router.POST("/reserves", svc.createCourseReserves)
+	req := httptest.NewRequest("POST", "/reserves", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(requestIDHeader, "test-request-1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestReserveProblemResponse(t *testing.T) {
+	w := postReserves(t, `{"request":{"name":"Mary Smith","email":"mst3k","course":"ENGL 1010","semester":"Fall 2026",
+		"library":"music"},"items":[]}`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d; want 400", w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); strings.HasPrefix(contentType, problemContentType) == false {
+		t.Errorf("content type = %s; want %s", contentType, problemContentType)
+	}
+
+	var problem map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"type": "about:blank", "title": "Bad Request", "status": float64(400),
+		"detail": "reserve request has 3 invalid field(s)", "instance": "/reserves", "request_id": "test-request-1"}
+	for key, value := range want {
+		if problem[key] != value {
+			t.Errorf("%s = %v; want %v", key, problem[key], value)
+		}
+	}
+	if len(problem) != len(want)+1 {
+		t.Errorf("problem has fields %v; want only %v and errors", problem, want)
+	}
+
+	var body struct {
+		Errors []map[string]string `json:"errors"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	fields := make([]string, 0)
+	for _, fieldErr := range body.Errors {
+		if len(fieldErr) != 2 || fieldErr["message"] == "" {
+			t.Errorf("error %v; want a field and message", fieldErr)
+		}
+		fields = append(fields, fieldErr["field"])
+	}
+	if strings.Join(fields, ",") != "request.email,request.library,items" {
+		t.Errorf("error fields = %v; want request.email, request.library and items", fields)
+	}
+}
+
+func TestReserveProblemUnparseable(t *testing.T) {
+	w := postReserves(t, `{"request":`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d; want 400", w.Code)
+	}
+	var problem problemDetails
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatal(err)
+	}
+	if problem.Status != http.StatusBadRequest || strings.HasPrefix(problem.Detail, "unable to parse reserve request") == false {
+		t.Errorf("problem = %+v; want a parse error", problem)
+	}
+	if strings.Contains(w.Body.String(), `"errors"`) {
+		t.Errorf("problem %s has errors; want them left out when there are none", w.Body.String())
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+	"golang.org/x/time/rate"
+)
+
+// clients that have not made a public request in this long are dropped from the rate limiter
+const publicClientIdle = 10 * time.Minute
+
+// lifetime of the guest JWT used for ILS requests made on behalf of public callers
+const publicJWTLifetime = time.Hour
+
+// publicAccess rate limits unauthenticated availability requests per client IP. It also holds the guest
+// JWT sent to the ILS for these requests, so public callers never need a token of their own.
+type publicAccess struct {
+	Config   PublicConfig
+	jwtKey   string
+	lock     sync.Mutex
+	clients  map[string]*publicClient
+	jwt      string
+	jwtUntil time.Time
+}
+
+type publicClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newPublicAccess(cfg PublicConfig, jwtKey string) *publicAccess {
+	pa := &publicAccess{Config: cfg, jwtKey: jwtKey, clients: make(map[string]*publicClient)}
+	go pa.dropIdleClients()
+	return pa
+}
+
+// publicMiddleware rate limits public requests and adds a guest JWT for calls to the ILS
+func (svc *ServiceContext) publicMiddleware(c *gin.Context) {
+	clientIP := c.ClientIP()
+	if svc.Public.allow(clientIP) == false {
+		slog.WarnContext(c.Request.Context(), "public availability rate limit exceeded", "client_ip", clientIP)
+		c.AbortWithStatus(http.StatusTooManyRequests)
+		return
+	}
+
+	guestJWT, err := svc.Public.guestJWT()
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "unable to create guest JWT", "error", err.Error())
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.Set("jwt", guestJWT)
+	c.Next()
+}
+
+// getPublicAvailability gets availability for callers that are not signed in. There are no claims, so
+// options that depend on the user are never added. Options that need sign in are listed with
+// sign_in_required but without their item details.
+func (svc *ServiceContext) getPublicAvailability(c *gin.Context) {
+	itemQ, err := parseItemQuery(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	availResp, solrDoc, _ := svc.availabilityForRequest(c, nil, nil)
+	if availResp == nil {
+		return
+	}
+	if itemQ.isSet() {
+		itemQ.apply(availResp)
+	}
+
+	for idx := range availResp.Availability.RequestOptions {
+		opt := &availResp.Availability.RequestOptions[idx]
+		if opt.SignInRequired {
+			opt.CreateURL = ""
+			opt.ItemOptions = make([]ItemOption, 0)
+		}
+	}
+	if solrDoc != nil {
+		availResp.Availability.AnonStatus = solrDoc.AnonAvailability
+	}
+	c.JSON(http.StatusOK, availResp)
+}
+
+func (pa *publicAccess) allow(clientIP string) bool {
+	pa.lock.Lock()
+	defer pa.lock.Unlock()
+	client, found := pa.clients[clientIP]
+	if found == false {
+		client = &publicClient{limiter: rate.NewLimiter(rate.Limit(pa.Config.Rate), pa.Config.Burst)}
+		pa.clients[clientIP] = client
+	}
+	client.lastSeen = time.Now()
+	return client.limiter.Allow()
+}
+
+// guestJWT returns a guest JWT, minting a new one when the current one is close to expiring
+func (pa *publicAccess) guestJWT() (string, error) {
+	pa.lock.Lock()
+	defer pa.lock.Unlock()
+	if pa.jwt != "" && time.Now().Before(pa.jwtUntil) {
+		return pa.jwt, nil
+	}
+	claims := v4jwt.V4Claims{UserID: "anonymous", Role: v4jwt.Guest, AuthMethod: v4jwt.NoAuth}
+	signed, err := v4jwt.Mint(claims, publicJWTLifetime, pa.jwtKey)
+	if err != nil {
+		return "", err
+	}
+	pa.jwt = signed
+	pa.jwtUntil = time.Now().Add(publicJWTLifetime - 5*time.Minute)
+	return pa.jwt, nil
+}
+
+func (pa *publicAccess) dropIdleClients() {
+	for range time.Tick(time.Minute) {
+		pa.lock.Lock()
+		for ip, client := range pa.clients {
+			if time.Since(client.lastSeen) > publicClientIdle {
+				delete(pa.clients, ip)
+			}
+		}
+		pa.lock.Unlock()
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// publicRequests makes public requests from a remote address with a forwarded client address and
+// returns the status of each
+func publicRequests(t *testing.T, trustedProxies []string, remoteAddr string, forwardedFor ...string) []int {
+	t.Helper()
+	svc := &ServiceContext{JWTKey: "test", Public: newPublicAccess(PublicConfig{Rate: 0.001, Burst: 1}, "test")}
+	router := gin.New()
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		t.Fatal(err)
+	}
+	router.GET("/public/item/:id", svc.publicMiddleware, func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	status := make([]int, 0)
+	for _, client := range forwardedFor {
+		req := httptest.NewRequest("GET", "/public/item/u1", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", client)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		status = append(status, w.Code)
+	}
+	return status
+}
+
+func TestPublicRateLimitClientIP(t *testing.T) {
+	// without trusted proxies a caller can't get a new rate limit by changing X-Forwarded-For
+	got := publicRequests(t, nil, "203.0.113.5:4000", "198.51.100.1", "198.51.100.2")
+	if got[0] != http.StatusOK || got[1] != http.StatusTooManyRequests {
+		t.Errorf("untrusted forwarded requests = %v; want the second one rate limited", got)
+	}
+
+	// behind the load balancer each forwarded client has its own limit
+	got = publicRequests(t, []string{"10.0.0.0/8"}, "10.1.2.3:4000", "198.51.100.1", "198.51.100.2", "198.51.100.1")
+	if got[0] != http.StatusOK || got[1] != http.StatusOK || got[2] != http.StatusTooManyRequests {
+		t.Errorf("requests through a trusted proxy = %v; want [200 200 429]", got)
+	}
+}
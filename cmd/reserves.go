@@ -2,14 +2,17 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	htmltemplate "html/template"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"path/filepath"
 	"sort"
 	"strings"
-	"text/template"
+	texttemplate "text/template"
 
 	"github.com/gin-gonic/gin"
 )
@@ -51,29 +54,24 @@ type requestParams struct {
 	OtherLMS        string `json:"otherLMS"`
 }
 
-type reserveRequest struct {
-	VirgoURL string
-	UserID   string         `json:"userID"`
-	Request  requestParams  `json:"request"`
-	Items    []requestItem  `json:"items"` // these are the items sent from the client
-	Video    []*requestItem `json:"-"`     // populated during processing from Items, includes avail
-	NonVideo []*requestItem `json:"-"`     // populated during processing from Items, includes avail
-	MaxAvail int            `json:"-"`
-}
-
-type ilsAvail struct {
-	Availability struct {
-		Items []ilsItem `json:"items"`
-	} `json:"availability"`
-}
-
-type ilsItem struct {
-	Fields []field `json:"fields"`
+// instructorEmail is the email of the instructor for the course; the requester unless the
+// request was made on behalf of an instructor
+func (p *requestParams) instructorEmail() string {
+	if p.InstructorEmail != "" {
+		return p.InstructorEmail
+	}
+	return p.Email
 }
 
-type field struct {
-	Name  string `json:"name"`
-	Value string `json:"value"`
+type reserveRequest struct {
+	VirgoURL  string
+	RequestID int64          `json:"-"` // set once the request is saved
+	UserID    string         `json:"userID"`
+	Request   requestParams  `json:"request"`
+	Items     []requestItem  `json:"items"` // these are the items sent from the client
+	Video     []*requestItem `json:"-"`     // populated during processing from Items, includes avail
+	NonVideo  []*requestItem `json:"-"`     // populated during processing from Items, includes avail
+	MaxAvail  int            `json:"-"`
 }
 
 type validateResponse struct {
@@ -127,7 +125,7 @@ type solrReservesHit struct {
 func (svc *ServiceContext) searchReserves(c *gin.Context) {
 	searchType := c.Query("type")
 	if searchType != "instructor_name" && searchType != "course_id" {
-		log.Printf("ERROR: invalid course reserves search type: %s", searchType)
+		slog.ErrorContext(c.Request.Context(), "invalid course reserves search type", "type", searchType)
 		c.String(http.StatusBadRequest, fmt.Sprintf("%s is not a valid search type", searchType))
 		return
 	}
@@ -137,13 +135,13 @@ func (svc *ServiceContext) searchReserves(c *gin.Context) {
 		queryStr += "*"
 	}
 
-	claims, err := getJWTClaims(c)
+	_, err := getJWTClaims(c)
 	if err != nil {
-		log.Printf("ERROR: search reserves without claims: %s", err.Error())
+		slog.ErrorContext(c.Request.Context(), "search reserves without claims", "error", err.Error())
 		c.String(http.StatusForbidden, "not authorized")
 		return
 	}
-	log.Printf("INFO: user [%s] is searching course reserves [%s] for [%s]", claims.UserID, searchType, queryStr)
+	slog.InfoContext(c.Request.Context(), "search course reserves", "type", searchType, "query", queryStr)
 
 	fl := url.QueryEscape("id,reserve_id_course_name_a,title_a,work_primary_author_a,call_number_a")
 	queryParam := "reserve_id_a"
@@ -163,28 +161,28 @@ func (svc *ServiceContext) searchReserves(c *gin.Context) {
 	queryParam = fmt.Sprintf("%s:%s", queryParam, queryStr)
 	solrURL := fmt.Sprintf("select?fl=%s&q=%s&rows=5000", fl, queryParam)
 
-	respBytes, solrErr := svc.SolrGet(solrURL)
+	respBytes, solrErr := svc.SolrGet(c.Request.Context(), solrURL)
 	if solrErr != nil {
-		log.Printf("ERROR: solr course reserves search failed: %s", solrErr.Message)
+		slog.ErrorContext(c.Request.Context(), "solr course reserves search failed", "status", solrErr.StatusCode, "error", solrErr.Message)
 	}
 	var solrResp solrReservesResponse
 	if err := json.Unmarshal(respBytes, &solrResp); err != nil {
-		log.Printf("ERROR: unable to parse solr response: %s.", err.Error())
+		slog.ErrorContext(c.Request.Context(), "unable to parse solr response", "error", err.Error())
 	}
-	log.Printf("INFO: found [%d] matches", solrResp.Response.NumFound)
+	slog.InfoContext(c.Request.Context(), "course reserves search done", "matches", solrResp.Response.NumFound)
 
 	if searchType == "instructor_name" {
-		reserves := extractInstructorReserves(rawQueryStr, solrResp.Response.Docs)
+		reserves := extractInstructorReserves(c.Request.Context(), rawQueryStr, solrResp.Response.Docs)
 		c.JSON(http.StatusOK, reserves)
 		return
 	}
 
-	reserves := extractCourseReserves(rawQueryStr, solrResp.Response.Docs)
+	reserves := extractCourseReserves(c.Request.Context(), rawQueryStr, solrResp.Response.Docs)
 	c.JSON(http.StatusOK, reserves)
 }
 
-func extractCourseReserves(tgtCourseID string, docs []solrReservesHit) []*courseSearchResponse {
-	log.Printf("INFO: extract instructor course reserves for %s", tgtCourseID)
+func extractCourseReserves(ctx context.Context, tgtCourseID string, docs []solrReservesHit) []*courseSearchResponse {
+	slog.InfoContext(ctx, "extract instructor course reserves", "course_id", tgtCourseID)
 	out := make([]*courseSearchResponse, 0)
 	for _, doc := range docs {
 		for _, reserve := range doc.ReserveInfo {
@@ -198,7 +196,7 @@ func extractCourseReserves(tgtCourseID string, docs []solrReservesHit) []*course
 				continue
 			}
 
-			log.Printf("INFO: process item %s reserve %s", doc.ID, reserve)
+			slog.InfoContext(ctx, "process reserve item", "title_id", doc.ID, "reserve", reserve)
 			item := reserveItem{ID: doc.ID, Title: doc.Title[0],
 				Author:     strings.Join(doc.Author, "; "),
 				CallNumber: strings.Join(doc.CallNumber, ", ")}
@@ -207,13 +205,13 @@ func extractCourseReserves(tgtCourseID string, docs []solrReservesHit) []*course
 			var tgtCourse *courseSearchResponse
 			for _, csr := range out {
 				if csr.CourseID == courseID {
-					log.Printf("INFO: found existing record for course %s", courseID)
+					slog.InfoContext(ctx, "found existing record for course", "course_id", courseID)
 					tgtCourse = csr
 					break
 				}
 			}
 			if tgtCourse == nil {
-				log.Printf("INFO: create new record for course %s", courseID)
+				slog.InfoContext(ctx, "create new record for course", "course_id", courseID)
 				newCourse := courseSearchResponse{CourseID: courseID, CourseName: courseName}
 				tgtCourse = &newCourse
 				out = append(out, tgtCourse)
@@ -224,7 +222,7 @@ func extractCourseReserves(tgtCourseID string, docs []solrReservesHit) []*course
 				if inst.InstructorName == instructor {
 					found = true
 					if itemExists(inst.Items, item.ID) == false {
-						log.Printf("INFO: append item to existing instructor...")
+						slog.InfoContext(ctx, "append item to existing instructor", "instructor", instructor, "item", item.ID)
 						inst.Items = append(inst.Items, item)
 						break
 					}
@@ -232,11 +230,11 @@ func extractCourseReserves(tgtCourseID string, docs []solrReservesHit) []*course
 			}
 
 			if found == false {
-				log.Printf("INFO: create new record for instructor %s", instructor)
+				slog.InfoContext(ctx, "create new record for instructor", "instructor", instructor)
 				newInst := instructorItems{InstructorName: instructor}
 				newInst.Items = append(newInst.Items, item)
 				tgtCourse.Instructors = append(tgtCourse.Instructors, &newInst)
-				log.Printf("INFO: new instructor: %v", newInst)
+				slog.InfoContext(ctx, "new instructor", "instructor", fmt.Sprintf("%v", newInst))
 			}
 		}
 	}
@@ -255,8 +253,8 @@ func extractCourseReserves(tgtCourseID string, docs []solrReservesHit) []*course
 	return out
 }
 
-func extractInstructorReserves(tgtInstructor string, docs []solrReservesHit) []*instructorSearchResponse {
-	log.Printf("INFO: extract course course reserves instructor %s", tgtInstructor)
+func extractInstructorReserves(ctx context.Context, tgtInstructor string, docs []solrReservesHit) []*instructorSearchResponse {
+	slog.InfoContext(ctx, "extract course reserves for instructor", "instructor", tgtInstructor)
 	out := make([]*instructorSearchResponse, 0)
 	for _, doc := range docs {
 		for _, reserve := range doc.ReserveInfo {
@@ -269,7 +267,7 @@ func extractInstructorReserves(tgtInstructor string, docs []solrReservesHit) []*
 				continue
 			}
 
-			log.Printf("INFO: process item %s reserve %s", doc.ID, reserve)
+			slog.InfoContext(ctx, "process reserve item", "title_id", doc.ID, "reserve", reserve)
 			item := reserveItem{ID: doc.ID, Title: doc.Title[0],
 				Author:     strings.Join(doc.Author, "; "),
 				CallNumber: strings.Join(doc.CallNumber, ", ")}
@@ -339,24 +337,25 @@ func (svc *ServiceContext) validateCourseReserves(c *gin.Context) {
 	}
 	err := c.ShouldBindJSON(&req)
 	if err != nil {
-		log.Printf("ERROR: Unable to parse request: %s", err.Error())
+		slog.ErrorContext(c.Request.Context(), "unable to parse request", "error", err.Error())
 		c.String(http.StatusBadRequest, err.Error())
 		return
 	}
 
-	log.Printf("INFO: validate course reserve items %v", req.Items)
-	url := fmt.Sprintf("%s/course_reserves/validate", svc.ILSAPI)
-	bodyBytes, ilsErr := svc.ILSConnectorPost(url, req, c.GetString("jwt"))
-	if ilsErr != nil {
-		c.String(ilsErr.StatusCode, ilsErr.Message)
-		return
+	for idx, rawID := range req.Items {
+		titleID, err := validateTitleID(rawID)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "invalid course reserve item", "title_id", rawID, "error", err.Error())
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		req.Items[idx] = titleID
 	}
-	// log.Printf("INFO: raw ilsconnect response: %s", bodyBytes)
 
-	var resp []validateResponse
-	if err := json.Unmarshal(bodyBytes, &resp); err != nil {
-		log.Printf("ERROR: unable to parse reserve search: %s", err.Error())
-		c.String(http.StatusInternalServerError, err.Error())
+	slog.InfoContext(c.Request.Context(), "validate course reserve items", "items", req.Items)
+	resp, ilsErr := svc.ILS.ValidateReserves(c.Request.Context(), req.Items, c.GetString("jwt"))
+	if ilsErr != nil {
+		c.String(ilsErr.StatusCode, ilsErr.Message)
 		return
 	}
 
@@ -365,13 +364,13 @@ func (svc *ServiceContext) validateCourseReserves(c *gin.Context) {
 	// (ils connector doesn't have enout info to determine this completely)
 	v4Claims, _ := getJWTClaims(c)
 	if v4Claims.CanPlaceReserve {
-		log.Printf("INFO: check if any items are type videoReserve")
+		slog.InfoContext(c.Request.Context(), "check if any items are type videoReserve")
 		for idx, item := range resp {
 			if item.Reserve == false || item.IsVideo == false {
-				solrDoc := svc.getSolrDoc(item.ID)
+				solrDoc := svc.getSolrDoc(c.Request.Context(), item.ID)
 				if solrDoc != nil {
 					if (solrDoc.Pool[0] == "video" && contains(solrDoc.Location, "Internet materials")) || contains(solrDoc.Source, "Avalon") {
-						log.Printf("INFO: %s is a video", item.ID)
+						slog.InfoContext(c.Request.Context(), "item is a video", "title_id", item.ID)
 						resp[idx].IsVideo = true
 						resp[idx].Reserve = true
 					}
@@ -384,14 +383,23 @@ func (svc *ServiceContext) validateCourseReserves(c *gin.Context) {
 }
 
 func (svc *ServiceContext) createCourseReserves(c *gin.Context) {
-	log.Printf("Received request to create new course reserves")
+	ctx := c.Request.Context()
+	slog.InfoContext(ctx, "received request to create new course reserves")
 	var reserveReq reserveRequest
 	err := c.ShouldBindJSON(&reserveReq)
 	if err != nil {
-		log.Printf("ERROR: Unable to parse request: %s", err.Error())
-		c.String(http.StatusBadRequest, err.Error())
+		slog.ErrorContext(ctx, "unable to parse request", "error", err.Error())
+		abortWithProblem(c, http.StatusBadRequest, fmt.Sprintf("unable to parse reserve request: %s", err.Error()), nil)
 		return
 	}
+	if errs := svc.validateReserveRequest(&reserveReq); len(errs) > 0 {
+		slog.ErrorContext(ctx, "invalid course reserve request", "errors", fmt.Sprintf("%+v", errs))
+		abortWithProblem(c, http.StatusBadRequest, fmt.Sprintf("reserve request has %d invalid field(s)", len(errs)), errs)
+		return
+	}
+	if claims, err := getJWTClaims(c); err == nil {
+		reserveReq.UserID = claims.UserID
+	}
 	reserveReq.VirgoURL = svc.VirgoURL
 	reserveReq.MaxAvail = -1
 	reserveReq.Video = make([]*requestItem, 0)
@@ -399,111 +407,166 @@ func (svc *ServiceContext) createCourseReserves(c *gin.Context) {
 
 	// Iterate thru all of the requested items, pull availability and stuff it into
 	// an array based on type. Separate emails will go out for video / non-video
-	for _, item := range reserveReq.Items {
+	for idx := range reserveReq.Items {
+		item := &reserveReq.Items[idx]
 		item.VirgoURL = fmt.Sprintf("%s/sources/%s/items/%s", svc.VirgoURL, item.Pool, item.CatalogKey)
-		svc.getItemAvailability(&item, c.GetString("jwt"))
+		svc.getItemAvailability(ctx, item, c.GetString("jwt"))
 		if len(item.Availability) > reserveReq.MaxAvail {
 			reserveReq.MaxAvail = len(item.Availability)
 		}
 		if item.IsVideo {
-			log.Printf("INFO: %s : %s is a video", item.CatalogKey, item.Title)
-			reserveReq.Video = append(reserveReq.Video, &item)
+			slog.InfoContext(ctx, "reserve item is a video", "title_id", item.CatalogKey, "title", item.Title)
+			reserveReq.Video = append(reserveReq.Video, item)
 		} else {
-			log.Printf("INFO: %s : %s is not a video", item.CatalogKey, item.Title)
-			reserveReq.NonVideo = append(reserveReq.NonVideo, &item)
+			slog.InfoContext(ctx, "reserve item is not a video", "title_id", item.CatalogKey, "title", item.Title)
+			reserveReq.NonVideo = append(reserveReq.NonVideo, item)
 		}
 	}
 
-	funcs := template.FuncMap{"add": func(x, y int) int {
-		return x + y
-	}}
+	priorItems, err := svc.findPriorReserveItems(&reserveReq)
+	if err != nil {
+		slog.WarnContext(ctx, "unable to check for prior reserve requests", "error", err.Error())
+	} else if len(priorItems) > 0 {
+		slog.WarnContext(ctx, "items already requested", "instructor_email", reserveReq.Request.instructorEmail(),
+			"items", priorItems, "course", reserveReq.Request.Course, "semester", reserveReq.Request.Semester)
+	}
 
-	templates := [2]string{"reserves.txt", "reserves_video.txt"}
-	for _, templateFile := range templates {
-		if templateFile == "reserves.txt" && len(reserveReq.NonVideo) == 0 {
+	requestID, err := svc.saveReserveRequest(ctx, &reserveReq, svc.renderReserveEmails)
+	if err != nil {
+		slog.ErrorContext(ctx, "unable to save reserve request", "error", err.Error())
+		c.String(http.StatusInternalServerError, "unable to save reserve request")
+		return
+	}
+	slog.InfoContext(ctx, "reserve request accepted; emails queued", "reserve_request_id", requestID)
+	svc.Outbox.notify()
+	c.JSON(http.StatusAccepted, gin.H{"request_id": requestID, "status": reserveSubmitted})
+}
+
+// renderReserveEmails renders the emails for a saved reserve request: the staff emails, one for video
+// items and one for everything else, and the confirmation receipt for the requester. Recipients, sender and
+// template of the staff emails come from the reserve route for the library. Nothing is sent here; the
+// emails are queued in the outbox with the request.
+func (svc *ServiceContext) renderReserveEmails(ctx context.Context, reserveReq *reserveRequest) ([]*emailRequest, error) {
+	emails := make([]*emailRequest, 0)
+	for _, video := range []bool{false, true} {
+		if video == false && len(reserveReq.NonVideo) == 0 {
 			continue
 		}
-		if templateFile == "reserves_video.txt" && len(reserveReq.Video) == 0 {
+		if video && len(reserveReq.Video) == 0 {
 			continue
 		}
-		var renderedEmail bytes.Buffer
-		tpl := template.Must(template.New(templateFile).Funcs(funcs).ParseFiles(fmt.Sprintf("templates/%s", templateFile)))
-		err = tpl.Execute(&renderedEmail, reserveReq)
+		route := svc.ReserveRoutes.routeFor(reserveReq.Request.Library, video)
+		if route == nil {
+			return nil, fmt.Errorf("no reserve route for %s %s items", reserveReq.Request.Library, itemType(video))
+		}
+		reserveTpl := route.template(video)
+		textBody, htmlBody, err := reserveTpl.render(reserveReq)
 		if err != nil {
-			log.Printf("ERROR: Unable to render %s: %s", templateFile, err.Error())
-			c.String(http.StatusInternalServerError, err.Error())
-			return
+			return nil, err
 		}
 
-		log.Printf("Generate SMTP message for %s", templateFile)
-		// NOTES for recipient: For any reserve library location other than Law, the email should be sent to
-		// svc.CourseReserveEmail with the from address of the patron submitting the request.
-		// For Law it should send the email to svc.LawReserveEmail AND the patron
-		to := []string{}
-		cc := ""
-		from := svc.SMTP.Sender
-		subjectName := reserveReq.Request.Name
-		if reserveReq.Request.Library == "law" {
-			log.Printf("The reserve library is law. Send request to law %s and requestor %s from sender %s",
-				svc.LawReserveEmail, reserveReq.Request.Email, svc.SMTP.Sender)
-			to = append(to, svc.LawReserveEmail)
-			to = append(to, reserveReq.Request.Email)
-			if reserveReq.Request.InstructorEmail != "" {
-				to = append(to, reserveReq.Request.InstructorEmail)
-			}
-		} else {
-			log.Printf("The reserve library is not law.")
-			to = append(to, svc.CourseReserveEmail)
-			if reserveReq.Request.InstructorEmail != "" {
-				from = reserveReq.Request.InstructorEmail
-				cc = reserveReq.Request.Email
-				subjectName = reserveReq.Request.InstructorName
-			} else {
-				from = reserveReq.Request.Email
-			}
-		}
+		addrs := route.addresses(&reserveReq.Request, svc.SMTP.Sender)
+		slog.InfoContext(ctx, "reserve route selected", "items", itemType(video),
+			"library", reserveReq.Request.Library, "route", route.Name, "template", reserveTpl.Name)
+		subject := fmt.Sprintf("%s - %s: %s", reserveReq.Request.Semester, addrs.SubjectName, reserveReq.Request.Course)
+		emails = append(emails, &emailRequest{Subject: subject, To: addrs.To, CC: addrs.CC, From: addrs.From,
+			ReplyTo: addrs.ReplyTo, Body: textBody, HTMLBody: htmlBody})
+	}
 
-		subject := fmt.Sprintf("%s - %s: %s", reserveReq.Request.Semester, subjectName, reserveReq.Request.Course)
-		eRequest := emailRequest{Subject: subject, To: to, CC: cc, From: from, Body: renderedEmail.String()}
-		sendErr := svc.sendEmail(&eRequest)
-		if sendErr != nil {
-			log.Printf("ERROR: Unable to send reserve email: %s", sendErr.Error())
-			c.String(http.StatusInternalServerError, sendErr.Error())
-			return
-		}
+	receipt, err := svc.renderReserveReceipt(reserveReq)
+	if err != nil {
+		return nil, err
 	}
-	c.String(http.StatusOK, "Reserve emails sent")
+	return append(emails, receipt), nil
 }
 
-func (svc *ServiceContext) getItemAvailability(reqItem *requestItem, jwt string) {
-	log.Printf("INFO: check if item %s is available for course reserve", reqItem.CatalogKey)
-	reqItem.Availability = make([]availabilityInfo, 0)
-	availabilityURL := fmt.Sprintf("%s/availability/%s", svc.ILSAPI, reqItem.CatalogKey)
-	bodyBytes, ilsErr := svc.ILSConnectorGet(availabilityURL, jwt, svc.HTTPClient)
-	if ilsErr != nil {
-		log.Printf("WARN: Unable to get availabilty info for reserve %s: %s", reqItem.CatalogKey, ilsErr.Message)
-		return
+// renderReserveReceipt renders the confirmation sent to the requester, and to the instructor if the request
+// was made on their behalf. It comes from the service sender; replies go to the reserves desk for the library.
+func (svc *ServiceContext) renderReserveReceipt(reserveReq *reserveRequest) (*emailRequest, error) {
+	textBody, htmlBody, err := receiptTemplate.render(reserveReq)
+	if err != nil {
+		return nil, err
+	}
+	to := []string{reserveReq.Request.Email}
+	if reserveReq.Request.OnBehalfOf == "yes" && reserveReq.Request.InstructorEmail != "" &&
+		reserveReq.Request.InstructorEmail != reserveReq.Request.Email {
+		to = append(to, reserveReq.Request.InstructorEmail)
 	}
+	replyTo := ""
+	if route := svc.ReserveRoutes.routeFor(reserveReq.Request.Library, len(reserveReq.NonVideo) == 0); route != nil {
+		replyTo = route.desk
+	}
+	subject := fmt.Sprintf("Course reserve request %d received - %s %s", reserveReq.RequestID,
+		reserveReq.Request.Course, reserveReq.Request.Semester)
+	return &emailRequest{Subject: subject, To: to, From: svc.SMTP.Sender, ReplyTo: replyTo,
+		Body: textBody, HTMLBody: htmlBody}, nil
+}
+
+// reserveTemplate is a course reserve email. It has a plain text template, templates/<Name>.txt, and
+// an HTML template, templates/<Name>.html, with the same content.
+type reserveTemplate struct {
+	Name string
+}
+
+// templateDir is where the reserve email templates are, relative to the working directory
+var templateDir = "templates"
+
+// receiptTemplate is the confirmation email sent to the requester
+var receiptTemplate = reserveTemplate{Name: "reserves_receipt"}
 
-	var availData ilsAvail
-	err := json.Unmarshal([]byte(bodyBytes), &availData)
+// functions available to the reserve email templates
+var reserveTemplateFuncs = map[string]interface{}{"add": func(x, y int) int {
+	return x + y
+}}
+
+// parse loads and parses the text and HTML templates of a reserve email
+func (rt reserveTemplate) parse() (*texttemplate.Template, *htmltemplate.Template, error) {
+	textFile := fmt.Sprintf("%s.txt", rt.Name)
+	textTpl, err := texttemplate.New(textFile).Funcs(reserveTemplateFuncs).ParseFiles(filepath.Join(templateDir, textFile))
+	if err != nil {
+		return nil, nil, err
+	}
+	htmlFile := fmt.Sprintf("%s.html", rt.Name)
+	htmlTpl, err := htmltemplate.New(htmlFile).Funcs(reserveTemplateFuncs).ParseFiles(filepath.Join(templateDir, htmlFile))
+	if err != nil {
+		return nil, nil, err
+	}
+	return textTpl, htmlTpl, nil
+}
+
+// render renders the plain text and HTML bodies of a reserve email. Values in the HTML body are escaped.
+func (rt reserveTemplate) render(data interface{}) (string, string, error) {
+	textTpl, htmlTpl, err := rt.parse()
 	if err != nil {
-		log.Printf("WARN: Invalid ILS Availabilty response for %s: %s", reqItem.CatalogKey, err.Error())
+		return "", "", fmt.Errorf("unable to parse %s templates: %s", rt.Name, err.Error())
+	}
+	var textBody, htmlBody bytes.Buffer
+	if err = textTpl.Execute(&textBody, data); err != nil {
+		return "", "", fmt.Errorf("unable to render %s.txt: %s", rt.Name, err.Error())
+	}
+	if err = htmlTpl.Execute(&htmlBody, data); err != nil {
+		return "", "", fmt.Errorf("unable to render %s.html: %s", rt.Name, err.Error())
+	}
+	return textBody.String(), htmlBody.String(), nil
+}
+
+// getItemAvailability adds the library, location and status of each copy of a reserve item
+func (svc *ServiceContext) getItemAvailability(ctx context.Context, reqItem *requestItem, jwt string) {
+	slog.InfoContext(ctx, "check if item is available for course reserve", "title_id", reqItem.CatalogKey)
+	reqItem.Availability = make([]availabilityInfo, 0)
+	availData, ilsErr := svc.ILS.GetAvailability(ctx, reqItem.CatalogKey, jwt)
+	if ilsErr != nil {
+		slog.WarnContext(ctx, "unable to get availability for reserve item", "title_id", reqItem.CatalogKey, "status", ilsErr.StatusCode, "error", ilsErr.Message)
 		return
 	}
 
-	for _, availItem := range availData.Availability.Items {
-		avail := availabilityInfo{}
-		for _, field := range availItem.Fields {
-			if field.Name == "Library" {
-				avail.Library = field.Value
-			} else if field.Name == "Availability" {
-				avail.Availability = field.Value
-			} else if field.Name == "Current Location" {
-				avail.Location = field.Value
-			} else if field.Name == "Call Number" {
-				avail.CallNumber = field.Value
-			}
+	for _, item := range availData.Availability.Items {
+		normalizeItemStatus(item)
+		avail := availabilityInfo{
+			Library:      item.Library,
+			Location:     item.CurrentLocation,
+			Availability: item.StatusLabel,
+			CallNumber:   item.CallNumber,
 		}
 		reqItem.Availability = append(reqItem.Availability, avail)
 	}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// useRepoTemplates points the reserve templates at the repo templates; tests run in cmd/
+func useRepoTemplates(t *testing.T) {
+	t.Helper()
+	templateDir = "../templates"
+	t.Cleanup(func() { templateDir = "templates" })
+}
+
+// testReserveRequest is a saved reserve request for the items, split into video and non-video items the
+// way createCourseReserves does it
+func testReserveRequest(params requestParams, items ...requestItem) *reserveRequest {
+	req := reserveRequest{VirgoURL: "https://search.lib.virginia.edu", RequestID: 42, UserID: "mst3k",
+		Request: params, Items: items, Video: make([]*requestItem, 0), NonVideo: make([]*requestItem, 0)}
+	for idx := range req.Items {
+		item := &req.Items[idx]
+		item.VirgoURL = fmt.Sprintf("%s/sources/%s/items/%s", req.VirgoURL, item.Pool, item.CatalogKey)
+		if item.IsVideo {
+			req.Video = append(req.Video, item)
+		} else {
+			req.NonVideo = append(req.NonVideo, item)
+		}
+	}
+	return &req
+}
+
+func testReserveParams() requestParams {
+	return requestParams{OnBehalfOf: "no", Name: "Mary Smith", Email: "mst3k@virginia.edu", Course: "ENGL 1010",
+		Semester: "Fall 2026", Library: "clemons", Period: "3h", LMS: "Canvas"}
+}
+
+func testBook() requestItem {
+	return requestItem{Pool: "uva_library", CatalogKey: "u3523432", Title: "Moby Dick", Author: "Melville, Herman",
+		CallNumber: []string{"PS2384 .M6 2001"}, Period: "3h", Notes: "Chapters 1-10",
+		Availability: []availabilityInfo{
+			{Library: "Clemons", Location: "Stacks", Availability: "Available", CallNumber: "PS2384 .M6 2001"},
+			{Library: "Alderman", Location: "Stacks", Availability: "Checked out, due Nov 3", CallNumber: "PS2384 .M6 2001 c.2"},
+		}}
+}
+
+func testVideo() requestItem {
+	return requestItem{Pool: "video", IsVideo: true, CatalogKey: "u6543210", Title: "Jaws", Author: "Spielberg, Steven",
+		AudioLanguage: "English", Subtitles: "yes", SubtitleLanguage: "Spanish", Notes: "Stream from week 3",
+		Availability: []availabilityInfo{{Library: "Clemons", Location: "Internet materials", Availability: "Available"}}}
+}
+
+func TestReserveTemplatesGolden(t *testing.T) {
+	useRepoTemplates(t)
+
+	onBehalf := testReserveParams()
+	onBehalf.OnBehalfOf = "yes"
+	onBehalf.Name = "Pat Assistant"
+	onBehalf.Email = "pa1x@virginia.edu"
+	onBehalf.InstructorName = "Mary Smith"
+	onBehalf.InstructorEmail = "mst3k@virginia.edu"
+
+	noAvailBook := testBook()
+	noAvailBook.Availability = []availabilityInfo{}
+	noAvailVideo := testVideo()
+	noAvailVideo.Availability = nil
+	noAvailVideo.Subtitles = "no"
+
+	escaped := testReserveParams()
+	escaped.Name = `Pat O'Brien <b>"PI"</b>`
+	escaped.Course = "ENGL 1010 & 1020"
+	escaped.LMS = "Other"
+	escaped.OtherLMS = `<script>alert("lms")</script>`
+	escapedBook := testBook()
+	escapedBook.Title = `<script>alert("title")</script> & Sons`
+	escapedBook.Notes = `<img src=x onerror="alert(1)">`
+	escapedVideo := testVideo()
+	escapedVideo.Author = "Smith & <i>Jones</i>"
+
+	tests := []struct {
+		name string
+		req  *reserveRequest
+	}{
+		{"on_behalf_of", testReserveRequest(onBehalf, testBook(), testVideo())},
+		{"no_availability", testReserveRequest(testReserveParams(), noAvailBook, noAvailVideo)},
+		{"escaped", testReserveRequest(escaped, escapedBook, escapedVideo)},
+	}
+	for _, test := range tests {
+		for _, reserveTpl := range []reserveTemplate{{Name: "reserves"}, {Name: "reserves_video"}, receiptTemplate} {
+			t.Run(test.name+"/"+reserveTpl.Name, func(t *testing.T) {
+				textBody, htmlBody, err := reserveTpl.render(test.req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				golden := filepath.Join("testdata", "golden", fmt.Sprintf("%s_%s", reserveTpl.Name, test.name))
+				checkGolden(t, golden+".txt", textBody)
+				checkGolden(t, golden+".html", htmlBody)
+			})
+		}
+	}
+}
+
+// TestReserveTemplatesEscapeHTML checks that only the HTML bodies escape request values; plain text is sent as-is
+func TestReserveTemplatesEscapeHTML(t *testing.T) {
+	useRepoTemplates(t)
+	params := testReserveParams()
+	params.Name = `<b>Pat</b>`
+	book := testBook()
+	book.Title = `<script>alert("title")</script>`
+	req := testReserveRequest(params, book)
+	for _, reserveTpl := range []reserveTemplate{{Name: "reserves"}, receiptTemplate} {
+		textBody, htmlBody, err := reserveTpl.render(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, raw := range []string{`<b>Pat</b>`, `<script>`} {
+			if strings.Contains(htmlBody, raw) {
+				t.Errorf("%s.html has unescaped %s", reserveTpl.Name, raw)
+			}
+			if strings.Contains(textBody, raw) == false {
+				t.Errorf("%s.txt is missing %s", reserveTpl.Name, raw)
+			}
+		}
+		if strings.Contains(htmlBody, "&lt;script&gt;") == false {
+			t.Errorf("%s.html is missing the escaped title", reserveTpl.Name)
+		}
+	}
+}
+
+// checkGolden compares a rendered body with its golden file. Run go test -update to rewrite the golden files.
+func checkGolden(t *testing.T, path string, got string) {
+	t.Helper()
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%s; run go test -update to create it", err.Error())
+	}
+	if got != string(want) {
+		t.Errorf("%s differs from the rendered body\n got:\n%s\nwant:\n%s", path, got, want)
+	}
+}
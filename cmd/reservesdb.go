@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/lib/pq"
+)
+
+// saveReserveRequest stores a reserve request, all of its items (including the ILS availability
+// resolved for each) and its emails in a single transaction. The emails are rendered once the request
+// has an ID, so they can reference it, and queued in the outbox to be sent after the request is saved.
+// The ID of the new request is returned.
+func (svc *ServiceContext) saveReserveRequest(ctx context.Context, req *reserveRequest, renderEmails func(context.Context, *reserveRequest) ([]*emailRequest, error)) (int64, error) {
+	slog.InfoContext(ctx, "save reserve request", "course", req.Request.Course, "semester", req.Request.Semester)
+	tx, err := svc.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("unable to start transaction: %s", err.Error())
+	}
+	defer tx.Rollback()
+
+	var requestID int64
+	params := req.Request
+	err = tx.QueryRow(`INSERT INTO reserve_requests
+		(user_id, requester_name, requester_email, on_behalf_of, instructor_name, instructor_email,
+		 course, semester, library, period, lms, other_lms)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id`,
+		req.UserID, params.Name, params.Email, params.OnBehalfOf == "yes", params.InstructorName, params.instructorEmail(),
+		params.Course, params.Semester, params.Library, params.Period, params.LMS, params.OtherLMS).Scan(&requestID)
+	if err != nil {
+		return 0, fmt.Errorf("unable to insert reserve request: %s", err.Error())
+	}
+
+	_, err = tx.Exec(`INSERT INTO reserve_status_events (request_id, to_status, changed_by) VALUES ($1, $2, $3)`,
+		requestID, reserveSubmitted, req.UserID)
+	if err != nil {
+		return 0, fmt.Errorf("unable to insert reserve request status: %s", err.Error())
+	}
+
+	for _, item := range req.Items {
+		availJSON, jsonErr := json.Marshal(item.Availability)
+		if jsonErr != nil || item.Availability == nil {
+			availJSON = []byte("[]")
+		}
+		callNumbers := item.CallNumber
+		if callNumbers == nil {
+			callNumbers = make([]string, 0)
+		}
+		_, err = tx.Exec(`INSERT INTO reserve_request_items
+			(request_id, pool, catalog_key, is_video, title, author, call_number, period, notes,
+			 audio_language, subtitles, subtitle_language, availability)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+			requestID, item.Pool, item.CatalogKey, item.IsVideo, item.Title, item.Author, pq.Array(callNumbers),
+			item.Period, item.Notes, item.AudioLanguage, item.Subtitles, item.SubtitleLanguage, availJSON)
+		if err != nil {
+			return 0, fmt.Errorf("unable to insert reserve item %s: %s", item.CatalogKey, err.Error())
+		}
+	}
+
+	req.RequestID = requestID
+	emails, err := renderEmails(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+	if err = queueEmails(tx, requestID, emails); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("unable to commit reserve request: %s", err.Error())
+	}
+	slog.InfoContext(ctx, "reserve request saved", "reserve_request_id", requestID, "items", len(req.Items))
+	return requestID, nil
+}
+
+// findPriorReserveItems finds catalog keys from this request that the same instructor has already
+// submitted for the same course and semester
+func (svc *ServiceContext) findPriorReserveItems(req *reserveRequest) ([]string, error) {
+	keys := make([]string, 0)
+	for _, item := range req.Items {
+		keys = append(keys, item.CatalogKey)
+	}
+
+	out := make([]string, 0)
+	rows, err := svc.DB.Query(`SELECT DISTINCT i.catalog_key FROM reserve_request_items i
+		INNER JOIN reserve_requests r ON r.id = i.request_id
+		WHERE r.instructor_email = $1 AND r.course = $2 AND r.semester = $3 AND i.catalog_key = ANY($4)`,
+		req.Request.instructorEmail(), req.Request.Course, req.Request.Semester, pq.Array(keys))
+	if err != nil {
+		return out, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return out, err
+		}
+		out = append(out, key)
+	}
+	return out, rows.Err()
+}
+
+// findReserveRequests finds reserve requests (and their items) matching a where clause on the
+// reserve_requests table (aliased as r), newest first
+func (svc *ServiceContext) findReserveRequests(where string, args ...interface{}) ([]*reserveRequestStatus, error) {
+	out := make([]*reserveRequestStatus, 0)
+	rows, err := svc.DB.Query(fmt.Sprintf(`SELECT r.id, r.user_id, r.requester_name, r.instructor_name, r.instructor_email,
+		r.course, r.semester, r.library, r.status, r.created_at, r.updated_at
+		FROM reserve_requests r WHERE %s ORDER BY r.created_at DESC`, where), args...)
+	if err != nil {
+		return out, err
+	}
+	defer rows.Close()
+
+	byID := make(map[int64]*reserveRequestStatus)
+	ids := make([]int64, 0)
+	for rows.Next() {
+		req := reserveRequestStatus{Items: make([]*reserveItemStatus, 0)}
+		err = rows.Scan(&req.ID, &req.UserID, &req.Name, &req.InstructorName, &req.InstructorEmail,
+			&req.Course, &req.Semester, &req.Library, &req.Status, &req.CreatedAt, &req.UpdatedAt)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, &req)
+		byID[req.ID] = &req
+		ids = append(ids, req.ID)
+	}
+	if err = rows.Err(); err != nil {
+		return out, err
+	}
+	if len(ids) == 0 {
+		return out, nil
+	}
+
+	itemRows, err := svc.DB.Query(`SELECT id, request_id, catalog_key, title, author, is_video, period, status, status_note, updated_at
+		FROM reserve_request_items WHERE request_id = ANY($1) ORDER BY id`, pq.Array(ids))
+	if err != nil {
+		return out, err
+	}
+	defer itemRows.Close()
+	for itemRows.Next() {
+		var item reserveItemStatus
+		var requestID int64
+		err = itemRows.Scan(&item.ID, &requestID, &item.CatalogKey, &item.Title, &item.Author, &item.IsVideo,
+			&item.Period, &item.Status, &item.Note, &item.UpdatedAt)
+		if err != nil {
+			return out, err
+		}
+		byID[requestID].Items = append(byID[requestID].Items, &item)
+	}
+	return out, itemRows.Err()
+}
+
+// findReserveRequest gets a single reserve request with items and full status history. Nil is returned if not found.
+func (svc *ServiceContext) findReserveRequest(requestID int64) (*reserveRequestStatus, error) {
+	requests, err := svc.findReserveRequests("r.id = $1", requestID)
+	if err != nil {
+		return nil, err
+	}
+	if len(requests) == 0 {
+		return nil, nil
+	}
+	req := requests[0]
+
+	req.History = make([]*reserveStatusEvent, 0)
+	rows, err := svc.DB.Query(`SELECT item_id, from_status, to_status, note, changed_by, created_at
+		FROM reserve_status_events WHERE request_id = $1 ORDER BY created_at, id`, requestID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var evt reserveStatusEvent
+		var itemID sql.NullInt64
+		err = rows.Scan(&itemID, &evt.From, &evt.To, &evt.Note, &evt.ChangedBy, &evt.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if itemID.Valid {
+			evt.ItemID = &itemID.Int64
+		}
+		req.History = append(req.History, &evt)
+	}
+	return req, rows.Err()
+}
+
+// setReserveRequestStatus moves a request to a new status and records the change. Items that
+// can legally make the same move are moved along with it.
+func (svc *ServiceContext) setReserveRequestStatus(requestID int64, update statusUpdateRequest, changedBy string) *RequestError {
+	tx, err := svc.DB.Begin()
+	if err != nil {
+		return &RequestError{StatusCode: http.StatusInternalServerError, Message: err.Error()}
+	}
+	defer tx.Rollback()
+
+	var currStatus string
+	err = tx.QueryRow(`SELECT status FROM reserve_requests WHERE id = $1 FOR UPDATE`, requestID).Scan(&currStatus)
+	if err == sql.ErrNoRows {
+		return &RequestError{StatusCode: http.StatusNotFound, Message: fmt.Sprintf("reserve request %d not found", requestID)}
+	} else if err != nil {
+		return &RequestError{StatusCode: http.StatusInternalServerError, Message: err.Error()}
+	}
+	if canTransition(currStatus, update.Status) == false {
+		return &RequestError{StatusCode: http.StatusConflict,
+			Message: fmt.Sprintf("reserve request %d cannot change from %s to %s", requestID, currStatus, update.Status)}
+	}
+
+	_, err = tx.Exec(`UPDATE reserve_requests SET status = $1, updated_at = NOW() WHERE id = $2`, update.Status, requestID)
+	if err != nil {
+		return &RequestError{StatusCode: http.StatusInternalServerError, Message: err.Error()}
+	}
+	_, err = tx.Exec(`INSERT INTO reserve_status_events (request_id, from_status, to_status, note, changed_by)
+		VALUES ($1, $2, $3, $4, $5)`, requestID, currStatus, update.Status, update.Note, changedBy)
+	if err != nil {
+		return &RequestError{StatusCode: http.StatusInternalServerError, Message: err.Error()}
+	}
+
+	// cascade to items
+	type itemState struct {
+		ID     int64
+		Status string
+	}
+	items := make([]itemState, 0)
+	rows, err := tx.Query(`SELECT id, status FROM reserve_request_items WHERE request_id = $1 FOR UPDATE`, requestID)
+	if err != nil {
+		return &RequestError{StatusCode: http.StatusInternalServerError, Message: err.Error()}
+	}
+	for rows.Next() {
+		var item itemState
+		if err = rows.Scan(&item.ID, &item.Status); err != nil {
+			rows.Close()
+			return &RequestError{StatusCode: http.StatusInternalServerError, Message: err.Error()}
+		}
+		items = append(items, item)
+	}
+	rows.Close()
+
+	for _, item := range items {
+		if canTransition(item.Status, update.Status) == false {
+			continue
+		}
+		if reqErr := updateItemStatus(tx, requestID, item.ID, item.Status, update, changedBy); reqErr != nil {
+			return reqErr
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return &RequestError{StatusCode: http.StatusInternalServerError, Message: err.Error()}
+	}
+	return nil
+}
+
+// setReserveItemStatus moves one item of a request to a new status and records the change
+func (svc *ServiceContext) setReserveItemStatus(requestID int64, itemID int64, update statusUpdateRequest, changedBy string) *RequestError {
+	tx, err := svc.DB.Begin()
+	if err != nil {
+		return &RequestError{StatusCode: http.StatusInternalServerError, Message: err.Error()}
+	}
+	defer tx.Rollback()
+
+	var currStatus string
+	err = tx.QueryRow(`SELECT status FROM reserve_request_items WHERE id = $1 AND request_id = $2 FOR UPDATE`,
+		itemID, requestID).Scan(&currStatus)
+	if err == sql.ErrNoRows {
+		return &RequestError{StatusCode: http.StatusNotFound, Message: fmt.Sprintf("reserve request %d item %d not found", requestID, itemID)}
+	} else if err != nil {
+		return &RequestError{StatusCode: http.StatusInternalServerError, Message: err.Error()}
+	}
+	if canTransition(currStatus, update.Status) == false {
+		return &RequestError{StatusCode: http.StatusConflict,
+			Message: fmt.Sprintf("reserve request %d item %d cannot change from %s to %s", requestID, itemID, currStatus, update.Status)}
+	}
+	if reqErr := updateItemStatus(tx, requestID, itemID, currStatus, update, changedBy); reqErr != nil {
+		return reqErr
+	}
+
+	if err = tx.Commit(); err != nil {
+		return &RequestError{StatusCode: http.StatusInternalServerError, Message: err.Error()}
+	}
+	return nil
+}
+
+func updateItemStatus(tx *sql.Tx, requestID int64, itemID int64, currStatus string, update statusUpdateRequest, changedBy string) *RequestError {
+	_, err := tx.Exec(`UPDATE reserve_request_items SET status = $1, status_note = $2, updated_at = NOW() WHERE id = $3`,
+		update.Status, update.Note, itemID)
+	if err != nil {
+		return &RequestError{StatusCode: http.StatusInternalServerError, Message: err.Error()}
+	}
+	_, err = tx.Exec(`INSERT INTO reserve_status_events (request_id, item_id, from_status, to_status, note, changed_by)
+		VALUES ($1, $2, $3, $4, $5, $6)`, requestID, itemID, currStatus, update.Status, update.Note, changedBy)
+	if err != nil {
+		return &RequestError{StatusCode: http.StatusInternalServerError, Message: err.Error()}
+	}
+	return nil
+}
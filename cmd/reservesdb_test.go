@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+// testReceipt renders a single email that references the saved request
+func testReceipt(ctx context.Context, req *reserveRequest) ([]*emailRequest, error) {
+	return []*emailRequest{{Subject: fmt.Sprintf("Course reserve request %d received", req.RequestID),
+		From: "virgo4@virginia.edu", To: []string{req.Request.Email}, Body: "text", HTMLBody: "<p>html</p>"}}, nil
+}
+
+// saveTestRequest saves a request for the items and returns its ID
+func saveTestRequest(t *testing.T, svc *ServiceContext, params requestParams, items ...requestItem) int64 {
+	t.Helper()
+	requestID, err := svc.saveReserveRequest(context.Background(), testReserveRequest(params, items...), testReceipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return requestID
+}
+
+func TestSaveReserveRequest(t *testing.T) {
+	svc := &ServiceContext{DB: newTestDB(t)}
+	req := testReserveRequest(testReserveParams(), testBook(), testVideo())
+	requestID, err := svc.saveReserveRequest(context.Background(), req, testReceipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requestID == 0 || req.RequestID != requestID {
+		t.Fatalf("request ID = %d, saved request has %d; want the new ID on both", requestID, req.RequestID)
+	}
+
+	var userID, course, semester, status string
+	err = svc.DB.QueryRow(`SELECT user_id, course, semester, status FROM reserve_requests WHERE id = $1`, requestID).
+		Scan(&userID, &course, &semester, &status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != "mst3k" || course != "ENGL 1010" || semester != "Fall 2026" || status != reserveSubmitted {
+		t.Errorf("request = %s, %s, %s, %s; want the submitted request for mst3k", userID, course, semester, status)
+	}
+
+	rows, err := svc.DB.Query(`SELECT catalog_key, is_video, call_number, availability FROM reserve_request_items
+		WHERE request_id = $1 ORDER BY id`, requestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	keys := make([]string, 0)
+	for rows.Next() {
+		var key string
+		var isVideo bool
+		var callNumbers []string
+		var availJSON []byte
+		if err := rows.Scan(&key, &isVideo, pq.Array(&callNumbers), &availJSON); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+		var avail []availabilityInfo
+		if err := json.Unmarshal(availJSON, &avail); err != nil {
+			t.Fatal(err)
+		}
+		if isVideo && (len(avail) != 1 || len(callNumbers) != 0) {
+			t.Errorf("video availability = %v, call numbers = %v; want one library and no call numbers", avail, callNumbers)
+		}
+		if isVideo == false && (len(avail) != 2 || avail[1].Library != "Alderman" || len(callNumbers) != 1) {
+			t.Errorf("book availability = %v, call numbers = %v; want both libraries and the call number", avail, callNumbers)
+		}
+	}
+	if strings.Join(keys, ",") != "u3523432,u6543210" {
+		t.Errorf("items = %v; want the book and the video", keys)
+	}
+
+	var events, emails int
+	svc.DB.QueryRow(`SELECT COUNT(*) FROM reserve_status_events WHERE request_id = $1 AND to_status = $2`,
+		requestID, reserveSubmitted).Scan(&events)
+	if events != 1 {
+		t.Errorf("status events = %d; want the submitted event", events)
+	}
+	var subject string
+	svc.DB.QueryRow(`SELECT COUNT(*), MAX(subject) FROM email_outbox WHERE request_id = $1 AND status = 'pending'`,
+		requestID).Scan(&emails, &subject)
+	if emails != 1 || subject != fmt.Sprintf("Course reserve request %d received", requestID) {
+		t.Errorf("outbox has %d email(s) with subject %q; want the receipt with the request ID", emails, subject)
+	}
+}
+
+func TestSaveReserveRequestRollback(t *testing.T) {
+	svc := &ServiceContext{DB: newTestDB(t)}
+	renderErr := func(ctx context.Context, req *reserveRequest) ([]*emailRequest, error) {
+		return nil, fmt.Errorf("template failed")
+	}
+	_, err := svc.saveReserveRequest(context.Background(), testReserveRequest(testReserveParams(), testBook()), renderErr)
+	if err == nil || err.Error() != "template failed" {
+		t.Fatalf("err = %v; want the render error", err)
+	}
+	for _, table := range []string{"reserve_requests", "reserve_request_items", "reserve_status_events", "email_outbox"} {
+		var count int
+		svc.DB.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s`, table)).Scan(&count)
+		if count != 0 {
+			t.Errorf("%s has %d rows; want nothing saved when the emails can't be rendered", table, count)
+		}
+	}
+}
+
+func TestFindPriorReserveItems(t *testing.T) {
+	svc := &ServiceContext{DB: newTestDB(t)}
+	onBehalf := testReserveParams()
+	onBehalf.OnBehalfOf = "yes"
+	onBehalf.Name = "Pat Assistant"
+	onBehalf.Email = "pa1x@virginia.edu"
+	onBehalf.InstructorName = "Mary Smith"
+	onBehalf.InstructorEmail = "mst3k@virginia.edu"
+	saveTestRequest(t, svc, onBehalf, testBook(), testVideo())
+
+	other := testBook()
+	other.CatalogKey = "u1111111"
+	nextSemester := testReserveParams()
+	nextSemester.Semester = "Spring 2027"
+	otherCourse := testReserveParams()
+	otherCourse.Course = "ENGL 2020"
+	otherInstructor := testReserveParams()
+	otherInstructor.Email = "ab1c@virginia.edu"
+
+	for _, test := range []struct {
+		name   string
+		params requestParams
+		want   string
+	}{
+		{"instructor requesting for themselves", testReserveParams(), "u3523432,u6543210"},
+		{"assistant for the instructor", onBehalf, "u3523432,u6543210"},
+		{"next semester", nextSemester, ""},
+		{"other course", otherCourse, ""},
+		{"other instructor", otherInstructor, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := testReserveRequest(test.params, testBook(), testVideo(), other)
+			prior, err := svc.findPriorReserveItems(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(prior)
+			if strings.Join(prior, ",") != test.want {
+				t.Errorf("prior items = %v; want %q", prior, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Course reserve request lifecycle. Requests and each of their items move through these states
+const (
+	reserveSubmitted = "submitted"
+	reserveInReview  = "in_review"
+	reservePulled    = "pulled"
+	reserveOnReserve = "on_reserve"
+	reserveRejected  = "rejected"
+	reserveExpired   = "expired"
+)
+
+// reserveTransitions lists the states that can be reached from each state. Rejected and expired are final.
+var reserveTransitions = map[string][]string{
+	reserveSubmitted: {reserveInReview, reserveRejected, reserveExpired},
+	reserveInReview:  {reservePulled, reserveRejected, reserveExpired},
+	reservePulled:    {reserveOnReserve, reserveRejected, reserveExpired},
+	reserveOnReserve: {reserveExpired},
+	reserveRejected:  {},
+	reserveExpired:   {},
+}
+
+// canTransition checks if a request or item can move from one status to another
+func canTransition(from string, to string) bool {
+	for _, next := range reserveTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+func isValidReserveStatus(status string) bool {
+	_, found := reserveTransitions[status]
+	return found
+}
+
+type reserveItemStatus struct {
+	ID         int64     `json:"id"`
+	CatalogKey string    `json:"catalogKey"`
+	Title      string    `json:"title"`
+	Author     string    `json:"author"`
+	IsVideo    bool      `json:"isVideo"`
+	Period     string    `json:"period"`
+	Status     string    `json:"status"`
+	Note       string    `json:"note"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+type reserveStatusEvent struct {
+	ItemID    *int64    `json:"itemID,omitempty"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Note      string    `json:"note"`
+	ChangedBy string    `json:"changedBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type reserveRequestStatus struct {
+	ID              int64                 `json:"id"`
+	UserID          string                `json:"userID"`
+	Name            string                `json:"name"`
+	InstructorName  string                `json:"instructorName"`
+	InstructorEmail string                `json:"instructorEmail"`
+	Course          string                `json:"course"`
+	Semester        string                `json:"semester"`
+	Library         string                `json:"library"`
+	Status          string                `json:"status"`
+	CreatedAt       time.Time             `json:"createdAt"`
+	UpdatedAt       time.Time             `json:"updatedAt"`
+	Items           []*reserveItemStatus  `json:"items"`
+	History         []*reserveStatusEvent `json:"history,omitempty"`
+}
+
+type statusUpdateRequest struct {
+	Status string `json:"status"`
+	Note   string `json:"note"`
+}
+
+// getUserReserveRequests returns all reserve requests submitted by the signed in user
+func (svc *ServiceContext) getUserReserveRequests(c *gin.Context) {
+	claims, err := getJWTClaims(c)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "get reserve requests without claims", "error", err.Error())
+		c.String(http.StatusForbidden, "not authorized")
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "get reserve requests for user")
+	requests, err := svc.findReserveRequests("r.user_id = $1", claims.UserID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "unable to get reserve requests for user", "error", err.Error())
+		c.String(http.StatusInternalServerError, "unable to get reserve requests")
+		return
+	}
+	c.JSON(http.StatusOK, requests)
+}
+
+// getStaffReserveRequests returns all reserve requests with the status in the status query param (default submitted)
+func (svc *ServiceContext) getStaffReserveRequests(c *gin.Context) {
+	status := c.DefaultQuery("status", reserveSubmitted)
+	if isValidReserveStatus(status) == false {
+		c.String(http.StatusBadRequest, fmt.Sprintf("%s is not a valid status", status))
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "get reserve requests", "status", status)
+	requests, err := svc.findReserveRequests("r.status = $1", status)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "unable to get reserve requests", "status", status, "error", err.Error())
+		c.String(http.StatusInternalServerError, "unable to get reserve requests")
+		return
+	}
+	c.JSON(http.StatusOK, requests)
+}
+
+// getReserveRequest returns details and status history of a reserve request. Only the
+// requester or staff can see a request
+func (svc *ServiceContext) getReserveRequest(c *gin.Context) {
+	claims, err := getJWTClaims(c)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "get reserve request without claims", "error", err.Error())
+		c.String(http.StatusForbidden, "not authorized")
+		return
+	}
+	requestID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("%s is not a valid request id", c.Param("id")))
+		return
+	}
+
+	req, err := svc.findReserveRequest(requestID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "unable to get reserve request", "reserve_request_id", requestID, "error", err.Error())
+		c.String(http.StatusInternalServerError, "unable to get reserve request")
+		return
+	}
+	if req == nil || (req.UserID != claims.UserID && isStaff(claims) == false) {
+		c.String(http.StatusNotFound, fmt.Sprintf("reserve request %d not found", requestID))
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
+
+// updateReserveRequestStatus moves a reserve request to a new status. Items that can make the same move go with it.
+func (svc *ServiceContext) updateReserveRequestStatus(c *gin.Context) {
+	claims, _ := getJWTClaims(c)
+	requestID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("%s is not a valid request id", c.Param("id")))
+		return
+	}
+	var update statusUpdateRequest
+	if err := c.ShouldBindJSON(&update); err != nil {
+		slog.ErrorContext(c.Request.Context(), "unable to parse status update", "error", err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if isValidReserveStatus(update.Status) == false {
+		c.String(http.StatusBadRequest, fmt.Sprintf("%s is not a valid status", update.Status))
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "change reserve request status", "reserve_request_id", requestID, "status", update.Status)
+	reqErr := svc.setReserveRequestStatus(requestID, update, claims.UserID)
+	if reqErr != nil {
+		slog.ErrorContext(c.Request.Context(), "unable to update reserve request status", "reserve_request_id", requestID, "error", reqErr.Message)
+		c.String(reqErr.StatusCode, reqErr.Message)
+		return
+	}
+
+	req, err := svc.findReserveRequest(requestID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "unable to get reserve request", "reserve_request_id", requestID, "error", err.Error())
+		c.String(http.StatusInternalServerError, "unable to get reserve request")
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
+
+// updateReserveItemStatus moves a single item of a reserve request to a new status
+func (svc *ServiceContext) updateReserveItemStatus(c *gin.Context) {
+	claims, _ := getJWTClaims(c)
+	requestID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("%s is not a valid request id", c.Param("id")))
+		return
+	}
+	itemID, err := strconv.ParseInt(c.Param("item"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("%s is not a valid item id", c.Param("item")))
+		return
+	}
+	var update statusUpdateRequest
+	if err := c.ShouldBindJSON(&update); err != nil {
+		slog.ErrorContext(c.Request.Context(), "unable to parse status update", "error", err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if isValidReserveStatus(update.Status) == false {
+		c.String(http.StatusBadRequest, fmt.Sprintf("%s is not a valid status", update.Status))
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "change reserve item status", "reserve_request_id", requestID, "item_id", itemID, "status", update.Status)
+	reqErr := svc.setReserveItemStatus(requestID, itemID, update, claims.UserID)
+	if reqErr != nil {
+		slog.ErrorContext(c.Request.Context(), "unable to update reserve item status", "reserve_request_id", requestID, "item_id", itemID, "error", reqErr.Message)
+		c.String(reqErr.StatusCode, reqErr.Message)
+		return
+	}
+
+	req, err := svc.findReserveRequest(requestID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "unable to get reserve request", "reserve_request_id", requestID, "error", err.Error())
+		c.String(http.StatusInternalServerError, "unable to get reserve request")
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
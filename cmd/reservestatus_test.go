@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		want bool
+	}{
+		{reserveSubmitted, reserveInReview, true},
+		{reserveSubmitted, reserveRejected, true},
+		{reserveSubmitted, reserveExpired, true},
+		{reserveSubmitted, reservePulled, false},
+		{reserveSubmitted, reserveOnReserve, false},
+		{reserveSubmitted, reserveSubmitted, false},
+		{reserveInReview, reservePulled, true},
+		{reserveInReview, reserveSubmitted, false},
+		{reserveInReview, reserveOnReserve, false},
+		{reservePulled, reserveOnReserve, true},
+		{reservePulled, reserveRejected, true},
+		{reservePulled, reserveInReview, false},
+		{reserveOnReserve, reserveExpired, true},
+		{reserveOnReserve, reserveRejected, false},
+		{reserveRejected, reserveInReview, false},
+		{reserveRejected, reserveExpired, false},
+		{reserveExpired, reserveOnReserve, false},
+		{"unknown", reserveInReview, false},
+		{reserveSubmitted, "unknown", false},
+	}
+	for _, test := range tests {
+		if got := canTransition(test.from, test.to); got != test.want {
+			t.Errorf("canTransition(%s, %s) = %t; want %t", test.from, test.to, got, test.want)
+		}
+	}
+
+	// every state that can be reached is a known state, and the final states can't be left
+	for from, next := range reserveTransitions {
+		for _, to := range next {
+			if isValidReserveStatus(to) == false {
+				t.Errorf("%s can move to unknown status %s", from, to)
+			}
+		}
+	}
+	for _, final := range []string{reserveRejected, reserveExpired} {
+		if len(reserveTransitions[final]) != 0 {
+			t.Errorf("%s is final; want no transitions from it", final)
+		}
+	}
+}
+
+// reserveItemStates is the status of each item of a saved request, by catalog key
+func reserveItemStates(t *testing.T, svc *ServiceContext, requestID int64) map[string]*reserveItemStatus {
+	t.Helper()
+	req, err := svc.findReserveRequest(requestID)
+	if err != nil || req == nil {
+		t.Fatalf("find request %d = %+v, %v", requestID, req, err)
+	}
+	out := make(map[string]*reserveItemStatus)
+	for _, item := range req.Items {
+		out[item.CatalogKey] = item
+	}
+	return out
+}
+
+func TestReserveStatusCascade(t *testing.T) {
+	svc := &ServiceContext{DB: newTestDB(t)}
+	requestID := saveTestRequest(t, svc, testReserveParams(), testBook(), testVideo())
+	items := reserveItemStates(t, svc, requestID)
+	book, video := items["u3523432"], items["u6543210"]
+
+	// the video is rejected on its own; the request and the book are not touched
+	if reqErr := svc.setReserveItemStatus(requestID, video.ID, statusUpdateRequest{Status: reserveRejected, Note: "no streaming rights"}, "staff1"); reqErr != nil {
+		t.Fatal(reqErr.Message)
+	}
+	// a rejected item doesn't move with the request
+	if reqErr := svc.setReserveRequestStatus(requestID, statusUpdateRequest{Status: reserveInReview}, "staff1"); reqErr != nil {
+		t.Fatal(reqErr.Message)
+	}
+	items = reserveItemStates(t, svc, requestID)
+	if items["u3523432"].Status != reserveInReview || items["u6543210"].Status != reserveRejected {
+		t.Errorf("item statuses = %s, %s; want the book in review and the video still rejected",
+			items["u3523432"].Status, items["u6543210"].Status)
+	}
+	if items["u6543210"].Note != "no streaming rights" {
+		t.Errorf("video note = %q; want the rejection note", items["u6543210"].Note)
+	}
+
+	req, _ := svc.findReserveRequest(requestID)
+	if req.Status != reserveInReview {
+		t.Errorf("request status = %s; want %s", req.Status, reserveInReview)
+	}
+	// submitted, the video rejection, the request change and the book moving with it
+	want := []string{"->submitted", "submitted->rejected", "submitted->in_review", "submitted->in_review"}
+	if len(req.History) != len(want) {
+		t.Fatalf("history = %d events; want %d", len(req.History), len(want))
+	}
+	for idx, evt := range req.History {
+		if got := evt.From + "->" + evt.To; got != want[idx] {
+			t.Errorf("event %d = %s; want %s", idx, got, want[idx])
+		}
+	}
+	if req.History[1].ItemID == nil || *req.History[1].ItemID != video.ID || req.History[2].ItemID != nil ||
+		req.History[3].ItemID == nil || *req.History[3].ItemID != book.ID {
+		t.Errorf("history item IDs are wrong: %+v", req.History)
+	}
+
+	for _, test := range []struct {
+		name   string
+		err    *RequestError
+		status int
+	}{
+		{"request can't go back", svc.setReserveRequestStatus(requestID, statusUpdateRequest{Status: reserveSubmitted}, "staff1"), http.StatusConflict},
+		{"rejected item is final", svc.setReserveItemStatus(requestID, video.ID, statusUpdateRequest{Status: reservePulled}, "staff1"), http.StatusConflict},
+		{"unknown request", svc.setReserveRequestStatus(requestID+1, statusUpdateRequest{Status: reserveInReview}, "staff1"), http.StatusNotFound},
+		{"item of another request", svc.setReserveItemStatus(requestID+1, book.ID, statusUpdateRequest{Status: reservePulled}, "staff1"), http.StatusNotFound},
+	} {
+		if test.err == nil || test.err.StatusCode != test.status {
+			t.Errorf("%s: err = %+v; want %d", test.name, test.err, test.status)
+		}
+	}
+}
+
+func TestGetReserveRequestVisibility(t *testing.T) {
+	svc := &ServiceContext{DB: newTestDB(t)}
+	requestID := saveTestRequest(t, svc, testReserveParams(), testBook())
+
+	for _, test := range []struct {
+		name   string
+		path   string
+		claims *v4jwt.V4Claims
+		status int
+	}{
+		{"requester", "/reserves/%d", &v4jwt.V4Claims{UserID: "mst3k"}, http.StatusOK},
+		{"staff", "/reserves/%d", &v4jwt.V4Claims{UserID: "staff1", Role: v4jwt.Staff}, http.StatusOK},
+		{"admin", "/reserves/%d", &v4jwt.V4Claims{UserID: "admin1", Role: v4jwt.Admin}, http.StatusOK},
+		{"another user", "/reserves/%d", &v4jwt.V4Claims{UserID: "ab1c"}, http.StatusNotFound},
+		{"not signed in", "/reserves/%d", nil, http.StatusForbidden},
+		{"unknown request", "/reserves/%d0", &v4jwt.V4Claims{UserID: "staff1", Role: v4jwt.Staff}, http.StatusNotFound},
+		{"invalid id", "/reserves/%d-one", &v4jwt.V4Claims{UserID: "mst3k"}, http.StatusBadRequest},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/reserves/:id", func(c *gin.Context) {
+				if test.claims != nil {
+					c.Set("claims", test.claims)
+				}
+			}, svc.getReserveRequest)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf(test.path, requestID), nil))
+			if w.Code != test.status {
+				t.Fatalf("status = %d; want %d", w.Code, test.status)
+			}
+			if test.status != http.StatusOK {
+				return
+			}
+			var req reserveRequestStatus
+			if err := json.Unmarshal(w.Body.Bytes(), &req); err != nil {
+				t.Fatal(err)
+			}
+			if req.ID != requestID || len(req.Items) != 1 || len(req.History) != 1 {
+				t.Errorf("request = %+v; want request %d with its item and history", req, requestID)
+			}
+		})
+	}
+}
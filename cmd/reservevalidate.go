@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// values for yes / no request fields
+var yesNo = map[string]bool{"yes": true, "no": true}
+
+// semesterRegex is the accepted format of a reserve semester, like Fall 2025 or J-Term 2026
+var semesterRegex = regexp.MustCompile(`(?i)^(spring|summer|fall|winter|j-term)\s+20\d{2}$`)
+
+// maxFieldLength is the size of the column a field is saved in; see db/migrations/000001
+type maxFieldLength struct {
+	Field string
+	Value string
+	Max   int
+}
+
+// validateReserveRequest checks a course reserve request before any work is done for it and returns a
+// problem for each invalid field. String values are trimmed and title IDs normalized as they are checked.
+func (svc *ServiceContext) validateReserveRequest(req *reserveRequest) []fieldError {
+	errs := make(fieldErrors, 0)
+	params := &req.Request
+	for _, value := range []*string{&params.OnBehalfOf, &params.InstructorName, &params.InstructorEmail,
+		&params.Name, &params.Email, &params.Course, &params.Semester, &params.Library, &params.Period,
+		&params.LMS, &params.OtherLMS} {
+		*value = strings.TrimSpace(*value)
+	}
+
+	if params.Name == "" {
+		errs.add("request.name", "name is required")
+	}
+	checkEmail(&errs, "request.email", params.Email)
+	if params.OnBehalfOf != "" && yesNo[params.OnBehalfOf] == false {
+		errs.add("request.onBehalfOf", "onBehalfOf must be yes or no")
+	}
+	if params.OnBehalfOf == "yes" {
+		if params.InstructorName == "" {
+			errs.add("request.instructorName", "instructor name is required for a request on behalf of an instructor")
+		}
+		checkEmail(&errs, "request.instructorEmail", params.InstructorEmail)
+	} else if params.InstructorEmail != "" {
+		checkEmail(&errs, "request.instructorEmail", params.InstructorEmail)
+	}
+	if params.Course == "" {
+		errs.add("request.course", "course is required")
+	}
+	if params.Semester == "" {
+		errs.add("request.semester", "semester is required")
+	} else if semesterRegex.MatchString(params.Semester) == false {
+		errs.add("request.semester", "semester must be a term and year, like Fall 2025")
+	}
+	if params.Library == "" {
+		errs.add("request.library", "library is required")
+	} else if svc.ReserveRoutes.isLibrary(params.Library) == false {
+		errs.add("request.library", fmt.Sprintf("library must be one of %s", strings.Join(svc.ReserveRoutes.Libraries, ", ")))
+	}
+	if params.Period != "" && svc.ReserveRoutes.isLoanPeriod(params.Period) == false {
+		errs.add("request.period", loanPeriodMessage(svc.ReserveRoutes))
+	}
+	if strings.EqualFold(params.LMS, "other") && params.OtherLMS == "" {
+		errs.add("request.otherLMS", "otherLMS is required when lms is Other")
+	}
+	checkLengths(&errs, []maxFieldLength{
+		{"request.name", params.Name, 255}, {"request.email", params.Email, 255},
+		{"request.instructorName", params.InstructorName, 255}, {"request.instructorEmail", params.InstructorEmail, 255},
+		{"request.course", params.Course, 255}, {"request.semester", params.Semester, 50},
+		{"request.library", params.Library, 100}, {"request.period", params.Period, 100},
+		{"request.lms", params.LMS, 100}, {"request.otherLMS", params.OtherLMS, 255},
+	})
+
+	if len(req.Items) == 0 {
+		errs.add("items", "at least one item is required")
+	} else if len(req.Items) > svc.ReserveItemLimit {
+		errs.add("items", fmt.Sprintf("a request can have at most %d items", svc.ReserveItemLimit))
+	}
+	hasVideo := false
+	for idx := range req.Items {
+		item := &req.Items[idx]
+		field := fmt.Sprintf("items[%d]", idx)
+		for _, value := range []*string{&item.Period, &item.AudioLanguage, &item.Subtitles, &item.SubtitleLanguage} {
+			*value = strings.TrimSpace(*value)
+		}
+		titleID, err := validateTitleID(item.CatalogKey)
+		if err != nil {
+			errs.add(field+".catalogKey", err.Error())
+		} else {
+			item.CatalogKey = titleID
+		}
+
+		if item.IsVideo {
+			hasVideo = true
+			if item.AudioLanguage == "" {
+				errs.add(field+".audioLanguage", "audio language is required for a video")
+			}
+			if yesNo[item.Subtitles] == false {
+				errs.add(field+".subtitles", "subtitles must be yes or no for a video")
+			} else if item.Subtitles == "yes" && item.SubtitleLanguage == "" {
+				errs.add(field+".subtitleLanguage", "subtitle language is required when subtitles is yes")
+			}
+		} else if svc.ReserveRoutes.isLoanPeriod(item.Period) == false {
+			errs.add(field+".period", loanPeriodMessage(svc.ReserveRoutes))
+		}
+		checkLengths(&errs, []maxFieldLength{
+			{field + ".pool", item.Pool, 100}, {field + ".catalogKey", item.CatalogKey, 100},
+			{field + ".period", item.Period, 100}, {field + ".audioLanguage", item.AudioLanguage, 100},
+			{field + ".subtitleLanguage", item.SubtitleLanguage, 100},
+		})
+	}
+
+	// video reserves are delivered through the course LMS, so it must be known
+	if hasVideo && params.LMS == "" {
+		errs.add("request.lms", "lms is required for a video reserve")
+	}
+	return errs
+}
+
+// checkEmail adds a problem if an email is missing or is not a bare address
+func checkEmail(errs *fieldErrors, field, email string) {
+	if email == "" {
+		errs.add(field, "email is required")
+		return
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		errs.add(field, fmt.Sprintf("%s is not a valid email address", email))
+	}
+}
+
+// checkLengths adds a problem for each value that is too long to save, unless its field already has one.
+// Lengths are in characters, like the varchar columns.
+func checkLengths(errs *fieldErrors, lengths []maxFieldLength) {
+	invalid := make(map[string]bool)
+	for _, fieldErr := range *errs {
+		invalid[fieldErr.Field] = true
+	}
+	for _, length := range lengths {
+		if invalid[length.Field] || utf8.RuneCountInString(length.Value) <= length.Max {
+			continue
+		}
+		name := length.Field[strings.LastIndex(length.Field, ".")+1:]
+		errs.add(length.Field, fmt.Sprintf("%s can be at most %d characters", name, length.Max))
+	}
+}
+
+func loanPeriodMessage(routes *reserveRoutes) string {
+	if len(routes.LoanPeriods) == 0 {
+		return "loan period is required"
+	}
+	return fmt.Sprintf("loan period must be one of %s", strings.Join(routes.LoanPeriods, ", "))
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// validReserveRequest is a request for one book that passes validation
+func validReserveRequest() *reserveRequest {
+	return &reserveRequest{
+		Request: requestParams{OnBehalfOf: "no", Name: "Mary Smith", Email: "mst3k@virginia.edu", Course: "ENGL 1010",
+			Semester: "Fall 2026", Library: "clemons"},
+		Items: []requestItem{{Pool: "uva_library", CatalogKey: "u3523432", Title: "Moby Dick", Period: "3h"}},
+	}
+}
+
+func validVideo() requestItem {
+	return requestItem{Pool: "video", IsVideo: true, CatalogKey: "u6543210", Title: "Jaws", AudioLanguage: "English", Subtitles: "no"}
+}
+
+func TestValidateReserveRequest(t *testing.T) {
+	listed := &ServiceContext{ReserveItemLimit: 2,
+		ReserveRoutes: &reserveRoutes{Libraries: []string{"law", "clemons"}, LoanPeriods: []string{"3h", "2d"}}}
+	unlisted := &ServiceContext{ReserveItemLimit: 2, ReserveRoutes: &reserveRoutes{}}
+
+	tests := []struct {
+		name   string
+		svc    *ServiceContext
+		change func(req *reserveRequest)
+		fields []string
+	}{
+		{"valid", listed, func(req *reserveRequest) {}, nil},
+		{"valid with a video", listed, func(req *reserveRequest) {
+			req.Request.LMS = "Canvas"
+			req.Items = append(req.Items, validVideo())
+		}, nil},
+		{"values are trimmed", listed, func(req *reserveRequest) {
+			req.Request.Email = " mst3k@virginia.edu "
+			req.Request.Library = "clemons\t"
+			req.Items[0].Period = " 3h"
+		}, nil},
+		{"missing request fields", listed, func(req *reserveRequest) {
+			req.Request = requestParams{}
+		}, []string{"request.name", "request.email", "request.course", "request.semester", "request.library"}},
+		{"email with a display name", listed, func(req *reserveRequest) {
+			req.Request.Email = "Mary Smith <mst3k@virginia.edu>"
+		}, []string{"request.email"}},
+		{"invalid on behalf of", listed, func(req *reserveRequest) {
+			req.Request.OnBehalfOf = "maybe"
+		}, []string{"request.onBehalfOf"}},
+		{"on behalf of without an instructor", listed, func(req *reserveRequest) {
+			req.Request.OnBehalfOf = "yes"
+		}, []string{"request.instructorName", "request.instructorEmail"}},
+		{"invalid instructor email", listed, func(req *reserveRequest) {
+			req.Request.InstructorEmail = "mst3k"
+		}, []string{"request.instructorEmail"}},
+		{"j-term semester", listed, func(req *reserveRequest) {
+			req.Request.Semester = "j-term 2027"
+		}, nil},
+		{"semester without a term", listed, func(req *reserveRequest) {
+			req.Request.Semester = "2026 Summer Session II"
+		}, []string{"request.semester"}},
+		{"multi-line semester", listed, func(req *reserveRequest) {
+			req.Request.Semester = "Fall 2026\nBcc: everyone@virginia.edu"
+		}, []string{"request.semester"}},
+		{"unlisted library", listed, func(req *reserveRequest) {
+			req.Request.Library = "music"
+		}, []string{"request.library"}},
+		{"any library when none are listed", unlisted, func(req *reserveRequest) {
+			req.Request.Library = "music"
+		}, nil},
+		{"unlisted loan periods", listed, func(req *reserveRequest) {
+			req.Request.Period = "1y"
+			req.Items[0].Period = "4h"
+		}, []string{"request.period", "items[0].period"}},
+		{"any loan period when none are listed", unlisted, func(req *reserveRequest) {
+			req.Items[0].Period = "4h"
+		}, nil},
+		{"book without a loan period", unlisted, func(req *reserveRequest) {
+			req.Items[0].Period = ""
+		}, []string{"items[0].period"}},
+		{"request values too long", listed, func(req *reserveRequest) {
+			req.Request.Name = strings.Repeat("a", 256)
+			req.Request.Course = strings.Repeat("é", 256)
+			req.Request.OtherLMS = strings.Repeat("a", 256)
+			req.Request.LMS = "Other"
+		}, []string{"request.name", "request.course", "request.otherLMS"}},
+		{"values at the column size", listed, func(req *reserveRequest) {
+			req.Request.Name = strings.Repeat("a", 255)
+			req.Request.Course = strings.Repeat("é", 255)
+			req.Items[0].Pool = strings.Repeat("a", 100)
+		}, nil},
+		{"item values too long", listed, func(req *reserveRequest) {
+			req.Request.LMS = "Canvas"
+			video := validVideo()
+			video.Pool = strings.Repeat("a", 101)
+			video.AudioLanguage = strings.Repeat("a", 101)
+			video.Subtitles = "yes"
+			video.SubtitleLanguage = strings.Repeat("a", 101)
+			req.Items = append(req.Items, video)
+		}, []string{"items[1].pool", "items[1].audioLanguage", "items[1].subtitleLanguage"}},
+		{"too long library is only reported once", listed, func(req *reserveRequest) {
+			req.Request.Library = strings.Repeat("a", 101)
+		}, []string{"request.library"}},
+		{"other lms without a name", listed, func(req *reserveRequest) {
+			req.Request.LMS = "Other"
+		}, []string{"request.otherLMS"}},
+		{"no items", listed, func(req *reserveRequest) {
+			req.Items = nil
+		}, []string{"items"}},
+		{"too many items", listed, func(req *reserveRequest) {
+			req.Items = append(req.Items, req.Items[0], req.Items[0])
+		}, []string{"items"}},
+		{"invalid catalog key", listed, func(req *reserveRequest) {
+			req.Items[0].CatalogKey = "u1\x00"
+		}, []string{"items[0].catalogKey"}},
+		{"video without its details", listed, func(req *reserveRequest) {
+			video := validVideo()
+			video.AudioLanguage = ""
+			video.Subtitles = "sometimes"
+			req.Items = append(req.Items, video)
+		}, []string{"items[1].audioLanguage", "items[1].subtitles", "request.lms"}},
+		{"video subtitles without a language", listed, func(req *reserveRequest) {
+			req.Request.LMS = "Canvas"
+			video := validVideo()
+			video.Subtitles = "yes"
+			req.Items = append(req.Items, video)
+		}, []string{"items[1].subtitleLanguage"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := validReserveRequest()
+			test.change(req)
+			errs := test.svc.validateReserveRequest(req)
+			fields := make([]string, 0)
+			for _, fieldErr := range errs {
+				if fieldErr.Message == "" {
+					t.Errorf("%s has no message", fieldErr.Field)
+				}
+				fields = append(fields, fieldErr.Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(test.fields, ",") {
+				t.Errorf("invalid fields = %v; want %v (%+v)", fields, test.fields, errs)
+			}
+		})
+	}
+}
+
+func TestValidateReserveRequestNormalizes(t *testing.T) {
+	svc := &ServiceContext{ReserveItemLimit: 2, ReserveRoutes: &reserveRoutes{}}
+	req := validReserveRequest()
+	req.Request.Course = "  ENGL 1010 "
+	req.Items[0].CatalogKey = " u3523432\t"
+	if errs := svc.validateReserveRequest(req); len(errs) > 0 {
+		t.Fatalf("errors = %+v; want none", errs)
+	}
+	if req.Request.Course != "ENGL 1010" || req.Items[0].CatalogKey != "u3523432" {
+		t.Errorf("course = %q, catalog key = %q; want them trimmed", req.Request.Course, req.Items[0].CatalogKey)
+	}
+}
+
+func TestValidateReserveRequestShippedLists(t *testing.T) {
+	useRepoTemplates(t)
+	cfg := ServiceConfig{CourseReserveEmail: testRouteDesks["cremail"], LawReserveEmail: testRouteDesks["lawemail"]}
+	routes, err := loadReserveRoutes("../data/reserve_routes.yaml", &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := &ServiceContext{ReserveItemLimit: 2, ReserveRoutes: routes}
+
+	tests := []struct {
+		name   string
+		change func(req *reserveRequest)
+		fields []string
+	}{
+		{"client library and loan period", func(req *reserveRequest) {
+			req.Request.Library = "health-sciences"
+			req.Items[0].Period = "24h"
+		}, nil},
+		{"unknown library", func(req *reserveRequest) {
+			req.Request.Library = "alderman"
+		}, []string{"request.library"}},
+		{"unknown loan period", func(req *reserveRequest) {
+			req.Items[0].Period = "1w"
+		}, []string{"items[0].period"}},
+		{"unknown request loan period", func(req *reserveRequest) {
+			req.Request.Period = "4h"
+		}, []string{"request.period"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := validReserveRequest()
+			test.change(req)
+			fields := make([]string, 0)
+			for _, fieldErr := range svc.validateReserveRequest(req) {
+				fields = append(fields, fieldErr.Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(test.fields, ",") {
+				t.Errorf("invalid fields = %v; want %v", fields, test.fields)
+			}
+		})
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// item types a reserve route can apply to
+const (
+	routeAllItems = "all"
+	routeVideo    = "video"
+	routeNonVideo = "non_video"
+)
+
+// recipient, from and reply to values a reserve route can use in place of an email address
+const (
+	routeDesk       = "desk"
+	routeRequester  = "requester"
+	routeInstructor = "instructor"
+	routePatron     = "patron"
+	routeSender     = "sender"
+)
+
+// routeAnyLibrary in the libraries of a route matches every reserve library
+const routeAnyLibrary = "*"
+
+// default templates for staff reserve emails
+const (
+	defaultReserveTemplate      = "reserves"
+	defaultVideoReserveTemplate = "reserves_video"
+)
+
+// reserveRoutes decides where the staff email for a course reserve request goes, based on the reserve
+// library and the type of the items. Routes are checked in order and the first match is used.
+type reserveRoutes struct {
+	Libraries   []string          `yaml:"libraries"`
+	LoanPeriods []string          `yaml:"loan_periods"`
+	Desks       map[string]string `yaml:"desks"`
+	Routes      []*reserveRoute   `yaml:"routes"`
+}
+
+// reserveRoute is the staff email setup for a set of reserve libraries
+type reserveRoute struct {
+	Name      string   `yaml:"name"`
+	Libraries []string `yaml:"libraries"`
+	Items     string   `yaml:"items"`
+	Desk      string   `yaml:"desk"`
+	To        []string `yaml:"to"`
+	CC        []string `yaml:"cc"`
+	From      string   `yaml:"from"`
+	ReplyTo   string   `yaml:"reply_to"`
+	Template  string   `yaml:"template"`
+	desk      string
+}
+
+// reserveEmailAddrs are the addresses for one staff reserve email
+type reserveEmailAddrs struct {
+	To          []string
+	CC          []string
+	From        string
+	ReplyTo     string
+	SubjectName string
+}
+
+// loadReserveRoutes reads and validates a reserve routing file. The desks named cremail and lawemail
+// are the addresses from the -cremail and -lawemail params; other desks are defined in the file.
+func loadReserveRoutes(routesFile string, cfg *ServiceConfig) (*reserveRoutes, error) {
+	slog.Info("loading reserve routes", "file", routesFile)
+	routesData, err := ioutil.ReadFile(routesFile)
+	if err != nil {
+		return nil, err
+	}
+	var routes reserveRoutes
+	if err := yaml.Unmarshal(routesData, &routes); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %s", routesFile, err.Error())
+	}
+	if routes.Desks == nil {
+		routes.Desks = make(map[string]string)
+	}
+	for name, addr := range map[string]string{"cremail": cfg.CourseReserveEmail, "lawemail": cfg.LawReserveEmail} {
+		if _, found := routes.Desks[name]; found {
+			return nil, fmt.Errorf("invalid routes in %s: desk %s is set by the %s param", routesFile, name, name)
+		}
+		routes.Desks[name] = addr
+	}
+	if err := routes.validate(); err != nil {
+		return nil, fmt.Errorf("invalid routes in %s: %s", routesFile, err.Error())
+	}
+	slog.Info("loaded reserve routes", "routes", len(routes.Routes), "libraries", len(routes.Libraries))
+	return &routes, nil
+}
+
+func (routes *reserveRoutes) validate() error {
+	libraries := make(map[string]bool)
+	for _, lib := range routes.Libraries {
+		libraries[lib] = true
+	}
+	names := make(map[string]bool)
+	for idx, route := range routes.Routes {
+		if route.Name == "" {
+			return fmt.Errorf("route %d has no name", idx+1)
+		}
+		if names[route.Name] {
+			return fmt.Errorf("route %s is defined more than once", route.Name)
+		}
+		names[route.Name] = true
+		if err := route.validate(routes.Desks, libraries); err != nil {
+			return fmt.Errorf("route %s: %s", route.Name, err.Error())
+		}
+	}
+
+	// every library offered by the client must have a route for both kinds of item. Without a list of
+	// libraries any library is accepted, so there must be routes for all libraries.
+	libraryNames := routes.Libraries
+	if len(libraryNames) == 0 {
+		libraryNames = []string{routeAnyLibrary}
+	}
+	for _, lib := range libraryNames {
+		for _, video := range []bool{false, true} {
+			if routes.routeFor(lib, video) == nil {
+				return fmt.Errorf("library %s has no route for %s items", lib, itemType(video))
+			}
+		}
+	}
+	return nil
+}
+
+func (route *reserveRoute) validate(desks map[string]string, libraries map[string]bool) error {
+	if len(route.Libraries) == 0 {
+		return errors.New("no libraries")
+	}
+	for _, lib := range route.Libraries {
+		if lib != routeAnyLibrary && len(libraries) > 0 && libraries[lib] == false {
+			return fmt.Errorf("%s is not in the list of reserve libraries", lib)
+		}
+	}
+	if route.Items == "" {
+		route.Items = routeAllItems
+	}
+	if route.Items != routeAllItems && route.Items != routeVideo && route.Items != routeNonVideo {
+		return fmt.Errorf("items must be %s, %s or %s", routeAllItems, routeVideo, routeNonVideo)
+	}
+	addr, found := desks[route.Desk]
+	if found == false {
+		return fmt.Errorf("%s is not a known desk", route.Desk)
+	}
+	if isEmailAddress(addr) == false {
+		return fmt.Errorf("desk %s has no email address", route.Desk)
+	}
+	route.desk = addr
+	if len(route.To) == 0 {
+		return errors.New("no to recipients")
+	}
+	for _, recipient := range append(route.To, route.CC...) {
+		if recipient != routeDesk && recipient != routeRequester && recipient != routeInstructor && isEmailAddress(recipient) == false {
+			return fmt.Errorf("recipient %s must be %s, %s, %s or an email address", recipient, routeDesk, routeRequester, routeInstructor)
+		}
+	}
+	if route.From == "" {
+		route.From = routeSender
+	}
+	// sending as the patron would forge their address, which SPF and DMARC checks reject
+	if route.From == routePatron || route.From == routeRequester || route.From == routeInstructor {
+		return fmt.Errorf("from %s is not supported; use from: %s and reply_to: %s", route.From, routeSender, route.From)
+	}
+	if route.From != routeSender && route.From != routeDesk {
+		return fmt.Errorf("from must be %s or %s", routeSender, routeDesk)
+	}
+	if route.ReplyTo != "" && route.ReplyTo != routePatron && route.ReplyTo != routeRequester && route.ReplyTo != routeInstructor {
+		return fmt.Errorf("reply_to must be %s, %s or %s", routePatron, routeRequester, routeInstructor)
+	}
+	for _, video := range []bool{false, true} {
+		if route.matchesItems(video) == false {
+			continue
+		}
+		if _, _, err := route.template(video).parse(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isLibrary is true for the reserve libraries offered by the client. If they are not listed, any
+// library is accepted.
+func (routes *reserveRoutes) isLibrary(library string) bool {
+	if len(routes.Libraries) == 0 {
+		return library != ""
+	}
+	for _, lib := range routes.Libraries {
+		if lib == library {
+			return true
+		}
+	}
+	return false
+}
+
+// isLoanPeriod is true for the loan periods offered by the client. If they are not listed, any
+// loan period is accepted.
+func (routes *reserveRoutes) isLoanPeriod(period string) bool {
+	if len(routes.LoanPeriods) == 0 {
+		return period != ""
+	}
+	for _, p := range routes.LoanPeriods {
+		if p == period {
+			return true
+		}
+	}
+	return false
+}
+
+// routeFor is the first route for a library and item type, or nil if there is none
+func (routes *reserveRoutes) routeFor(library string, video bool) *reserveRoute {
+	for _, route := range routes.Routes {
+		if route.matchesItems(video) == false {
+			continue
+		}
+		for _, lib := range route.Libraries {
+			if lib == library || lib == routeAnyLibrary {
+				return route
+			}
+		}
+	}
+	return nil
+}
+
+// templates are all of the staff email templates used by the routes
+func (routes *reserveRoutes) templates() []reserveTemplate {
+	out := make([]reserveTemplate, 0)
+	seen := make(map[string]bool)
+	for _, route := range routes.Routes {
+		for _, video := range []bool{false, true} {
+			tpl := route.template(video)
+			if route.matchesItems(video) && seen[tpl.Name] == false {
+				seen[tpl.Name] = true
+				out = append(out, tpl)
+			}
+		}
+	}
+	return out
+}
+
+func (route *reserveRoute) matchesItems(video bool) bool {
+	return route.Items == routeAllItems || route.Items == itemType(video)
+}
+
+// template is the staff email template for the item type; the route template if it has one
+func (route *reserveRoute) template(video bool) reserveTemplate {
+	if route.Template != "" {
+		return reserveTemplate{Name: route.Template}
+	}
+	if video {
+		return reserveTemplate{Name: defaultVideoReserveTemplate}
+	}
+	return reserveTemplate{Name: defaultReserveTemplate}
+}
+
+// addresses works out the recipients, sender and reply to address of the staff email for a request. When
+// replies go to the instructor, the subject names them. Empty and duplicate addresses are dropped, as are
+// CCs that are already recipients or the sender.
+func (route *reserveRoute) addresses(params *requestParams, sender string) reserveEmailAddrs {
+	addrs := reserveEmailAddrs{From: sender, SubjectName: params.Name}
+	if route.From == routeDesk {
+		addrs.From = route.desk
+	}
+	switch route.ReplyTo {
+	case routeRequester:
+		addrs.ReplyTo = params.Email
+	case routeInstructor:
+		if params.InstructorEmail != "" {
+			addrs.ReplyTo = params.InstructorEmail
+			addrs.SubjectName = params.InstructorName
+		}
+	case routePatron:
+		addrs.ReplyTo = params.Email
+		if params.InstructorEmail != "" {
+			addrs.ReplyTo = params.InstructorEmail
+			addrs.SubjectName = params.InstructorName
+		}
+	}
+
+	seen := map[string]bool{"": true}
+	resolve := func(recipients []string) []string {
+		out := make([]string, 0)
+		for _, recipient := range recipients {
+			addr := recipient
+			switch recipient {
+			case routeDesk:
+				addr = route.desk
+			case routeRequester:
+				addr = params.Email
+			case routeInstructor:
+				addr = params.InstructorEmail
+			}
+			if seen[strings.ToLower(addr)] == false {
+				seen[strings.ToLower(addr)] = true
+				out = append(out, addr)
+			}
+		}
+		return out
+	}
+	addrs.To = resolve(route.To)
+	seen[strings.ToLower(addrs.From)] = true
+	addrs.CC = resolve(route.CC)
+	return addrs
+}
+
+func itemType(video bool) string {
+	if video {
+		return routeVideo
+	}
+	return routeNonVideo
+}
+
+// isEmailAddress is a loose check that a value looks like an email address
+func isEmailAddress(value string) bool {
+	at := strings.Index(value, "@")
+	return at > 0 && at < len(value)-1 && strings.ContainsAny(value, " \t\r\n,;") == false
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+var testRouteDesks = map[string]string{"cremail": "reserves@virginia.edu", "lawemail": "lawreserves@virginia.edu"}
+
+// parseTestRoutes validates a routing file with the -cremail and -lawemail desks
+func parseTestRoutes(t *testing.T, routesYAML string) (*reserveRoutes, error) {
+	t.Helper()
+	useRepoTemplates(t)
+	var routes reserveRoutes
+	if err := yaml.Unmarshal([]byte(routesYAML), &routes); err != nil {
+		t.Fatal(err)
+	}
+	routes.Desks = testRouteDesks
+	return &routes, routes.validate()
+}
+
+func TestDefaultReserveRoutes(t *testing.T) {
+	useRepoTemplates(t)
+	cfg := ServiceConfig{CourseReserveEmail: testRouteDesks["cremail"], LawReserveEmail: testRouteDesks["lawemail"]}
+	routes, err := loadReserveRoutes("../data/reserve_routes.yaml", &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		library string
+		video   bool
+		route   string
+	}{
+		{"law", false, "law"},
+		{"law", true, "law"},
+		{"clemons", false, "course_reserves"},
+		{"music", true, "course_reserves"},
+	} {
+		route := routes.routeFor(test.library, test.video)
+		if route == nil || route.Name != test.route {
+			t.Errorf("routeFor(%s, %t) = %+v; want %s", test.library, test.video, route, test.route)
+		}
+	}
+	for _, library := range []string{"clemons", "law", "music", "fine-arts", "health-sciences", "science"} {
+		if routes.isLibrary(library) == false {
+			t.Errorf("library %s is not accepted", library)
+		}
+	}
+	for _, library := range []string{"", "alderman", "Law", "*"} {
+		if routes.isLibrary(library) {
+			t.Errorf("library %q is accepted", library)
+		}
+	}
+}
+
+func TestReserveRouteAddresses(t *testing.T) {
+	routes, err := parseTestRoutes(t, `
+loan_periods: [3h]
+routes:
+  - name: law
+    libraries: [law]
+    desk: lawemail
+    to: [desk, requester, instructor]
+  - name: course_reserves
+    libraries: ["*"]
+    desk: cremail
+    to: [desk]
+    cc: [requester]
+    reply_to: patron
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requester := requestParams{Name: "Pat Assistant", Email: "pa1x@virginia.edu"}
+	onBehalf := requester
+	onBehalf.InstructorName = "Mary Smith"
+	onBehalf.InstructorEmail = "mst3k@virginia.edu"
+
+	for _, test := range []struct {
+		name    string
+		library string
+		params  requestParams
+		want    reserveEmailAddrs
+	}{
+		{"law", "law", onBehalf, reserveEmailAddrs{To: []string{"lawreserves@virginia.edu", "pa1x@virginia.edu", "mst3k@virginia.edu"},
+			CC: []string{}, From: "virgo4@virginia.edu", SubjectName: "Pat Assistant"}},
+		{"requester", "clemons", requester, reserveEmailAddrs{To: []string{"reserves@virginia.edu"}, CC: []string{"pa1x@virginia.edu"},
+			From: "virgo4@virginia.edu", ReplyTo: "pa1x@virginia.edu", SubjectName: "Pat Assistant"}},
+		{"on behalf of", "clemons", onBehalf, reserveEmailAddrs{To: []string{"reserves@virginia.edu"}, CC: []string{"pa1x@virginia.edu"},
+			From: "virgo4@virginia.edu", ReplyTo: "mst3k@virginia.edu", SubjectName: "Mary Smith"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got := routes.routeFor(test.library, false).addresses(&test.params, "virgo4@virginia.edu")
+			if strings.Join(got.To, ",") != strings.Join(test.want.To, ",") || strings.Join(got.CC, ",") != strings.Join(test.want.CC, ",") ||
+				got.From != test.want.From || got.ReplyTo != test.want.ReplyTo || got.SubjectName != test.want.SubjectName {
+				t.Errorf("addresses = %+v; want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReserveRoutesInvalid(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		routes string
+		want   string
+	}{
+		{"from patron", `
+loan_periods: [3h]
+routes:
+  - {name: all, libraries: ["*"], desk: cremail, to: [desk], from: patron}`, "use from: sender and reply_to: patron"},
+		{"bad reply to", `
+loan_periods: [3h]
+routes:
+  - {name: all, libraries: ["*"], desk: cremail, to: [desk], reply_to: desk}`, "reply_to must be"},
+		{"listed library without a route", `
+libraries: [law, clemons]
+loan_periods: [3h]
+routes:
+  - {name: law, libraries: [law], desk: lawemail, to: [desk]}`, "library clemons has no route"},
+		{"route for an unlisted library", `
+libraries: [law]
+loan_periods: [3h]
+routes:
+  - {name: law, libraries: [law, music], desk: lawemail, to: [desk]}`, "music is not in the list"},
+		{"no route for all libraries", `
+loan_periods: [3h]
+routes:
+  - {name: law, libraries: [law], desk: lawemail, to: [desk]}`, "library * has no route"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseTestRoutes(t, test.routes)
+			if err == nil || strings.Contains(err.Error(), test.want) == false {
+				t.Errorf("err = %v; want %q", err, test.want)
+			}
+		})
+	}
+}
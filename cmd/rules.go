@@ -0,0 +1,470 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+	"gopkg.in/yaml.v3"
+)
+
+// requestRules is the ordered list of rules that adjust the request options of an availability response.
+// Rules are loaded from a YAML (or JSON) file so request policy can change without a code change.
+type requestRules struct {
+	Rules []*requestRule `yaml:"rules"`
+}
+
+// requestRule applies its actions to a response when its condition matches
+type requestRule struct {
+	Name         string         `yaml:"name"`
+	SolrOptional bool           `yaml:"solr_optional"`
+	When         *ruleCondition `yaml:"when"`
+	Actions      []*ruleAction  `yaml:"actions"`
+}
+
+// ruleCondition tests one field of the JWT claims, solr document or an availability item. Conditions
+// can be combined with all, any and not.
+type ruleCondition struct {
+	All      []*ruleCondition `yaml:"all"`
+	Any      []*ruleCondition `yaml:"any"`
+	Not      *ruleCondition   `yaml:"not"`
+	Claim    string           `yaml:"claim"`
+	Solr     string           `yaml:"solr"`
+	Item     string           `yaml:"item"`
+	Equals   *string          `yaml:"equals"`
+	Contains string           `yaml:"contains"`
+	Present  *bool            `yaml:"present"`
+	First    bool             `yaml:"first"`
+}
+
+// ruleAction is a single change to an availability response. Exactly one field is set.
+type ruleAction struct {
+	AddOption     *optionTemplate `yaml:"add_option"`
+	RemoveOption  string          `yaml:"remove_option"`
+	ReplaceOption *replaceOption  `yaml:"replace_option"`
+	FilterItems   *ruleCondition  `yaml:"filter_items"`
+	AddItems      string          `yaml:"add_items"`
+}
+
+type replaceOption struct {
+	Type   string         `yaml:"type"`
+	Append bool           `yaml:"append"`
+	Option optionTemplate `yaml:"option"`
+}
+
+// optionTemplate is a RequestOption from the rules file. URLs and item options that depend on
+// the title are built by name with CreateURLFrom and ItemOptionsFrom.
+type optionTemplate struct {
+	Type             string `yaml:"type"`
+	Label            string `yaml:"label"`
+	Description      string `yaml:"description"`
+	CreateURL        string `yaml:"create_url"`
+	CreateURLFrom    string `yaml:"create_url_from"`
+	SignInRequired   bool   `yaml:"sign_in_required"`
+	StreamingReserve bool   `yaml:"streaming_reserve"`
+	ItemOptionsFrom  string `yaml:"item_options_from"`
+}
+
+// ruleInput is everything a rule can look at or change for one title
+type ruleInput struct {
+	Ctx     context.Context
+	TitleID string
+	Claims  *v4jwt.V4Claims
+	SolrDoc *SolrDocument
+	Result  *AvailabilityData
+	Trace   *ruleTrace
+}
+
+// named builders for values that can't be expressed in the rules file
+var ruleURLBuilders = map[string]func(svc *ServiceContext, in *ruleInput) string{
+	"hsl_illiad": func(svc *ServiceContext, in *ruleInput) string {
+		return openURLQuery(in.Ctx, svc.HSILLiadURL, in.SolrDoc)
+	},
+	"aeon": func(svc *ServiceContext, in *ruleInput) string { return createAeonURL(in.SolrDoc) },
+	"solr_url": func(svc *ServiceContext, in *ruleInput) string {
+		if len(in.SolrDoc.URL) > 0 {
+			return in.SolrDoc.URL[0]
+		}
+		return ""
+	},
+}
+
+// item options are null in the response unless the option builds them; empty gives an empty list
+var ruleItemOptionBuilders = map[string]func(in *ruleInput) []ItemOption{
+	"aeon":  func(in *ruleInput) []ItemOption { return createAeonItemOptions(in.Result, in.SolrDoc) },
+	"empty": func(in *ruleInput) []ItemOption { return []ItemOption{} },
+}
+
+var ruleItemSources = map[string]func(in *ruleInput){
+	"sc_availability": func(in *ruleInput) { processSCAvailabilityStored(in.Ctx, in.Result, in.SolrDoc) },
+}
+
+// loadRequestRules reads and validates a request option rules file
+func loadRequestRules(rulesFile string) (*requestRules, error) {
+	slog.Info("loading request option rules", "file", rulesFile)
+	rulesData, err := ioutil.ReadFile(rulesFile)
+	if err != nil {
+		return nil, err
+	}
+	var rules requestRules
+	if err := yaml.Unmarshal(rulesData, &rules); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %s", rulesFile, err.Error())
+	}
+	if err := rules.validate(); err != nil {
+		return nil, fmt.Errorf("invalid rules in %s: %s", rulesFile, err.Error())
+	}
+	slog.Info("loaded request option rules", "rules", len(rules.Rules))
+	return &rules, nil
+}
+
+func (rules *requestRules) validate() error {
+	names := make(map[string]bool)
+	for idx, rule := range rules.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d has no name", idx+1)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("rule %s is defined more than once", rule.Name)
+		}
+		names[rule.Name] = true
+		if rule.When == nil {
+			return fmt.Errorf("rule %s has no condition", rule.Name)
+		}
+		if err := rule.When.validate(false); err != nil {
+			return fmt.Errorf("rule %s: %s", rule.Name, err.Error())
+		}
+		if len(rule.Actions) == 0 {
+			return fmt.Errorf("rule %s has no actions", rule.Name)
+		}
+		for _, action := range rule.Actions {
+			if err := action.validate(); err != nil {
+				return fmt.Errorf("rule %s: %s", rule.Name, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// validate checks a condition; item conditions are only allowed when filtering items
+func (cond *ruleCondition) validate(forItems bool) error {
+	if cond == nil {
+		return errors.New("empty condition")
+	}
+	groups := make([]*ruleCondition, 0)
+	groups = append(groups, cond.All...)
+	groups = append(groups, cond.Any...)
+	if cond.Not != nil {
+		groups = append(groups, cond.Not)
+	}
+	if len(groups) > 0 {
+		for _, sub := range groups {
+			if err := sub.validate(forItems); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var target interface{}
+	field := ""
+	if cond.Claim != "" {
+		target, field = v4jwt.V4Claims{}, cond.Claim
+	} else if cond.Solr != "" {
+		target, field = SolrDocument{}, cond.Solr
+	} else if cond.Item != "" {
+		if forItems == false {
+			return fmt.Errorf("item %s can only be tested in filter_items", cond.Item)
+		}
+		target, field = Item{}, cond.Item
+	} else {
+		return errors.New("condition must have a claim, solr or item field")
+	}
+	if _, found := jsonFieldValues(target, field); found == false {
+		return fmt.Errorf("%s is not a known field", field)
+	}
+	if cond.Equals == nil && cond.Contains == "" && cond.Present == nil {
+		return fmt.Errorf("condition on %s must have equals, contains or present", field)
+	}
+	if cond.Contains != "" {
+		if _, err := regexp.Compile("(?i)" + cond.Contains); err != nil {
+			return fmt.Errorf("invalid contains pattern for %s: %s", field, err.Error())
+		}
+	}
+	return nil
+}
+
+func (action *ruleAction) validate() error {
+	set := 0
+	if action.AddOption != nil {
+		set++
+		if err := action.AddOption.validate(); err != nil {
+			return err
+		}
+	}
+	if action.RemoveOption != "" {
+		set++
+	}
+	if action.ReplaceOption != nil {
+		set++
+		if action.ReplaceOption.Type == "" {
+			return errors.New("replace_option requires a type")
+		}
+		if err := action.ReplaceOption.Option.validate(); err != nil {
+			return err
+		}
+	}
+	if action.FilterItems != nil {
+		set++
+		if err := action.FilterItems.validate(true); err != nil {
+			return err
+		}
+	}
+	if action.AddItems != "" {
+		set++
+		if _, ok := ruleItemSources[action.AddItems]; ok == false {
+			return fmt.Errorf("%s is not a known item source", action.AddItems)
+		}
+	}
+	if set != 1 {
+		return errors.New("each action must have exactly one of add_option, remove_option, replace_option, filter_items or add_items")
+	}
+	return nil
+}
+
+func (tpl *optionTemplate) validate() error {
+	if tpl.Type == "" {
+		return errors.New("request option requires a type")
+	}
+	if _, ok := ruleURLBuilders[tpl.CreateURLFrom]; tpl.CreateURLFrom != "" && ok == false {
+		return fmt.Errorf("%s is not a known create_url_from", tpl.CreateURLFrom)
+	}
+	if _, ok := ruleItemOptionBuilders[tpl.ItemOptionsFrom]; tpl.ItemOptionsFrom != "" && ok == false {
+		return fmt.Errorf("%s is not a known item_options_from", tpl.ItemOptionsFrom)
+	}
+	return nil
+}
+
+// applyRequestRules runs all of the rules, in order, against an availability response
+func (svc *ServiceContext) applyRequestRules(in *ruleInput) {
+	if svc.Rules == nil {
+		return
+	}
+	for _, rule := range svc.Rules.Rules {
+		decision := in.Trace.addRule(rule.Name)
+		if in.SolrDoc == nil && rule.SolrOptional == false {
+			decision.skip("no solr document")
+			continue
+		}
+		if rule.When.matches(in, nil, decision) == false {
+			decision.skip("conditions not met")
+			continue
+		}
+		slog.InfoContext(in.Ctx, "apply request option rule", "rule", rule.Name, "title_id", in.TitleID)
+		ruleFired(rule.Name)
+		decision.apply()
+		for _, action := range rule.Actions {
+			svc.applyRuleAction(action, in, decision)
+		}
+	}
+}
+
+func (svc *ServiceContext) applyRuleAction(action *ruleAction, in *ruleInput, decision *ruleDecision) {
+	avail := &in.Result.Availability
+	if action.AddOption != nil {
+		avail.RequestOptions = append(avail.RequestOptions, svc.buildRequestOption(action.AddOption, in))
+		decision.action(&actionDecision{Action: "add_option", OptionType: action.AddOption.Type, Result: "added"})
+	}
+	if action.RemoveOption != "" {
+		result := "not present"
+		for i, opt := range avail.RequestOptions {
+			if opt.Type == action.RemoveOption {
+				avail.RequestOptions = append(avail.RequestOptions[:i], avail.RequestOptions[i+1:]...)
+				result = "removed"
+				break
+			}
+		}
+		decision.action(&actionDecision{Action: "remove_option", OptionType: action.RemoveOption, Result: result})
+	}
+	if action.ReplaceOption != nil {
+		replaceIdx := -1
+		for i, opt := range avail.RequestOptions {
+			if opt.Type == action.ReplaceOption.Type {
+				replaceIdx = i
+			}
+		}
+		option := svc.buildRequestOption(&action.ReplaceOption.Option, in)
+		result := "not present"
+		if replaceIdx != -1 {
+			avail.RequestOptions[replaceIdx] = option
+			result = fmt.Sprintf("replaced with %s", option.Type)
+		} else if action.ReplaceOption.Append {
+			avail.RequestOptions = append(avail.RequestOptions, option)
+			result = fmt.Sprintf("not present; added %s", option.Type)
+		}
+		decision.action(&actionDecision{Action: "replace_option", OptionType: action.ReplaceOption.Type, Result: result})
+	}
+	if action.FilterItems != nil {
+		items := []*Item{}
+		removed := make([]string, 0)
+		for _, item := range avail.Items {
+			if action.FilterItems.matches(in, item, nil) {
+				items = append(items, item)
+			} else {
+				removed = append(removed, fmt.Sprintf("%s %s", item.Barcode, item.CallNumber))
+			}
+		}
+		avail.Items = items
+		decision.action(&actionDecision{Action: "filter_items", Result: fmt.Sprintf("kept %d of %d items", len(items), len(items)+len(removed)),
+			ItemsRemoved: removed})
+	}
+	if action.AddItems != "" {
+		before := len(avail.Items)
+		ruleItemSources[action.AddItems](in)
+		decision.action(&actionDecision{Action: "add_items", Result: fmt.Sprintf("added %d items from %s", len(avail.Items)-before, action.AddItems)})
+	}
+}
+
+func (svc *ServiceContext) buildRequestOption(tpl *optionTemplate, in *ruleInput) RequestOption {
+	option := RequestOption{
+		Type:             tpl.Type,
+		Label:            tpl.Label,
+		Description:      tpl.Description,
+		CreateURL:        tpl.CreateURL,
+		SignInRequired:   tpl.SignInRequired,
+		StreamingReserve: tpl.StreamingReserve,
+	}
+	if tpl.CreateURLFrom != "" {
+		option.CreateURL = ruleURLBuilders[tpl.CreateURLFrom](svc, in)
+	}
+	if tpl.ItemOptionsFrom != "" {
+		option.ItemOptions = ruleItemOptionBuilders[tpl.ItemOptionsFrom](in)
+	}
+	return option
+}
+
+// matches evaluates a condition. Item is only used by item conditions. Each field test is recorded
+// in the decision, if there is one.
+func (cond *ruleCondition) matches(in *ruleInput, item *Item, decision *ruleDecision) bool {
+	if len(cond.All) > 0 || len(cond.Any) > 0 || cond.Not != nil {
+		for _, sub := range cond.All {
+			if sub.matches(in, item, decision) == false {
+				return false
+			}
+		}
+		if len(cond.Any) > 0 {
+			anyMatch := false
+			for _, sub := range cond.Any {
+				if sub.matches(in, item, decision) {
+					anyMatch = true
+					break
+				}
+			}
+			if anyMatch == false {
+				return false
+			}
+		}
+		if cond.Not != nil && cond.Not.matches(in, item, decision) {
+			return false
+		}
+		return true
+	}
+
+	var values []string
+	if cond.Claim != "" && in.Claims != nil {
+		values, _ = jsonFieldValues(*in.Claims, cond.Claim)
+		in.Trace.claimUsed(cond.Claim, values)
+	} else if cond.Solr != "" && in.SolrDoc != nil {
+		values, _ = jsonFieldValues(*in.SolrDoc, cond.Solr)
+	} else if cond.Item != "" && item != nil {
+		values, _ = jsonFieldValues(*item, cond.Item)
+	}
+	if cond.First && len(values) > 1 {
+		values = values[:1]
+	}
+
+	matched := cond.test(values)
+	decision.condition(cond, values, matched)
+	return matched
+}
+
+// test checks field values against the equals, contains and present tests of a condition
+func (cond *ruleCondition) test(values []string) bool {
+	if cond.Present != nil && (len(values) > 0) != *cond.Present {
+		return false
+	}
+	if cond.Equals != nil {
+		found := false
+		for _, v := range values {
+			if v == *cond.Equals {
+				found = true
+				break
+			}
+		}
+		if found == false {
+			return false
+		}
+	}
+	if cond.Contains != "" && contains(values, cond.Contains) == false {
+		return false
+	}
+	return true
+}
+
+// describe is a readable summary of the tests in a leaf condition
+func (cond *ruleCondition) describe() string {
+	tests := make([]string, 0)
+	if cond.First {
+		tests = append(tests, "first value")
+	}
+	if cond.Present != nil {
+		tests = append(tests, fmt.Sprintf("present is %t", *cond.Present))
+	}
+	if cond.Equals != nil {
+		tests = append(tests, fmt.Sprintf("equals %q", *cond.Equals))
+	}
+	if cond.Contains != "" {
+		tests = append(tests, fmt.Sprintf("contains %q", cond.Contains))
+	}
+	return strings.Join(tests, ", ")
+}
+
+// jsonFieldValues gets the values of the struct field with the given JSON name as strings. Empty
+// values are omitted, so a field with no value returns an empty list. The second result is false if
+// there is no such field.
+func jsonFieldValues(target interface{}, name string) ([]string, bool) {
+	rv := reflect.ValueOf(target)
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		tag := strings.Split(rt.Field(i).Tag.Get("json"), ",")[0]
+		if tag != name || tag == "-" {
+			continue
+		}
+		out := make([]string, 0)
+		fv := rv.Field(i)
+		if stringer, ok := fv.Interface().(fmt.Stringer); ok {
+			return append(out, stringer.String()), true
+		}
+		switch fv.Kind() {
+		case reflect.String:
+			if fv.String() != "" {
+				out = append(out, fv.String())
+			}
+		case reflect.Bool:
+			out = append(out, fmt.Sprintf("%t", fv.Bool()))
+		case reflect.Slice:
+			for j := 0; j < fv.Len(); j++ {
+				out = append(out, fmt.Sprintf("%v", fv.Index(j).Interface()))
+			}
+		default:
+			out = append(out, fmt.Sprintf("%v", fv.Interface()))
+		}
+		return out, true
+	}
+	return nil, false
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+// ruleFixture is one title from testdata/request_options.json; the ILS availability, solr document
+// and JWT claims the request option rules run against
+type ruleFixture struct {
+	Name         string          `json:"name"`
+	Claims       v4jwt.V4Claims  `json:"claims"`
+	Solr         json.RawMessage `json:"solr"`
+	Availability json.RawMessage `json:"availability"`
+}
+
+// inputs decodes a fresh copy of the fixture, since the rules change the availability in place
+func (fix *ruleFixture) inputs(t *testing.T) (*SolrDocument, *AvailabilityData) {
+	t.Helper()
+	var solrDoc *SolrDocument
+	if err := json.Unmarshal(fix.Solr, &solrDoc); err != nil {
+		t.Fatal(err)
+	}
+	var avail AvailabilityData
+	if err := json.Unmarshal(fix.Availability, &avail); err != nil {
+		t.Fatal(err)
+	}
+	return solrDoc, &avail
+}
+
+func loadRuleFixtures(t *testing.T) []*ruleFixture {
+	t.Helper()
+	data, err := os.ReadFile("testdata/request_options.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixtures []*ruleFixture
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		t.Fatal(err)
+	}
+	return fixtures
+}
+
+// TestRequestRulesMatchLegacy runs the default rules and the hard coded request option logic they
+// replaced over the same fixtures; the request options and items must be identical.
+func TestRequestRulesMatchLegacy(t *testing.T) {
+	rules, err := loadRequestRules("../data/request_options.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := &ServiceContext{Rules: rules, HSILLiadURL: "https://hsl.illiad.example.edu"}
+
+	for _, fix := range loadRuleFixtures(t) {
+		t.Run(fix.Name, func(t *testing.T) {
+			claims := fix.Claims
+			solrDoc, want := fix.inputs(t)
+			titleID := want.Availability.ID
+			legacyRequestOptions(svc, titleID, solrDoc, &claims, want)
+
+			solrDoc, got := fix.inputs(t)
+			svc.applyRequestRules(&ruleInput{Ctx: context.Background(), TitleID: titleID, Claims: &claims, SolrDoc: solrDoc, Result: got})
+
+			wantJSON, _ := json.MarshalIndent(want, "", "  ")
+			gotJSON, _ := json.MarshalIndent(got, "", "  ")
+			if string(gotJSON) != string(wantJSON) {
+				t.Errorf("rules output differs from the legacy code\n got: %s\nwant: %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+func TestRuleOptionItemOptions(t *testing.T) {
+	rules, err := loadRequestRules("../data/request_options.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := &ServiceContext{Rules: rules}
+	for _, fix := range loadRuleFixtures(t) {
+		if fix.Name != "etas with a hathi link" {
+			continue
+		}
+		solrDoc, avail := fix.inputs(t)
+		svc.applyRequestRules(&ruleInput{Ctx: context.Background(), TitleID: "u10", Claims: &fix.Claims, SolrDoc: solrDoc, Result: avail})
+		out, _ := json.Marshal(avail.Availability.RequestOptions[1])
+		want := `{"type":"directLink","button_label":"Read via HathiTrust",`
+		if string(out[:len(want)]) != want {
+			t.Fatalf("hold option = %s; want the hathi link", out)
+		}
+		var option map[string]interface{}
+		json.Unmarshal(out, &option)
+		if itemOptions, found := option["item_options"]; found == false || itemOptions != nil {
+			t.Errorf("hathi item_options = %v; want null", itemOptions)
+		}
+		return
+	}
+	t.Fatal("missing etas fixture")
+}
+
+// legacyRequestOptions is the request option logic from processAvailability before it moved to the
+// rules file, kept as the reference for the default rules.
+func legacyRequestOptions(svc *ServiceContext, titleID string, solrDoc *SolrDocument, v4Claims *v4jwt.V4Claims, result *AvailabilityData) {
+	if solrDoc == nil {
+		return
+	}
+	avail := &result.Availability
+
+	if v4Claims.HomeLibrary == "HEALTHSCI" {
+		for i, opt := range avail.RequestOptions {
+			if opt.Type == "scan" {
+				avail.RequestOptions = append(avail.RequestOptions[:i], avail.RequestOptions[i+1:]...)
+				break
+			}
+		}
+		avail.RequestOptions = append(avail.RequestOptions, RequestOption{
+			Type:        "directLink",
+			Label:       "Request a scan",
+			Description: "Select a portion of this item to be scanned.",
+			CreateURL:   openURLQuery(context.Background(), svc.HSILLiadURL, solrDoc),
+			ItemOptions: make([]ItemOption, 0),
+		})
+	}
+
+	if v4Claims.CanPlaceReserve {
+		if (solrDoc.Pool[0] == "video" && contains(solrDoc.Location, "Internet materials")) || contains(solrDoc.Source, "Avalon") {
+			avail.RequestOptions = append(avail.RequestOptions, RequestOption{
+				Type:             "videoReserve",
+				Label:            "Video reserve request",
+				SignInRequired:   true,
+				Description:      "Request a video reserve for streaming",
+				StreamingReserve: true,
+				ItemOptions:      []ItemOption{},
+			})
+		}
+	}
+
+	processSCAvailabilityStored(context.Background(), result, solrDoc)
+	if contains(solrDoc.Library, "Special Collections") {
+		avail.RequestOptions = append(avail.RequestOptions, RequestOption{
+			Type:        "aeon",
+			Label:       "Request this in Special Collections",
+			CreateURL:   createAeonURL(solrDoc),
+			ItemOptions: createAeonItemOptions(result, solrDoc),
+		})
+	}
+
+	if len(solrDoc.HathiETAS) > 0 && solrDoc.HathiETAS[0] == "etas" {
+		hathiOption := RequestOption{
+			Type: "directLink",
+			Description: "Use the link above to read this item online through the <a target=\"_blank\" href=\"https://www.library.virginia.edu/services/etas\">Emergency Temporary Access Service.</a>" +
+				"<p>Because of U.S. Copyright law, any item made available online through ETAS cannot be also physically circulated. Buttons above reflect any requests that can be made for this item. <a href=\"https://www.library.virginia.edu/news/covid-19/\" target=\"blank\">Read more about digital and physical access during COVID-19.</a></p>",
+		}
+		if len(solrDoc.URL) > 0 {
+			hathiOption.CreateURL = solrDoc.URL[0]
+			hathiOption.Label = "Read via HathiTrust"
+		}
+		holdID := -1
+		for i, v := range avail.RequestOptions {
+			if v.Type == "hold" {
+				holdID = i
+			}
+		}
+		if holdID != -1 {
+			avail.RequestOptions[holdID] = hathiOption
+		} else {
+			avail.RequestOptions = append(avail.RequestOptions, hathiOption)
+		}
+		items := []*Item{}
+		for _, v := range avail.Items {
+			if v.LibraryID == "SPEC-COLL" {
+				items = append(items, v)
+			}
+		}
+		avail.Items = items
+	}
+}
@@ -2,11 +2,14 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"path/filepath"
@@ -14,28 +17,42 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	_ "github.com/lib/pq"
 	"gopkg.in/gomail.v2"
 )
 
 // ServiceContext contains common data used by all handlers
 type ServiceContext struct {
-	Version            string
-	VirgoURL           string
-	ILSAPI             string
-	JWTKey             string
-	Solr               SolrConfig
-	Maps               []Map
-	MapLookups         []MapLookup
-	HSILLiadURL        string
-	CourseReserveEmail string
-	LawReserveEmail    string
-	HTTPClient         *http.Client
-	FastHTTPClient     *http.Client
-	SlowHTTPClient     *http.Client
-	SMTP               SMTPConfig
+	Version          string
+	VirgoURL         string
+	ILSAPI           string
+	ILS              ILSBackend
+	ILSBreaker       *circuitBreaker
+	SolrBreaker      *circuitBreaker
+	Rules            *requestRules
+	Public           *publicAccess
+	JWTKey           string
+	Solr             SolrConfig
+	Maps             []Map
+	MapLookups       []MapLookup
+	MapIndex         *mapIndex
+	HSILLiadURL      string
+	ReserveRoutes    *reserveRoutes
+	BatchLimit       int
+	ReserveItemLimit int
+	HTTPClient       *http.Client
+	FastHTTPClient   *http.Client
+	SlowHTTPClient   *http.Client
+	SMTP             SMTPConfig
+	DB               *sql.DB
+	Outbox           *emailOutbox
+	Cache            *responseCache
+	ILSCache         cacheSource
+	SolrCache        cacheSource
 }
 
+// statusClientClosedRequest is the (nginx) status used when a client goes away before a response is ready
+const statusClientClosedRequest = 499
+
 // RequestError contains http status code and message for a
 // failed ILS Connector request
 type RequestError struct {
@@ -46,22 +63,22 @@ type RequestError struct {
 // intializeService will initialize the service context based on the config parameters
 func intializeService(version string, cfg *ServiceConfig) (*ServiceContext, error) {
 	ctx := ServiceContext{Version: version,
-		VirgoURL:           cfg.VirgoURL,
-		Solr:               cfg.Solr,
-		SMTP:               cfg.SMTP,
-		HSILLiadURL:        cfg.HSILLiadURL,
-		CourseReserveEmail: cfg.CourseReserveEmail,
-		LawReserveEmail:    cfg.LawReserveEmail,
-		JWTKey:             cfg.JWTKey,
-		ILSAPI:             cfg.ILSAPI,
+		VirgoURL:         cfg.VirgoURL,
+		Solr:             cfg.Solr,
+		SMTP:             cfg.SMTP,
+		HSILLiadURL:      cfg.HSILLiadURL,
+		BatchLimit:       cfg.BatchLimit,
+		ReserveItemLimit: cfg.ReserveItemLimit,
+		JWTKey:           cfg.JWTKey,
+		ILSAPI:           cfg.ILSAPI,
 	}
 
 	if ctx.SMTP.DevMode {
-		log.Printf("Using dev mode for SMTP; all messages will be logged instead of delivered")
+		slog.Info("using dev mode for SMTP; all messages will be logged instead of delivered")
 	}
 
-	log.Printf("Create HTTP client for external service calls")
-	defaultTransport := &http.Transport{
+	slog.Info("create HTTP client for external service calls")
+	baseTransport := &http.Transport{
 		Dial: (&net.Dialer{
 			Timeout:   5 * time.Second,
 			KeepAlive: 600 * time.Second,
@@ -70,6 +87,7 @@ func intializeService(version string, cfg *ServiceConfig) (*ServiceContext, erro
 		MaxIdleConns:        100,
 		MaxIdleConnsPerHost: 100,
 	}
+	defaultTransport := &requestIDTransport{Base: baseTransport}
 	ctx.HTTPClient = &http.Client{
 		Transport: defaultTransport,
 		Timeout:   10 * time.Second,
@@ -84,6 +102,45 @@ func intializeService(version string, cfg *ServiceConfig) (*ServiceContext, erro
 	}
 	ctx.initMapLookups()
 
+	ctx.Public = newPublicAccess(cfg.Public, cfg.JWTKey)
+
+	rules, err := loadRequestRules(cfg.RulesFile)
+	if err != nil {
+		return nil, err
+	}
+	ctx.Rules = rules
+
+	routes, err := loadReserveRoutes(cfg.RoutesFile, cfg)
+	if err != nil {
+		return nil, err
+	}
+	ctx.ReserveRoutes = routes
+
+	ctx.ILSBreaker = newCircuitBreaker("ils", cfg.Breaker)
+	ctx.SolrBreaker = newCircuitBreaker("solr", cfg.Breaker)
+	ils, err := newILSBackend(&ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	ctx.ILS = ils
+	slog.Info("using ILS backend", "backend", ils.Name())
+
+	ctx.Cache, err = newResponseCache(cfg.Cache)
+	if err != nil {
+		return nil, err
+	}
+	ctx.ILSCache = cacheSource{Name: "ils", TTL: cfg.Cache.ILSTTL,
+		Revalidate: cfg.Cache.Revalidate, StaleIfError: cfg.Cache.StaleIfError}
+	ctx.SolrCache = cacheSource{Name: "solr", TTL: cfg.Cache.SolrTTL,
+		Revalidate: cfg.Cache.Revalidate, StaleIfError: cfg.Cache.StaleIfError}
+
+	err = ctx.initDB(cfg.DB)
+	if err != nil {
+		return nil, err
+	}
+	ctx.Outbox = newEmailOutbox(&ctx, cfg.Outbox)
+	ctx.Outbox.start()
+
 	return &ctx, nil
 }
 
@@ -106,32 +163,6 @@ func (svc *ServiceContext) getVersion(c *gin.Context) {
 	c.JSON(http.StatusOK, vMap)
 }
 
-// HealthCheck reports the health of the server
-func (svc *ServiceContext) healthCheck(c *gin.Context) {
-	type hcResp struct {
-		Healthy bool   `json:"healthy"`
-		Message string `json:"message,omitempty"`
-		Version int    `json:"version,omitempty"`
-	}
-	hcMap := make(map[string]hcResp)
-
-	if svc.ILSAPI != "" {
-		apiURL := fmt.Sprintf("%s/version", svc.ILSAPI)
-		resp, err := svc.FastHTTPClient.Get(apiURL)
-		if resp != nil {
-			defer resp.Body.Close()
-		}
-		if err != nil {
-			log.Printf("ERROR: Failed response from ILS Connector PING: %s - %s", err.Error(), svc.ILSAPI)
-			hcMap["ils_connector"] = hcResp{Healthy: false, Message: err.Error()}
-		} else {
-			hcMap["ils_connector"] = hcResp{Healthy: true}
-		}
-	}
-
-	c.JSON(http.StatusOK, hcMap)
-}
-
 type solrRequestParams struct {
 	Rows int      `json:"rows"`
 	Fq   []string `json:"fq,omitempty"`
@@ -150,94 +181,32 @@ type solrRequest struct {
 	Facets map[string]solrRequestFacet `json:"facet,omitempty"`
 }
 
-// ILSConnectorGet sends a GET request to the ILS connector and returns the response
-func (svc *ServiceContext) ILSConnectorGet(url string, jwt string, httpClient *http.Client) ([]byte, *RequestError) {
-
-	logURL := sanitizeURL(url)
-	log.Printf("ILS Connector GET request: %s, timeout  %.0f sec", logURL, httpClient.Timeout.Seconds())
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", jwt))
-
-	startTime := time.Now()
-	rawResp, rawErr := httpClient.Do(req)
-	resp, err := handleAPIResponse(logURL, rawResp, rawErr)
-	elapsedNanoSec := time.Since(startTime)
-	elapsedMS := int64(elapsedNanoSec / time.Millisecond)
-
-	if err != nil {
-		if shouldLogAsError(err.StatusCode) {
-			log.Printf("ERROR: Failed response from ILS GET %s - %d:%s. Elapsed Time: %d (ms)",
-				logURL, err.StatusCode, err.Message, elapsedMS)
-		} else {
-			log.Printf("INFO: Response from ILS GET %s - %d:%s. Elapsed Time: %d (ms)",
-				logURL, err.StatusCode, err.Message, elapsedMS)
-		}
-	} else {
-		log.Printf("Successful response from ILS GET %s. Elapsed Time: %d (ms)", logURL, elapsedMS)
-	}
-	return resp, err
-}
-
-// ILSConnectorPost sends a POST to the ILS connector and returns results
-func (svc *ServiceContext) ILSConnectorPost(url string, values interface{}, jwt string) ([]byte, *RequestError) {
-	log.Printf("ILS Connector POST request: %s", url)
-	startTime := time.Now()
-	b, _ := json.Marshal(values)
-	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(b))
-	req.Header.Add("Content-type", "application/json")
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", jwt))
-	httpClient := svc.HTTPClient
-	rawResp, rawErr := httpClient.Do(req)
-	resp, err := handleAPIResponse(url, rawResp, rawErr)
-	elapsedNanoSec := time.Since(startTime)
-	elapsedMS := int64(elapsedNanoSec / time.Millisecond)
-
-	if err != nil {
-		log.Printf("ERROR: Failed response from ILS POST %s - %d:%s. Elapsed Time: %d (ms)",
-			url, err.StatusCode, err.Message, elapsedMS)
-	} else {
-		log.Printf("Successful response from ILS POST %s. Elapsed Time: %d (ms)", url, elapsedMS)
-	}
-	return resp, err
-}
-
-// ILSConnectorDelete sends a DELETE request to the ILS connector and returns the response
-func (svc *ServiceContext) ILSConnectorDelete(url string, jwt string) ([]byte, *RequestError) {
-	log.Printf("ILS Connector DELETE request: %s", url)
-	req, _ := http.NewRequest("DELETE", url, nil)
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", jwt))
-
-	startTime := time.Now()
-	rawResp, rawErr := svc.HTTPClient.Do(req)
-	resp, err := handleAPIResponse(url, rawResp, rawErr)
-	elapsedNanoSec := time.Since(startTime)
-	elapsedMS := int64(elapsedNanoSec / time.Millisecond)
-
-	if err != nil {
-		log.Printf("ERROR: Failed response from ILS DELETE %s - %d:%s. Elapsed Time: %d (ms)",
-			url, err.StatusCode, err.Message, elapsedMS)
-	} else {
-		log.Printf("Successful response from ILS DELETE %s. Elapsed Time: %d (ms)", url, elapsedMS)
-	}
-	return resp, err
-}
-
 // SolrGet sends a GET request to solr and returns the response
-func (svc *ServiceContext) SolrGet(query string) ([]byte, *RequestError) {
+func (svc *ServiceContext) SolrGet(ctx context.Context, query string) ([]byte, *RequestError) {
 	url := fmt.Sprintf("%s/%s/%s", svc.Solr.URL, svc.Solr.Core, query)
-	log.Printf("Solr GET request: %s", url)
-	startTime := time.Now()
-	rawResp, rawErr := svc.FastHTTPClient.Get(url)
-	resp, err := handleAPIResponse(url, rawResp, rawErr)
-	elapsedNanoSec := time.Since(startTime)
-	elapsedMS := int64(elapsedNanoSec / time.Millisecond)
+	var resp []byte
+	err := svc.SolrBreaker.call(ctx, "GET", func() *RequestError {
+		slog.InfoContext(ctx, "Solr request", "method", "GET", "url", url)
+		startTime := time.Now()
+		var rawResp *http.Response
+		req, rawErr := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if rawErr == nil {
+			rawResp, rawErr = svc.FastHTTPClient.Do(req)
+		}
+		var err *RequestError
+		resp, err = handleAPIResponse(url, rawResp, rawErr)
+		elapsedNanoSec := time.Since(startTime)
+		elapsedMS := int64(elapsedNanoSec / time.Millisecond)
+		observeUpstream("solr", "GET", elapsedNanoSec, err)
 
-	if err != nil {
-		log.Printf("ERROR: Failed response from Solr GET %s - %d:%s. Elapsed Time: %d (ms)",
-			url, err.StatusCode, err.Message, elapsedMS)
-	} else {
-		log.Printf("Successful response from Solr GET %s. Elapsed Time: %d (ms)", url, elapsedMS)
-	}
+		if err != nil {
+			slog.ErrorContext(ctx, "Solr response", "method", "GET", "url", url, "status", err.StatusCode,
+				"error", err.Message, "elapsed_ms", elapsedMS)
+		} else {
+			slog.InfoContext(ctx, "Solr response", "method", "GET", "url", url, "status", http.StatusOK, "elapsed_ms", elapsedMS)
+		}
+		return err
+	})
 	return resp, err
 }
 
@@ -259,18 +228,19 @@ func (svc *ServiceContext) SolrPost(query string, jsonReq interface{}) ([]byte,
 
 	req.Header.Set("Content-Type", "application/json")
 
-	log.Printf("Solr POST request: %s", url)
+	slog.Info("Solr request", "method", "POST", "url", url)
 	startTime := time.Now()
 	rawResp, rawErr := svc.FastHTTPClient.Do(req)
 	resp, err := handleAPIResponse(url, rawResp, rawErr)
 	elapsedNanoSec := time.Since(startTime)
 	elapsedMS := int64(elapsedNanoSec / time.Millisecond)
+	observeUpstream("solr", "POST", elapsedNanoSec, err)
 
 	if err != nil {
-		log.Printf("ERROR: Failed response from Solr POST %s - %d:%s. Elapsed Time: %d (ms)",
-			url, err.StatusCode, err.Message, elapsedMS)
+		slog.Error("Solr response", "method", "POST", "url", url, "status", err.StatusCode,
+			"error", err.Message, "elapsed_ms", elapsedMS)
 	} else {
-		log.Printf("Successful response from Solr POST %s. Elapsed Time: %d (ms)", url, elapsedMS)
+		slog.Info("Solr response", "method", "POST", "url", url, "status", http.StatusOK, "elapsed_ms", elapsedMS)
 	}
 	return resp, err
 }
@@ -279,7 +249,10 @@ func handleAPIResponse(logURL string, resp *http.Response, err error) ([]byte, *
 	if err != nil {
 		status := http.StatusBadRequest
 		errMsg := err.Error()
-		if strings.Contains(err.Error(), "Timeout") {
+		if errors.Is(err, context.Canceled) {
+			status = statusClientClosedRequest
+			errMsg = fmt.Sprintf("%s was canceled", logURL)
+		} else if strings.Contains(err.Error(), "Timeout") {
 			status = http.StatusRequestTimeout
 			errMsg = fmt.Sprintf("%s timed out", logURL)
 		} else if strings.Contains(err.Error(), "connection refused") {
@@ -300,38 +273,22 @@ func handleAPIResponse(logURL string, resp *http.Response, err error) ([]byte, *
 	return bodyBytes, nil
 }
 
-// do we log this http response as an error or is it expected under normal circumstances
-func shouldLogAsError(httpStatus int) bool {
-	return httpStatus != http.StatusOK && httpStatus != http.StatusNotFound
-}
-
-// sanitize a url for logging by removing the customer PIN
-func sanitizeURL(url string) string {
-
-	// URL contains the user PIN
-	ix := strings.Index(url, "pin=")
-
-	// replace everything after
-	if ix >= 0 {
-		return url[0:ix] + "pin=SECRET"
-	}
-
-	return url
-}
-
 type emailRequest struct {
-	Subject string
-	To      []string
-	ReplyTo string
-	CC      string
-	From    string
-	Body    string
+	Subject  string
+	To       []string
+	ReplyTo  string
+	CC       []string
+	From     string
+	Body     string
+	HTMLBody string
 }
 
 func (svc *ServiceContext) sendEmail(request *emailRequest) error {
 	mail := gomail.NewMessage()
 	mail.SetHeader("MIME-version", "1.0")
-	mail.SetHeader("Content-Type", "text/plain; charset=\"UTF-8\"")
+	if request.HTMLBody == "" {
+		mail.SetHeader("Content-Type", "text/plain; charset=\"UTF-8\"")
+	}
 	mail.SetHeader("Subject", request.Subject)
 	mail.SetHeader("To", request.To...)
 	mail.SetHeader("From", request.From)
@@ -339,27 +296,41 @@ func (svc *ServiceContext) sendEmail(request *emailRequest) error {
 		mail.SetHeader("Reply-To", request.ReplyTo)
 	}
 	if len(request.CC) > 0 {
-		mail.SetHeader("Cc", request.CC)
+		mail.SetHeader("Cc", request.CC...)
 	}
 	mail.SetBody("text/plain", request.Body)
+	if request.HTMLBody != "" {
+		// sent as multipart/alternative; clients that can't show HTML use the plain text
+		mail.AddAlternative("text/html", request.HTMLBody)
+	}
 
 	if svc.SMTP.DevMode {
-		log.Printf("Email is in dev mode. Logging message instead of sending")
-		log.Printf("==================================================")
-		mail.WriteTo(log.Writer())
-		log.Printf("==================================================")
+		// the message is rendered first so it is logged as a single record rather than one per write
+		var msg bytes.Buffer
+		if _, err := mail.WriteTo(&msg); err != nil {
+			emailsSent.WithLabelValues("failed").Inc()
+			return err
+		}
+		slog.Info("email is in dev mode; logging message instead of sending", "subject", request.Subject,
+			"to", strings.Join(request.To, ","), "message", msg.String())
+		emailsSent.WithLabelValues("logged").Inc()
 		return nil
 	}
 
-	log.Printf("Sending %s email to %s", request.Subject, strings.Join(request.To, ","))
+	slog.Info("sending email", "subject", request.Subject, "to", strings.Join(request.To, ","))
+	dialer := gomail.Dialer{Host: svc.SMTP.Host, Port: svc.SMTP.Port}
 	if svc.SMTP.Pass != "" {
-		dialer := gomail.Dialer{Host: svc.SMTP.Host, Port: svc.SMTP.Port, Username: svc.SMTP.User, Password: svc.SMTP.Pass}
-		dialer.TLSConfig = &tls.Config{InsecureSkipVerify: true}
-		return dialer.DialAndSend(mail)
+		dialer.Username = svc.SMTP.User
+		dialer.Password = svc.SMTP.Pass
+	} else {
+		slog.Info("sending email with no auth")
 	}
-
-	log.Printf("Sending email with no auth")
-	dialer := gomail.Dialer{Host: svc.SMTP.Host, Port: svc.SMTP.Port}
 	dialer.TLSConfig = &tls.Config{InsecureSkipVerify: true}
-	return dialer.DialAndSend(mail)
+	err := dialer.DialAndSend(mail)
+	if err != nil {
+		emailsSent.WithLabelValues("failed").Inc()
+		return err
+	}
+	emailsSent.WithLabelValues("sent").Inc()
+	return nil
 }
@@ -0,0 +1,54 @@
+package main
+
+import "fmt"
+
+// summarizeHoldings groups items by library and current location and counts how many are available.
+// Libraries and locations are listed in the order they first appear in the items. The best location
+// to get the title now is the one with the most available items. There is no summary for no items.
+func summarizeHoldings(items []*Item) *HoldingsSummary {
+	if len(items) == 0 {
+		return nil
+	}
+
+	summary := HoldingsSummary{Libraries: make([]*LibrarySummary, 0)}
+	libraries := make(map[string]*LibrarySummary)
+	locations := make(map[string]*LocationSummary)
+	for _, item := range items {
+		library := item.Library
+		if library == "" {
+			library = item.LibraryID
+		}
+		lib, found := libraries[library]
+		if found == false {
+			lib = &LibrarySummary{Library: library, Locations: make([]*LocationSummary, 0)}
+			libraries[library] = lib
+			summary.Libraries = append(summary.Libraries, lib)
+		}
+		locKey := fmt.Sprintf("%s|%s", library, item.CurrentLocation)
+		loc, found := locations[locKey]
+		if found == false {
+			loc = &LocationSummary{Library: library, Location: item.CurrentLocation}
+			locations[locKey] = loc
+			lib.Locations = append(lib.Locations, loc)
+		}
+
+		summary.Total++
+		lib.Total++
+		loc.Total++
+		if item.Status == statusAvailable {
+			summary.Available++
+			lib.Available++
+			loc.Available++
+			if summary.BestLocation == nil || loc.Available > summary.BestLocation.Available {
+				summary.BestLocation = loc
+			}
+		}
+	}
+
+	summary.Label = fmt.Sprintf("%d of %d available", summary.Available, summary.Total)
+	if summary.BestLocation != nil {
+		best := libraries[summary.BestLocation.Library]
+		summary.Label = fmt.Sprintf("%d of %d available at %s", best.Available, best.Total, best.Library)
+	}
+	return &summary
+}
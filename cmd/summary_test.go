@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// summaryItem is an item at a library location with a normalized status
+func summaryItem(library string, location string, status string) *Item {
+	return &Item{Library: library, CurrentLocation: location, Status: status}
+}
+
+// describeSummary lists the counts of a summary; library available/total [location available/total ...]
+func describeSummary(summary *HoldingsSummary) string {
+	libraries := make([]string, 0)
+	for _, lib := range summary.Libraries {
+		locations := make([]string, 0)
+		for _, loc := range lib.Locations {
+			locations = append(locations, fmt.Sprintf("%s %d/%d", loc.Location, loc.Available, loc.Total))
+		}
+		libraries = append(libraries, fmt.Sprintf("%s %d/%d [%s]", lib.Library, lib.Available, lib.Total, strings.Join(locations, ", ")))
+	}
+	return strings.Join(libraries, "; ")
+}
+
+func TestSummarizeHoldings(t *testing.T) {
+	tests := []struct {
+		name      string
+		items     []*Item
+		available int
+		total     int
+		libraries string
+		best      string
+		label     string
+	}{
+		{"one available item", []*Item{summaryItem("Clemons", "Stacks", statusAvailable)},
+			1, 1, "Clemons 1/1 [Stacks 1/1]", "Clemons|Stacks", "1 of 1 available at Clemons"},
+		{"locations of one library", []*Item{
+			summaryItem("Alderman", "Stacks", statusAvailable),
+			summaryItem("Alderman", "Reference", statusAvailable),
+			summaryItem("Alderman", "Stacks", statusCheckedOut),
+			summaryItem("Alderman", "Stacks", statusAvailable),
+		}, 3, 4, "Alderman 3/4 [Stacks 2/3, Reference 1/1]", "Alderman|Stacks", "3 of 4 available at Alderman"},
+		{"checked out items count only toward the total", []*Item{
+			summaryItem("Clemons", "Stacks", statusCheckedOut),
+			summaryItem("Alderman", "Stacks", statusCheckedOut),
+			summaryItem("Alderman", "Stacks", statusAvailable),
+			summaryItem("Clemons", "Stacks", statusOnHoldShelf),
+		}, 1, 4, "Clemons 0/2 [Stacks 0/2]; Alderman 1/2 [Stacks 1/2]", "Alderman|Stacks", "1 of 2 available at Alderman"},
+		{"best location has the most available", []*Item{
+			summaryItem("Clemons", "Stacks", statusAvailable),
+			summaryItem("Music", "Stacks", statusAvailable),
+			summaryItem("Music", "Stacks", statusAvailable),
+			summaryItem("Clemons", "Stacks", statusCheckedOut),
+		}, 3, 4, "Clemons 1/2 [Stacks 1/2]; Music 2/2 [Stacks 2/2]", "Music|Stacks", "2 of 2 available at Music"},
+		{"first location wins a tie", []*Item{
+			summaryItem("Clemons", "Stacks", statusAvailable),
+			summaryItem("Music", "Stacks", statusAvailable),
+		}, 2, 2, "Clemons 1/1 [Stacks 1/1]; Music 1/1 [Stacks 1/1]", "Clemons|Stacks", "1 of 1 available at Clemons"},
+		{"none available", []*Item{
+			summaryItem("Clemons", "Stacks", statusCheckedOut),
+			summaryItem("Clemons", "Stacks", statusMissing),
+			summaryItem("Ivy", "Stacks", statusInTransit),
+		}, 0, 3, "Clemons 0/2 [Stacks 0/2]; Ivy 0/1 [Stacks 0/1]", "", "0 of 3 available"},
+		{"library ID when there is no name", []*Item{
+			{LibraryID: "LAW", CurrentLocation: "Stacks", Status: statusAvailable},
+			summaryItem("Law", "Stacks", statusAvailable),
+		}, 2, 2, "LAW 1/1 [Stacks 1/1]; Law 1/1 [Stacks 1/1]", "LAW|Stacks", "1 of 1 available at LAW"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			summary := summarizeHoldings(test.items)
+			if summary.Available != test.available || summary.Total != test.total {
+				t.Errorf("summary = %d of %d; want %d of %d", summary.Available, summary.Total, test.available, test.total)
+			}
+			if got := describeSummary(summary); got != test.libraries {
+				t.Errorf("libraries = %s; want %s", got, test.libraries)
+			}
+			best := ""
+			if summary.BestLocation != nil {
+				best = summary.BestLocation.Library + "|" + summary.BestLocation.Location
+			}
+			if best != test.best {
+				t.Errorf("best location = %q; want %q", best, test.best)
+			}
+			if summary.Label != test.label {
+				t.Errorf("label = %q; want %q", summary.Label, test.label)
+			}
+		})
+	}
+}
+
+func TestSummarizeNoHoldings(t *testing.T) {
+	if summary := summarizeHoldings(nil); summary != nil {
+		t.Errorf("summary of no items = %+v; want none", summary)
+	}
+	if summary := summarizeHoldings([]*Item{}); summary != nil {
+		t.Errorf("summary of an empty list = %+v; want none", summary)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// longest title ID accepted; real IDs are well under this
+const maxTitleIDLength = 100
+
+// titleIDPattern is one kind of catalog ID the service can look up
+type titleIDPattern struct {
+	Name  string
+	Regex *regexp.Regexp
+}
+
+// titleIDPatterns are the known catalog ID formats. IDs that match none of these are rejected before
+// they get near Solr or the ILS.
+var titleIDPatterns = []titleIDPattern{
+	{Name: "sirsi", Regex: regexp.MustCompile(`^u\d+$`)},
+	{Name: "folio", Regex: regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)},
+	{Name: "folio_hrid", Regex: regexp.MustCompile(`^[a-z]{1,3}\d+$`)},
+	{Name: "archivesspace", Regex: regexp.MustCompile(`^aspace_[A-Za-z0-9][A-Za-z0-9._-]*$`)},
+	{Name: "avalon", Regex: regexp.MustCompile(`^avalon_[A-Za-z0-9]+$`)},
+	{Name: "tracksys", Regex: regexp.MustCompile(`^uva-lib:\d+$`)},
+}
+
+// solrSpecialChars are characters with meaning in the solr standard query parser
+const solrSpecialChars = `+-&|!(){}[]^"~*?:\/ `
+
+// validateTitleID trims a title ID and checks that it matches one of the known ID formats. IDs are
+// still escaped everywhere they are used.
+func validateTitleID(rawID string) (string, error) {
+	titleID := strings.TrimSpace(rawID)
+	if titleID == "" {
+		return "", fmt.Errorf("title id is required")
+	}
+	if len(titleID) > maxTitleIDLength {
+		return "", fmt.Errorf("title id is longer than %d characters", maxTitleIDLength)
+	}
+	for _, p := range titleIDPatterns {
+		if p.Regex.MatchString(titleID) {
+			return titleID, nil
+		}
+	}
+	return "", fmt.Errorf("%q is not a valid title id", titleID)
+}
+
+// solrTerm escapes a value so solr treats it as a single term and not query syntax
+func solrTerm(value string) string {
+	var escaped strings.Builder
+	for _, r := range value {
+		if strings.ContainsRune(solrSpecialChars, r) {
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String()
+}
+
+// solrIDQuery is the URL encoded solr query for documents with any of the IDs
+func solrIDQuery(ids []string) string {
+	terms := make([]string, 0, len(ids))
+	for _, id := range ids {
+		terms = append(terms, solrTerm(id))
+	}
+	if len(terms) == 1 {
+		return url.QueryEscape(fmt.Sprintf("id:%s", terms[0]))
+	}
+	return url.QueryEscape(fmt.Sprintf("id:(%s)", strings.Join(terms, " OR ")))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestValidateTitleID(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{"sirsi", "u12345", "u12345", false},
+		{"trimmed", "  u12345\t", "u12345", false},
+		{"folio instance", "0f6e1e2a-3b4c-4d5e-8F90-1a2b3c4d5e6f", "0f6e1e2a-3b4c-4d5e-8F90-1a2b3c4d5e6f", false},
+		{"folio hrid", "in00001", "in00001", false},
+		{"archivesspace", "aspace_0f6e1e2a3b4c", "aspace_0f6e1e2a3b4c", false},
+		{"archivesspace with punctuation", "aspace_mss.12-a_b", "aspace_mss.12-a_b", false},
+		{"avalon", "avalon_x920fw85w", "avalon_x920fw85w", false},
+		{"tracksys", "uva-lib:2528443", "uva-lib:2528443", false},
+		{"longest", "aspace_" + strings.Repeat("a", maxTitleIDLength-7), "aspace_" + strings.Repeat("a", maxTitleIDLength-7), false},
+		{"empty", "", "", true},
+		{"blank", "   ", "", true},
+		{"too long", "aspace_" + strings.Repeat("a", maxTitleIDLength-6), "", true},
+		{"unknown prefix", "hathi_000123", "", true},
+		{"doi", "doi:10.1000/182", "", true},
+		{"sirsi with letters", "u123x", "", true},
+		{"upper case sirsi", "U12345", "", true},
+		{"short uuid", "0f6e1e2a-3b4c-4d5e-8f90-1a2b3c4d5e6", "", true},
+		{"archivesspace without an id", "aspace_", "", true},
+		{"archivesspace starting with punctuation", "aspace_-1", "", true},
+		{"avalon with punctuation", "avalon_x9.20", "", true},
+		{"tracksys without a number", "uva-lib:", "", true},
+		{"solr query", "u1 OR id:*", "", true},
+		{"path traversal", "u1/../admin", "", true},
+		{"null byte", "u123\x00", "", true},
+		{"new line", "u123\nid:*", "", true},
+		{"escape sequence", "u1\x1b[2J", "", true},
+		{"invalid utf-8", "u1\xff", "", true},
+		{"non-ascii digits", "u١٢٣", "", true},
+	}
+	for _, test := range tests {
+		got, err := validateTitleID(test.raw)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: validateTitleID(%q) = %q; want an error", test.name, test.raw, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("%s: validateTitleID(%q) = %q, %v; want %q", test.name, test.raw, got, err, test.want)
+		}
+	}
+}
+
+func TestSolrIDQuery(t *testing.T) {
+	tests := []struct {
+		ids  []string
+		want string
+	}{
+		{[]string{"u12345"}, `id:u12345`},
+		{[]string{"uva-lib:2528443"}, `id:uva\-lib\:2528443`},
+		{[]string{"u1 OR id:*"}, `id:u1\ OR\ id\:\*`},
+		{[]string{`a+b&&c||d!(e){f}[g]^"h"~i*j?k\l/m`}, `id:a\+b\&\&c\|\|d\!\(e\)\{f\}\[g\]\^\"h\"\~i\*j\?k\\l\/m`},
+		{[]string{"u1", "u2", "aspace_x-y"}, `id:(u1 OR u2 OR aspace_x\-y)`},
+	}
+	for _, test := range tests {
+		query, err := url.QueryUnescape(solrIDQuery(test.ids))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if query != test.want {
+			t.Errorf("solrIDQuery(%q) = %s; want %s", test.ids, query, test.want)
+		}
+	}
+	if raw := solrIDQuery([]string{"u1&fq=*:*"}); strings.ContainsAny(raw, "&=") {
+		t.Errorf("solrIDQuery is not URL encoded: %s", raw)
+	}
+}
@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP TABLE IF EXISTS reserve_request_items;
+DROP TABLE IF EXISTS reserve_requests;
+
+COMMIT;
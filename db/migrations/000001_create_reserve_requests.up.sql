@@ -0,0 +1,43 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS reserve_requests (
+   id serial PRIMARY KEY,
+   user_id varchar(255) NOT NULL,
+   requester_name varchar(255) NOT NULL DEFAULT '',
+   requester_email varchar(255) NOT NULL DEFAULT '',
+   on_behalf_of boolean NOT NULL DEFAULT false,
+   instructor_name varchar(255) NOT NULL DEFAULT '',
+   instructor_email varchar(255) NOT NULL DEFAULT '',
+   course varchar(255) NOT NULL DEFAULT '',
+   semester varchar(50) NOT NULL DEFAULT '',
+   library varchar(100) NOT NULL DEFAULT '',
+   period varchar(100) NOT NULL DEFAULT '',
+   lms varchar(100) NOT NULL DEFAULT '',
+   other_lms varchar(255) NOT NULL DEFAULT '',
+   created_at timestamp with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS reserve_requests_user_idx ON reserve_requests (user_id);
+CREATE INDEX IF NOT EXISTS reserve_requests_course_idx ON reserve_requests (instructor_email, course, semester);
+
+CREATE TABLE IF NOT EXISTS reserve_request_items (
+   id serial PRIMARY KEY,
+   request_id integer NOT NULL REFERENCES reserve_requests (id) ON DELETE CASCADE,
+   pool varchar(100) NOT NULL DEFAULT '',
+   catalog_key varchar(100) NOT NULL,
+   is_video boolean NOT NULL DEFAULT false,
+   title text NOT NULL DEFAULT '',
+   author text NOT NULL DEFAULT '',
+   call_number text[] NOT NULL DEFAULT '{}',
+   period varchar(100) NOT NULL DEFAULT '',
+   notes text NOT NULL DEFAULT '',
+   audio_language varchar(100) NOT NULL DEFAULT '',
+   subtitles varchar(50) NOT NULL DEFAULT '',
+   subtitle_language varchar(100) NOT NULL DEFAULT '',
+   availability jsonb NOT NULL DEFAULT '[]'
+);
+
+CREATE INDEX IF NOT EXISTS reserve_request_items_request_idx ON reserve_request_items (request_id);
+CREATE INDEX IF NOT EXISTS reserve_request_items_catalog_key_idx ON reserve_request_items (catalog_key);
+
+COMMIT;
@@ -0,0 +1,13 @@
+BEGIN;
+
+DROP TABLE IF EXISTS reserve_status_events;
+
+DROP INDEX IF EXISTS reserve_requests_status_idx;
+ALTER TABLE reserve_requests DROP COLUMN IF EXISTS status;
+ALTER TABLE reserve_requests DROP COLUMN IF EXISTS updated_at;
+
+ALTER TABLE reserve_request_items DROP COLUMN IF EXISTS status;
+ALTER TABLE reserve_request_items DROP COLUMN IF EXISTS status_note;
+ALTER TABLE reserve_request_items DROP COLUMN IF EXISTS updated_at;
+
+COMMIT;
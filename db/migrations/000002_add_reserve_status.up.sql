@@ -0,0 +1,24 @@
+BEGIN;
+
+ALTER TABLE reserve_requests ADD COLUMN IF NOT EXISTS status varchar(20) NOT NULL DEFAULT 'submitted';
+ALTER TABLE reserve_requests ADD COLUMN IF NOT EXISTS updated_at timestamp with time zone NOT NULL DEFAULT NOW();
+CREATE INDEX IF NOT EXISTS reserve_requests_status_idx ON reserve_requests (status);
+
+ALTER TABLE reserve_request_items ADD COLUMN IF NOT EXISTS status varchar(20) NOT NULL DEFAULT 'submitted';
+ALTER TABLE reserve_request_items ADD COLUMN IF NOT EXISTS status_note text NOT NULL DEFAULT '';
+ALTER TABLE reserve_request_items ADD COLUMN IF NOT EXISTS updated_at timestamp with time zone NOT NULL DEFAULT NOW();
+
+CREATE TABLE IF NOT EXISTS reserve_status_events (
+   id serial PRIMARY KEY,
+   request_id integer NOT NULL REFERENCES reserve_requests (id) ON DELETE CASCADE,
+   item_id integer REFERENCES reserve_request_items (id) ON DELETE CASCADE,
+   from_status varchar(20) NOT NULL DEFAULT '',
+   to_status varchar(20) NOT NULL,
+   note text NOT NULL DEFAULT '',
+   changed_by varchar(255) NOT NULL DEFAULT '',
+   created_at timestamp with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS reserve_status_events_request_idx ON reserve_status_events (request_id);
+
+COMMIT;
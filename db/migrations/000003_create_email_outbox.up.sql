@@ -0,0 +1,24 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS email_outbox (
+   id serial PRIMARY KEY,
+   request_id integer REFERENCES reserve_requests (id) ON DELETE CASCADE,
+   subject text NOT NULL DEFAULT '',
+   from_addr varchar(255) NOT NULL DEFAULT '',
+   reply_to varchar(255) NOT NULL DEFAULT '',
+   to_addrs text[] NOT NULL DEFAULT '{}',
+   cc text[] NOT NULL DEFAULT '{}',
+   body text NOT NULL DEFAULT '',
+   html_body text NOT NULL DEFAULT '',
+   status varchar(20) NOT NULL DEFAULT 'pending',
+   attempts integer NOT NULL DEFAULT 0,
+   last_error text NOT NULL DEFAULT '',
+   next_attempt_at timestamp with time zone NOT NULL DEFAULT NOW(),
+   created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+   sent_at timestamp with time zone
+);
+
+CREATE INDEX IF NOT EXISTS email_outbox_due_idx ON email_outbox (status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS email_outbox_request_idx ON email_outbox (request_id);
+
+COMMIT;
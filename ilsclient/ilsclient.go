@@ -0,0 +1,201 @@
+// Package ilsclient is a typed client for the ILS Connector API
+package ilsclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// StatusClientClosedRequest is the status of an Error for a request canceled by its context
+const StatusClientClosedRequest = 499
+
+// Client is the set of ILS Connector calls used by the availability service. Handlers depend on this
+// interface so they can be tested against a fake.
+type Client interface {
+	// GetAvailability gets the holdings and request options for a title. A 404 Error means the ILS does not know the title.
+	GetAvailability(ctx context.Context, titleID string, jwt string) (*AvailabilityResponse, error)
+	// ValidateReserves checks if titles can be placed on course reserve
+	ValidateReserves(ctx context.Context, titleIDs []string, jwt string) ([]ValidateResult, error)
+	// Version checks that the ILS Connector is up
+	Version(ctx context.Context) error
+}
+
+// Error is a failed ILS Connector request. StatusCode is the HTTP status, or a status describing a
+// transport failure: 408 for timeouts, 503 for refused connections and 499 for canceled requests.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d: %s", e.StatusCode, e.Message)
+}
+
+// decodeError is a successful response with a body that could not be parsed
+type decodeError struct {
+	Message string
+}
+
+func (e *decodeError) Error() string {
+	return fmt.Sprintf("unable to parse response: %s", e.Message)
+}
+
+// Observer is called after every request with the method, elapsed time and error (nil on success)
+type Observer func(method string, elapsed time.Duration, err *Error)
+
+// Connector is the HTTP implementation of Client
+type Connector struct {
+	baseURL    string
+	httpClient *http.Client
+	slowClient *http.Client
+	observer   Observer
+}
+
+// New creates a Connector for the ILS Connector at baseURL. Availability requests, which can take a
+// long time for large titles, use slowClient; everything else uses httpClient.
+func New(baseURL string, httpClient *http.Client, slowClient *http.Client, observer Observer) (*Connector, error) {
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ILS Connector URL %s: %s", baseURL, err.Error())
+	}
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid ILS Connector URL %s: scheme and host are required", baseURL)
+	}
+	return &Connector{baseURL: strings.TrimSuffix(baseURL, "/"), httpClient: httpClient, slowClient: slowClient, observer: observer}, nil
+}
+
+// GetAvailability calls GET /availability/:id. A response that can't be parsed is an empty availability,
+// not an error; the ILS Connector has no holdings for some titles that are found elsewhere (like
+// Special Collections), and the service is not down when that happens.
+func (ils *Connector) GetAvailability(ctx context.Context, titleID string, jwt string) (*AvailabilityResponse, error) {
+	var resp AvailabilityResponse
+	err := ils.do(ctx, ils.slowClient, "GET", ils.endpoint("availability", titleID), jwt, nil, &resp)
+	var decodeErr *decodeError
+	if errors.As(err, &decodeErr) {
+		slog.WarnContext(ctx, "unable to parse ILS Connector availability; using empty availability",
+			"title_id", titleID, "error", decodeErr.Message)
+		return &AvailabilityResponse{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ValidateReserves calls POST /course_reserves/validate
+func (ils *Connector) ValidateReserves(ctx context.Context, titleIDs []string, jwt string) ([]ValidateResult, error) {
+	req := struct {
+		Items []string `json:"items"`
+	}{Items: titleIDs}
+	var resp []ValidateResult
+	err := ils.do(ctx, ils.httpClient, "POST", ils.endpoint("course_reserves", "validate"), jwt, req, &resp)
+	var decodeErr *decodeError
+	if errors.As(err, &decodeErr) {
+		slog.ErrorContext(ctx, "unable to parse ILS Connector reserve validation", "error", decodeErr.Message)
+		return nil, &Error{StatusCode: http.StatusInternalServerError, Message: decodeErr.Message}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Version calls GET /version
+func (ils *Connector) Version(ctx context.Context) error {
+	return ils.do(ctx, ils.httpClient, "GET", ils.endpoint("version"), "", nil, nil)
+}
+
+// endpoint builds an API URL from path segments; each segment is escaped
+func (ils *Connector) endpoint(segments ...string) string {
+	escaped := make([]string, 0, len(segments))
+	for _, s := range segments {
+		escaped = append(escaped, url.PathEscape(s))
+	}
+	return fmt.Sprintf("%s/%s", ils.baseURL, strings.Join(escaped, "/"))
+}
+
+// do sends a request and decodes a JSON response into out, if it is not nil. An unparseable response
+// is a decodeError; callers decide if that is a failure.
+func (ils *Connector) do(ctx context.Context, httpClient *http.Client, method string, apiURL string, jwt string, body interface{}, out interface{}) error {
+	var payload io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return &Error{StatusCode: http.StatusBadRequest, Message: err.Error()}
+		}
+		payload = bytes.NewBuffer(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, apiURL, payload)
+	if err != nil {
+		return &Error{StatusCode: http.StatusBadRequest, Message: err.Error()}
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if jwt != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", jwt))
+	}
+
+	slog.InfoContext(ctx, "ILS Connector request", "method", method, "url", apiURL, "timeout_sec", httpClient.Timeout.Seconds())
+	startTime := time.Now()
+	rawResp, rawErr := httpClient.Do(req)
+	respBytes, reqErr := readResponse(apiURL, rawResp, rawErr)
+	elapsed := time.Since(startTime)
+	elapsedMS := int64(elapsed / time.Millisecond)
+	if ils.observer != nil {
+		ils.observer(method, elapsed, reqErr)
+	}
+	if reqErr != nil {
+		level := slog.LevelError
+		if reqErr.StatusCode == http.StatusNotFound {
+			level = slog.LevelInfo
+		}
+		slog.Log(ctx, level, "ILS Connector response", "method", method, "url", apiURL,
+			"status", reqErr.StatusCode, "error", reqErr.Message, "elapsed_ms", elapsedMS)
+		return reqErr
+	}
+	slog.InfoContext(ctx, "ILS Connector response", "method", method, "url", apiURL, "status", http.StatusOK, "elapsed_ms", elapsedMS)
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(respBytes, out); err != nil {
+		return &decodeError{Message: err.Error()}
+	}
+	return nil
+}
+
+// readResponse reads the body of a successful response, or converts a failure into an Error
+func readResponse(logURL string, resp *http.Response, err error) ([]byte, *Error) {
+	if err != nil {
+		status := http.StatusBadRequest
+		errMsg := err.Error()
+		if errors.Is(err, context.Canceled) {
+			status = StatusClientClosedRequest
+			errMsg = fmt.Sprintf("%s was canceled", logURL)
+		} else if strings.Contains(err.Error(), "Timeout") || errors.Is(err, context.DeadlineExceeded) {
+			status = http.StatusRequestTimeout
+			errMsg = fmt.Sprintf("%s timed out", logURL)
+		} else if strings.Contains(err.Error(), "connection refused") {
+			status = http.StatusServiceUnavailable
+			errMsg = fmt.Sprintf("%s refused connection", logURL)
+		}
+		return nil, &Error{StatusCode: status, Message: errMsg}
+	}
+
+	defer resp.Body.Close()
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, &Error{StatusCode: resp.StatusCode, Message: string(bodyBytes)}
+	}
+	return bodyBytes, nil
+}
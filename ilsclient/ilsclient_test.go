@@ -0,0 +1,76 @@
+package ilsclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestConnector(t *testing.T, handler http.HandlerFunc) *Connector {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	ils, err := New(server.URL, server.Client(), server.Client(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ils
+}
+
+func TestGetAvailability(t *testing.T) {
+	ils := newTestConnector(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/availability/u1%2F2" || r.Header.Get("Authorization") != "Bearer jwt" {
+			http.Error(w, "unexpected request "+r.URL.EscapedPath(), http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"availability":{"title_id":"u1/2","items":[{"barcode":"X1","on_shelf":true}]}}`))
+	})
+	resp, err := ils.GetAvailability(context.Background(), "u1/2", "jwt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Availability.TitleID != "u1/2" || len(resp.Availability.Items) != 1 || resp.Availability.Items[0].OnShelf == false {
+		t.Errorf("availability = %+v", resp.Availability)
+	}
+}
+
+func TestGetAvailabilityUnparseable(t *testing.T) {
+	for name, body := range map[string]string{"html": "<html>no holdings</html>", "empty": "", "wrong shape": `{"availability":[]}`} {
+		ils := newTestConnector(t, func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(body)) })
+		resp, err := ils.GetAvailability(context.Background(), "u1", "jwt")
+		if err != nil {
+			t.Errorf("%s: err = %v; want an empty availability", name, err)
+			continue
+		}
+		if resp.Availability.TitleID != "" || len(resp.Availability.Items) != 0 {
+			t.Errorf("%s: availability = %+v; want empty", name, resp.Availability)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	status := http.StatusNotFound
+	ils := newTestConnector(t, func(w http.ResponseWriter, r *http.Request) {
+		if status == http.StatusOK {
+			w.Write([]byte("not json"))
+			return
+		}
+		http.Error(w, "failed", status)
+	})
+	var ilsErr *Error
+	if _, err := ils.GetAvailability(context.Background(), "u1", ""); errors.As(err, &ilsErr) == false || ilsErr.StatusCode != http.StatusNotFound {
+		t.Errorf("GetAvailability err = %v; want 404", err)
+	}
+	status = http.StatusBadGateway
+	if _, err := ils.GetAvailability(context.Background(), "u1", ""); errors.As(err, &ilsErr) == false || ilsErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("GetAvailability err = %v; want 502", err)
+	}
+
+	// reserve validation has no lenient fallback
+	status = http.StatusOK
+	if _, err := ils.ValidateReserves(context.Background(), []string{"u1"}, ""); errors.As(err, &ilsErr) == false || ilsErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("ValidateReserves err = %v; want 500", err)
+	}
+}
@@ -0,0 +1,73 @@
+package ilsclient
+
+// AvailabilityResponse is the response from GET /availability/:id. It is the one model of ILS
+// availability; everything that reads ILS availability decodes it with this type.
+type AvailabilityResponse struct {
+	Availability Availability `json:"availability"`
+}
+
+// Availability is the ILS holdings and request options for a title
+type Availability struct {
+	TitleID        string            `json:"title_id"`
+	Display        map[string]string `json:"display"`
+	Items          []Item            `json:"items"`
+	RequestOptions []RequestOption   `json:"request_options"`
+	BoundWith      []BoundWithItem   `json:"bound_with"`
+}
+
+// Item is a single copy of a title
+type Item struct {
+	Barcode           string `json:"barcode"`
+	OnShelf           bool   `json:"on_shelf"`
+	Unavailable       bool   `json:"unavailable"`
+	Notice            string `json:"notice"`
+	Library           string `json:"library"`
+	LibraryID         string `json:"library_id"`
+	CurrentLocation   string `json:"current_location"`
+	CurrentLocationID string `json:"current_location_id"`
+	HomeLocationID    string `json:"home_location_id"`
+	CallNumber        string `json:"call_number"`
+	Volume            string `json:"volume"`
+	SCNotes           string `json:"special_collections_location"`
+	DueDate           string `json:"due_date,omitempty"`
+	ExpectedAvailable string `json:"expected_available,omitempty"`
+	HoldCount         int    `json:"hold_count,omitempty"`
+}
+
+// RequestOption is a kind of request a user can make for a title
+type RequestOption struct {
+	Type             string       `json:"type"`
+	Label            string       `json:"button_label"`
+	Description      string       `json:"description"`
+	CreateURL        string       `json:"create_url"`
+	SignInRequired   bool         `json:"sign_in_required"`
+	StreamingReserve bool         `json:"streaming_reserve"`
+	ItemOptions      []ItemOption `json:"item_options"`
+}
+
+// ItemOption is an item that can be selected for a request option
+type ItemOption struct {
+	Label      string `json:"label"`
+	Barcode    string `json:"barcode"`
+	SCNotes    string `json:"notes"`
+	Library    string `json:"library"`
+	Location   string `json:"location"`
+	LocationID string `json:"location_id"`
+	Notice     string `json:"notice"`
+}
+
+// BoundWithItem is a related title bound with this one
+type BoundWithItem struct {
+	IsParent   bool   `json:"is_parent"`
+	TitleID    string `json:"title_id"`
+	CallNumber string `json:"call_number"`
+	Title      string `json:"title"`
+	Author     string `json:"author"`
+}
+
+// ValidateResult is the course reserve eligibility of one title from POST /course_reserves/validate
+type ValidateResult struct {
+	ID      string `json:"id"`
+	Reserve bool   `json:"reserve"`
+	IsVideo bool   `json:"is_video"`
+}
@@ -1,6 +1,8 @@
 # set blank options variables
 SMTP_USER_OPT=""
 SMTP_PASS_OPT=""
+ILS_BACKEND_OPT=""
+CACHE_OPT=""
 
 # SMTP username
 if [ -n "${V4_SMPT_USER}" ]; then
@@ -12,6 +14,16 @@ if [ -n "${V4_SMPT_PASS}" ]; then
    SMTP_PASS_OPT="-smtppass ${V4_SMPT_PASS}"
 fi
 
+# ILS backend; the ILS Connector unless FOLIO is configured
+if [ -n "${V4_FOLIO_URL}" ]; then
+   ILS_BACKEND_OPT="-ilsbackend folio -folio ${V4_FOLIO_URL} -foliotenant ${V4_FOLIO_TENANT} -foliouser ${V4_FOLIO_USER} -foliopass ${V4_FOLIO_PASS}"
+fi
+
+# shared redis response cache
+if [ -n "${V4_REDIS_URL}" ]; then
+   CACHE_OPT="-cache redis -redis ${V4_REDIS_URL}"
+fi
+
 # run application
 cd bin; ./v4availability \
    -virgo ${V4_URL} \
@@ -25,8 +37,15 @@ cd bin; ./v4availability \
    -smtpsender ${V4_SMPT_SENDER} \
    -cremail ${V4_CR_EMAIL} \
    -lawemail ${V4_LAW_CR_EMAIL} \
+   -dbhost ${V4_DB_HOST} \
+   -dbport ${V4_DB_PORT} \
+   -dbname ${V4_DB_NAME} \
+   -dbuser ${V4_DB_USER} \
+   -dbpass ${V4_DB_PASSWORD} \
    ${SMTP_USER_OPT} \
-   ${SMTP_PASS_OPT}
+   ${SMTP_PASS_OPT} \
+   ${ILS_BACKEND_OPT} \
+   ${CACHE_OPT}
 
 #
 # end of file